@@ -0,0 +1,465 @@
+package mirageecs
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	DefaultACMEDirectoryURL = acme.LetsEncryptURL
+	DefaultACMERenewBefore  = 30 * 24 * time.Hour
+	DefaultACMECache        = "./acme"
+
+	acmeCheckInterval  = 12 * time.Hour
+	acmeRetryInterval  = 5 * time.Minute
+	acmeLockTTL        = 10 * time.Minute
+	acmeAccountKeyName = "acme_account+key"
+)
+
+var errACMELocked = errors.New("another mirage-ecs is obtaining the certificate")
+
+type ACMECfg struct {
+	DirectoryURL string        `yaml:"directory_url"`
+	Email        string        `yaml:"email"`
+	HostedZoneID string        `yaml:"hosted_zone_id"`
+	Cache        string        `yaml:"cache"`
+	RenewBefore  time.Duration `yaml:"renew_before"`
+	CACert       string        `yaml:"ca_cert"` // CA certificate of the ACME server (for testing with Pebble)
+}
+
+func (c *ACMECfg) fillDefaults() {
+	if c.DirectoryURL == "" {
+		c.DirectoryURL = DefaultACMEDirectoryURL
+	}
+	if c.Cache == "" {
+		c.Cache = DefaultACMECache
+	}
+	if c.RenewBefore == 0 {
+		c.RenewBefore = DefaultACMERenewBefore
+	}
+}
+
+func (c *ACMECfg) newCache(awscfg *aws.Config) (autocert.Cache, error) {
+	if !strings.HasPrefix(c.Cache, "s3://") {
+		return autocert.DirCache(c.Cache), nil
+	}
+	u, err := url.Parse(c.Cache)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &s3CertCache{
+		svc:    s3.NewFromConfig(*awscfg),
+		bucket: u.Host,
+		prefix: prefix,
+	}, nil
+}
+
+// acmeNames returns names of the certificate obtained by ACME.
+func (c *Config) acmeNames() []string {
+	wildcard := "*" + c.Host.ReverseProxySuffix
+	names := []string{wildcard}
+	webapi := strings.ToLower(c.Host.WebApi)
+	if label, ok := strings.CutSuffix(webapi, c.Host.ReverseProxySuffix); ok && !strings.Contains(label, ".") {
+		// covered by the wildcard
+		return names
+	}
+	return append(names, webapi)
+}
+
+// s3CertCache implements autocert.Cache backed by S3.
+// mirage-ecs replicas that share the cache share certificates.
+type s3CertCache struct {
+	svc    *s3.Client
+	bucket string
+	prefix string
+}
+
+func (c *s3CertCache) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := c.svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(c.prefix + key),
+	})
+	if err != nil {
+		var nsk *s3Types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, autocert.ErrCacheMiss
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+func (c *s3CertCache) Put(ctx context.Context, key string, data []byte) error {
+	_, err := c.svc.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(c.prefix + key),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+func (c *s3CertCache) Delete(ctx context.Context, key string) error {
+	_, err := c.svc.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(c.prefix + key),
+	})
+	return err
+}
+
+// ACMEDNSProvider presents TXT records for ACME DNS-01 challenges.
+type ACMEDNSProvider interface {
+	Present(ctx context.Context, fqdn, value string) error
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+type route53DNSProvider struct {
+	r53 *Route53
+}
+
+func (p *route53DNSProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.r53.ChangeTXTRecord(ctx, types.ChangeActionUpsert, fqdn, value)
+}
+
+func (p *route53DNSProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.r53.ChangeTXTRecord(ctx, types.ChangeActionDelete, fqdn, value)
+}
+
+// ACMEManager obtains and renews a certificate for the webapi host and *.{ReverseProxySuffix}
+// by ACME DNS-01 challenges, and puts it into the CertificateStore.
+type ACMEManager struct {
+	cfg        *ACMECfg
+	names      []string
+	cache      autocert.Cache
+	dns        ACMEDNSProvider
+	store      *CertificateStore
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	client *acme.Client
+}
+
+func NewACMEManager(ctx context.Context, cfg *Config) (*ACMEManager, error) {
+	zoneID := cfg.ACME.HostedZoneID
+	if zoneID == "" {
+		zoneID = cfg.Link.HostedZoneID
+	}
+	if zoneID == "" {
+		return nil, fmt.Errorf("acme.hosted_zone_id or link.hosted_zone_id is required")
+	}
+	r53 := newRoute53(ctx, cfg, zoneID)
+	if r53 == nil {
+		return nil, fmt.Errorf("failed to initialize route53 for hosted zone %s", zoneID)
+	}
+	cache, err := cfg.ACME.newCache(cfg.awscfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid acme.cache %s: %w", cfg.ACME.Cache, err)
+	}
+	return newACMEManager(cfg.ACME, cfg.acmeNames(), cache, &route53DNSProvider{r53: r53}, cfg.Certificates())
+}
+
+func newACMEManager(cfg *ACMECfg, names []string, cache autocert.Cache, dns ACMEDNSProvider, store *CertificateStore) (*ACMEManager, error) {
+	cfg.fillDefaults()
+	m := &ACMEManager{
+		cfg:        cfg,
+		names:      names,
+		cache:      cache,
+		dns:        dns,
+		store:      store,
+		httpClient: http.DefaultClient,
+	}
+	if cfg.CACert != "" {
+		b, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read acme.ca_cert: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in acme.ca_cert %s", cfg.CACert)
+		}
+		tp := http.DefaultTransport.(*http.Transport).Clone()
+		tp.TLSClientConfig = &tls.Config{RootCAs: pool}
+		m.httpClient = &http.Client{Transport: tp}
+	}
+	return m, nil
+}
+
+func (m *ACMEManager) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	slog.Info(f("acme: managing a certificate for %v", m.names))
+	for {
+		next := acmeCheckInterval
+		if err := m.Sync(ctx); err != nil {
+			slog.Error(f("acme: %s. retry after %s", err, acmeRetryInterval))
+			next = acmeRetryInterval
+		}
+		select {
+		case <-time.After(next):
+		case <-ctx.Done():
+			slog.Warn("ACMEManager.Run() is done")
+			return
+		}
+	}
+}
+
+// Sync loads the certificate from the cache, and obtains a new one when it is missing or expiring.
+func (m *ACMEManager) Sync(ctx context.Context) error {
+	cert, err := m.loadCertificate(ctx)
+	if err != nil && !errors.Is(err, autocert.ErrCacheMiss) {
+		return err
+	}
+	if cert != nil {
+		if err := m.store.Add(cert); err != nil {
+			return err
+		}
+		if !m.needsRenewal(cert) {
+			slog.Debug(f("acme: certificate for %v is valid until %s", m.names, cert.Leaf.NotAfter))
+			return nil
+		}
+		slog.Info(f("acme: certificate for %v expires at %s. renewing", m.names, cert.Leaf.NotAfter))
+	} else {
+		slog.Info(f("acme: certificate for %v is not found in the cache. obtaining", m.names))
+	}
+
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.unlock(ctx)
+	cert, err = m.obtain(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to obtain certificate for %v: %w", m.names, err)
+	}
+	slog.Info(f("acme: obtained certificate for %v (expire at %s)", m.names, cert.Leaf.NotAfter))
+	return m.store.Add(cert)
+}
+
+func (m *ACMEManager) certKey() string {
+	return strings.ReplaceAll(m.names[0], "*", "_wildcard")
+}
+
+func (m *ACMEManager) loadCertificate(ctx context.Context) (*tls.Certificate, error) {
+	data, err := m.cache.Get(ctx, m.certKey())
+	if err != nil {
+		return nil, err
+	}
+	// data contains a private key and a certificate chain
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate in the cache %s: %w", m.certKey(), err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func (m *ACMEManager) needsRenewal(cert *tls.Certificate) bool {
+	if time.Until(cert.Leaf.NotAfter) < m.cfg.RenewBefore {
+		return true
+	}
+	for _, name := range m.names {
+		if cert.Leaf.VerifyHostname(strings.Replace(name, "*", "x", 1)) != nil {
+			slog.Info(f("acme: certificate does not cover %s", name))
+			return true
+		}
+	}
+	return false
+}
+
+// lock prevents that multiple mirage-ecs replicas obtain certificates at the same time.
+// This is best effort because the cache does not support atomic operations.
+func (m *ACMEManager) lock(ctx context.Context) error {
+	key := m.certKey() + ".lock"
+	if b, err := m.cache.Get(ctx, key); err == nil {
+		if t, err := time.Parse(time.RFC3339, string(b)); err == nil && time.Since(t) < acmeLockTTL {
+			return errACMELocked
+		}
+	} else if !errors.Is(err, autocert.ErrCacheMiss) {
+		return err
+	}
+	return m.cache.Put(ctx, key, []byte(time.Now().Format(time.RFC3339)))
+}
+
+func (m *ACMEManager) unlock(ctx context.Context) {
+	if err := m.cache.Delete(ctx, m.certKey()+".lock"); err != nil {
+		slog.Warn(f("acme: failed to unlock: %s", err))
+	}
+}
+
+func (m *ACMEManager) acmeClient(ctx context.Context) (*acme.Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.client != nil {
+		return m.client, nil
+	}
+	key, err := m.accountKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: m.cfg.DirectoryURL,
+		HTTPClient:   m.httpClient,
+		UserAgent:    "mirage-ecs/" + Version,
+	}
+	acct := &acme.Account{}
+	if m.cfg.Email != "" {
+		acct.Contact = []string{"mailto:" + m.cfg.Email}
+	}
+	if _, err := client.Register(ctx, acct, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("failed to register acme account: %w", err)
+	}
+	m.client = client
+	return client, nil
+}
+
+func (m *ACMEManager) accountKey(ctx context.Context) (crypto.Signer, error) {
+	data, err := m.cache.Get(ctx, acmeAccountKeyName)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("invalid acme account key in the cache")
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	} else if !errors.Is(err, autocert.ErrCacheMiss) {
+		return nil, err
+	}
+	slog.Info("acme: generating a new account key")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	b, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.cache.Put(ctx, acmeAccountKeyName, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b})); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (m *ACMEManager) obtain(ctx context.Context) (*tls.Certificate, error) {
+	client, err := m.acmeClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(m.names...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to authorize order: %w", err)
+	}
+	// authorize sequentially because the wildcard and the apex share the same TXT record name
+	for _, u := range order.AuthzURLs {
+		if err := m.authorize(ctx, client, u); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, fmt.Errorf("failed to wait order: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: m.names}, key)
+	if err != nil {
+		return nil, err
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		// CreateOrderCert cannot wait for the order when the CA finalizes it asynchronously,
+		// because the response of finalize has no Location header. Wait by the order URL.
+		o, werr := client.WaitOrder(ctx, order.URI)
+		if werr != nil || o.Status != acme.StatusValid {
+			return nil, fmt.Errorf("failed to create certificate: %w", err)
+		}
+		if der, err = client.FetchCert(ctx, o.CertURL, true); err != nil {
+			return nil, fmt.Errorf("failed to fetch certificate: %w", err)
+		}
+	}
+
+	// same format as autocert: a private key followed by a certificate chain
+	buf := &bytes.Buffer{}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	pem.Encode(buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: kb})
+	for _, b := range der {
+		pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: b})
+	}
+	if err := m.cache.Put(ctx, m.certKey(), buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to put certificate to the cache: %w", err)
+	}
+	return m.loadCertificate(ctx)
+}
+
+func (m *ACMEManager) authorize(ctx context.Context, client *acme.Client, u string) error {
+	authz, err := client.GetAuthorization(ctx, u)
+	if err != nil {
+		return fmt.Errorf("failed to get authorization: %w", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("no dns-01 challenge for %s", authz.Identifier.Value)
+	}
+	value, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	fqdn := "_acme-challenge." + strings.TrimPrefix(authz.Identifier.Value, "*.") + "."
+	slog.Info(f("acme: presenting dns-01 challenge %s", fqdn))
+	if err := m.dns.Present(ctx, fqdn, value); err != nil {
+		return fmt.Errorf("failed to present %s: %w", fqdn, err)
+	}
+	defer func() {
+		if err := m.dns.CleanUp(ctx, fqdn, value); err != nil {
+			slog.Warn(f("acme: failed to clean up %s: %s", fqdn, err))
+		}
+	}()
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("failed to accept challenge for %s: %w", authz.Identifier.Value, err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("failed to authorize %s: %w", authz.Identifier.Value, err)
+	}
+	return nil
+}
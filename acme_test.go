@@ -0,0 +1,172 @@
+package mirageecs_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func putTestCertificateToCache(t *testing.T, cache autocert.Cache, key string, names ...string) {
+	t.Helper()
+	certFile, keyFile := writeTestCertificate(t, t.TempDir(), "cached", names...)
+	certPEM, _ := os.ReadFile(certFile)
+	keyPEM, _ := os.ReadFile(keyFile)
+	if err := cache.Put(context.Background(), key, append(keyPEM, certPEM...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type nopDNSProvider struct{}
+
+func (p nopDNSProvider) Present(ctx context.Context, fqdn, value string) error {
+	return fmt.Errorf("must not be called")
+}
+
+func (p nopDNSProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return nil
+}
+
+func TestACMEManagerLoadFromCache(t *testing.T) {
+	ctx := context.Background()
+	cache := autocert.DirCache(t.TempDir())
+	putTestCertificateToCache(t, cache, "_wildcard.dev.example.net", "*.dev.example.net")
+
+	store := mirageecs.NewCertificateStore()
+	m, err := mirageecs.NewACMEManagerForTest(
+		&mirageecs.ACMECfg{
+			DirectoryURL: "http://127.0.0.1:1/directory", // must not be accessed
+			RenewBefore:  time.Minute,
+		},
+		[]string{"*.dev.example.net"}, cache, nopDNSProvider{}, store,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !store.Has("foo.dev.example.net") {
+		t.Error("certificate is not loaded from the cache")
+	}
+}
+
+func TestACMEManagerLocked(t *testing.T) {
+	ctx := context.Background()
+	cache := autocert.DirCache(t.TempDir())
+	// expires in an hour, so it needs renewal
+	putTestCertificateToCache(t, cache, "_wildcard.dev.example.net", "*.dev.example.net")
+	// another replica is obtaining
+	cache.Put(ctx, "_wildcard.dev.example.net.lock", []byte(time.Now().Format(time.RFC3339)))
+
+	store := mirageecs.NewCertificateStore()
+	m, err := mirageecs.NewACMEManagerForTest(
+		&mirageecs.ACMECfg{
+			DirectoryURL: "http://127.0.0.1:1/directory",
+			RenewBefore:  24 * time.Hour,
+		},
+		[]string{"*.dev.example.net"}, cache, nopDNSProvider{}, store,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Sync(ctx); err == nil {
+		t.Error("Sync must fail while locked by another replica")
+	}
+	if !store.Has("foo.dev.example.net") {
+		t.Error("expiring certificate should be used until renewed")
+	}
+}
+
+// challtestsrvProvider presents TXT records by pebble-challtestsrv management API.
+type challtestsrvProvider struct {
+	url string
+}
+
+func (p *challtestsrvProvider) post(path string, body map[string]string) error {
+	b, _ := json.Marshal(body)
+	res, err := http.Post(p.url+path, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", path, res.StatusCode)
+	}
+	return nil
+}
+
+func (p *challtestsrvProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.post("/set-txt", map[string]string{"host": fqdn, "value": value})
+}
+
+func (p *challtestsrvProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.post("/clear-txt", map[string]string{"host": fqdn})
+}
+
+// TestACMEManagerPebble obtains a certificate from Pebble, answering DNS-01 challenges by pebble-challtestsrv.
+// It is skipped unless PEBBLE_URL is set.
+//
+//	pebble -config test/config/pebble-config.json -dnsserver 127.0.0.1:8053
+//	pebble-challtestsrv -defaultIPv4 127.0.0.1
+//	PEBBLE_URL=https://127.0.0.1:14000/dir \
+//	PEBBLE_CA_CERT=test/certs/pebble.minica.pem \
+//	CHALLTESTSRV_URL=http://127.0.0.1:8055 go test -run Pebble
+func TestACMEManagerPebble(t *testing.T) {
+	dirURL := os.Getenv("PEBBLE_URL")
+	if dirURL == "" {
+		t.Skip("PEBBLE_URL is not set")
+	}
+	challtestsrvURL := os.Getenv("CHALLTESTSRV_URL")
+	if challtestsrvURL == "" {
+		challtestsrvURL = "http://127.0.0.1:8055"
+	}
+	ctx := context.Background()
+	cache := autocert.DirCache(t.TempDir())
+	newManager := func(store *mirageecs.CertificateStore) *mirageecs.ACMEManager {
+		t.Helper()
+		m, err := mirageecs.NewACMEManagerForTest(
+			&mirageecs.ACMECfg{
+				DirectoryURL: dirURL,
+				CACert:       os.Getenv("PEBBLE_CA_CERT"),
+				Email:        "mirage@example.com",
+			},
+			[]string{"*.dev.example.net", "mirage.example.net"},
+			cache,
+			&challtestsrvProvider{url: challtestsrvURL},
+			store,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	store := mirageecs.NewCertificateStore()
+	if err := newManager(store).Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"foo.dev.example.net", "mirage.example.net"} {
+		if !store.Has(name) {
+			t.Errorf("certificate for %s is not obtained", name)
+		}
+	}
+
+	// another replica loads the certificate from the shared cache without ordering
+	store = mirageecs.NewCertificateStore()
+	m := newManager(store)
+	if err := m.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !store.Has("foo.dev.example.net") {
+		t.Error("certificate is not loaded from the cache")
+	}
+}
@@ -17,68 +17,103 @@ type Auth struct {
 	Basic        *AuthMethodBasic    `yaml:"basic"`
 	Token        *AuthMethodToken    `yaml:"token"`
 	AmznOIDC     *AuthMethodAmznOIDC `yaml:"amzn_oidc"`
+	OIDC         *AuthMethodOIDC     `yaml:"oidc"`
 	CookieSecret string              `yaml:"cookie_secret"`
+	Roles        *RolesCfg           `yaml:"roles"`
 
 	jwtParser  *jwt.Parser
 	jwtKeyFunc func(*jwt.Token) (interface{}, error)
 	once       sync.Once
 }
 
-type Authorizer func(req *http.Request, res http.ResponseWriter) (bool, error)
+// Identity is an authenticated client of mirage-ecs.
+type Identity struct {
+	Method string `json:"method"` // token, basic, amzn_oidc, oidc, none or system
+	Name   string `json:"name"`
+	Role   string `json:"role"` // viewer, launcher or admin
+	// Scopes limit the allowed actions of named tokens. nil means no limits.
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+func (i *Identity) String() string {
+	if i == nil {
+		return ""
+	}
+	return i.Method + ":" + i.Name
+}
+
+// AnonymousIdentity is an identity when auth is not configured.
+var AnonymousIdentity = &Identity{Method: "none", Name: "anonymous", Role: RoleAdmin}
 
-func (a *Auth) ByBasic(req *http.Request, res http.ResponseWriter) (bool, error) {
+// SystemIdentity is an identity of operations by mirage-ecs itself.
+var SystemIdentity = &Identity{Method: "system", Name: "mirage-ecs", Role: RoleAdmin}
+
+// Authorizer returns an Identity when the request is authorized, or nil if not.
+type Authorizer func(req *http.Request, res http.ResponseWriter) (*Identity, error)
+
+func (a *Auth) ByBasic(req *http.Request, res http.ResponseWriter) (*Identity, error) {
 	if a == nil || a.Basic == nil {
-		return false, nil
+		return nil, nil
 	}
 	if ok := a.Basic.Match(req.Header); ok {
 		slog.Debug("basic auth succeeded")
-		return ok, nil
+		return &Identity{Method: "basic", Name: a.Basic.Username}, nil
 	} else {
 		slog.Debug("basic auth failed. set WWW-Authenticate header")
 		res.Header().Set("WWW-Authenticate", "Basic realm=\"Restricted\"")
 	}
-	return false, nil
+	return nil, nil
 }
 
-func (a *Auth) ByToken(req *http.Request, res http.ResponseWriter) (bool, error) {
+func (a *Auth) ByToken(req *http.Request, res http.ResponseWriter) (*Identity, error) {
 	if a == nil || a.Token == nil {
-		return false, nil
+		return nil, nil
+	}
+	if t := a.Token.MatchNamed(req, time.Now()); t != nil {
+		id := &Identity{Method: "token", Name: t.Name}
+		if len(t.Scopes) > 0 {
+			id.Scopes = t.Scopes
+		}
+		return id, nil
 	}
 	if ok := a.Token.Match(req.Header); ok {
-		slog.Debug("token auth succeeded")
-		return ok, nil
+		slog.Info(f("token %s is used for %s %s from %s", a.Token.Header, req.Method, req.URL.Path, a.Token.clientIP(req)))
+		return &Identity{Method: "token", Name: a.Token.Header}, nil
 	}
 	slog.Debug("token auth failed")
-	return false, nil
+	return nil, nil
 }
 
-func (a *Auth) ByAmznOIDC(req *http.Request, res http.ResponseWriter) (bool, error) {
+func (a *Auth) ByAmznOIDC(req *http.Request, res http.ResponseWriter) (*Identity, error) {
 	if a == nil || a.AmznOIDC == nil {
-		return false, nil
+		return nil, nil
 	}
-	if ok, err := a.AmznOIDC.Match(req.Header); err != nil {
-		return false, err
-	} else if ok {
+	if name, err := a.AmznOIDC.Match(req.Header); err != nil {
+		return nil, err
+	} else if name != "" {
 		slog.Debug("amzn_oidc auth succeeded")
-		return true, nil
+		return &Identity{Method: "amzn_oidc", Name: name}, nil
 	}
 	slog.Debug("amzn_oidc auth failed")
-	return false, nil
+	return nil, nil
 }
 
-func (a *Auth) Do(req *http.Request, res http.ResponseWriter, runs ...Authorizer) (bool, error) {
+// Do runs authorizers in order, and returns the identity of the first succeeded one with its role.
+// It returns nil if all authorizers failed.
+func (a *Auth) Do(req *http.Request, res http.ResponseWriter, runs ...Authorizer) (*Identity, error) {
 	if a == nil {
 		// no auth
-		return true, nil
+		return AnonymousIdentity, nil
 	}
 	for _, run := range runs {
-		if ok, err := run(req, res); err != nil {
-			return false, fmt.Errorf("authorizer %v errored: %w", run, err)
-		} else if ok {
-			return true, nil
+		if id, err := run(req, res); err != nil {
+			return nil, fmt.Errorf("authorizer %v errored: %w", run, err)
+		} else if id != nil {
+			id.Role = a.Roles.roleOf(id)
+			return id, nil
 		}
 	}
-	return false, nil
+	return nil, nil
 }
 
 func (a *Auth) NewAuthCookie(expire time.Duration, domain string) (*http.Cookie, error) {
@@ -166,8 +201,11 @@ func (b *AuthMethodBasic) Match(h http.Header) bool {
 }
 
 type AuthMethodToken struct {
-	Token  string `yaml:"token"`
-	Header string `yaml:"header"`
+	Token  string        `yaml:"token"`
+	Header string        `yaml:"header"`
+	Tokens []*NamedToken `yaml:"tokens"`
+	// TrustedProxies are IP addresses or CIDRs of proxies (e.g. load balancers) whose X-Forwarded-For is trusted.
+	TrustedProxies []string `yaml:"trusted_proxies"`
 }
 
 func (b *AuthMethodToken) Match(h http.Header) bool {
@@ -192,19 +230,23 @@ type AuthMethodAmznOIDC struct {
 	Matchers []*ClaimMatcher `yaml:"matchers"`
 }
 
-func (a *AuthMethodAmznOIDC) Match(h http.Header) (bool, error) {
+// Match validates x-amzn-oidc-data header and returns the claim value when it matches.
+func (a *AuthMethodAmznOIDC) Match(h http.Header) (string, error) {
 	if a == nil {
-		return false, nil
+		return "", nil
 	}
 	if a.Claim == "" {
-		return false, nil
+		return "", nil
 	}
 	slog.Debug(f("auth amzn_oidc comparing %s with %s", a.Claim, h.Get("x-amzn-oidc-data")))
 	claims, err := validator.Validate(h.Get("x-amzn-oidc-data"))
 	if err != nil {
-		return false, fmt.Errorf("failed to validate x-amzn-oidc-data: %s", err)
+		return "", fmt.Errorf("failed to validate x-amzn-oidc-data: %s", err)
+	}
+	if !a.MatchClaims(claims) {
+		return "", nil
 	}
-	return a.MatchClaims(claims), nil
+	return claims[a.Claim].(string), nil
 }
 
 func (a *AuthMethodAmznOIDC) MatchClaims(claims map[string]interface{}) bool {
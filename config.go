@@ -15,6 +15,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -44,11 +45,34 @@ type Config struct {
 	ECS       ECSCfg     `yaml:"ecs"`
 	Link      Link       `yaml:"link"`
 	Auth      *Auth      `yaml:"auth"`
-
-	compatV1  bool
-	localMode bool
-	awscfg    *aws.Config
-	cleanups  []func() error
+	TLS       TLSCfg     `yaml:"tls"`
+	ACME      *ACMECfg   `yaml:"acme"`
+	History   HistoryCfg `yaml:"history"`
+	Purge     *PurgeCfg  `yaml:"purge"`
+
+	ScaleToZero   *ScaleToZeroCfg   `yaml:"scale_to_zero"`
+	Notifications *NotificationsCfg `yaml:"notifications"`
+
+	Presets     []*Preset `yaml:"presets"`
+	PresetStore string    `yaml:"preset_store"`
+
+	TaskOverrides *TaskOverridesCfg `yaml:"task_overrides"`
+	Secrets       *SecretsCfg       `yaml:"secrets"`
+	Redaction     *RedactionCfg     `yaml:"redaction"`
+	Logs          *LogsCfg          `yaml:"logs"`
+	TaskCache     *TaskCacheCfg     `yaml:"task_cache"`
+
+	compatV1     bool
+	localMode    bool
+	awscfg       *aws.Config
+	cleanups     []func() error
+	certificates *CertificateStore
+	history      HistoryStore
+	events       *EventBus
+	presets      *PresetRegistry
+	secrets      secretStore
+	redactor     *Redactor
+	eventsOnce   sync.Once
 }
 
 type ECSCfg struct {
@@ -59,6 +83,7 @@ type ECSCfg struct {
 	NetworkConfiguration     *NetworkConfiguration    `yaml:"network_configuration"`
 	DefaultTaskDefinition    string                   `yaml:"default_task_definition"`
 	EnableExecuteCommand     *bool                    `yaml:"enable_execute_command"`
+	Targets                  []*ECSTargetCfg          `yaml:"targets"`
 
 	capacityProviderStrategy []types.CapacityProviderStrategyItem `yaml:"-"`
 	networkConfiguration     *types.NetworkConfiguration          `yaml:"-"`
@@ -164,10 +189,19 @@ type Listen struct {
 	HTTPS          []PortMap `yaml:"https,omitempty"`
 }
 
+// PortMaps returns all port maps of HTTP and HTTPS listeners.
+func (l Listen) PortMaps() []PortMap {
+	pms := make([]PortMap, 0, len(l.HTTP)+len(l.HTTPS))
+	pms = append(pms, l.HTTP...)
+	pms = append(pms, l.HTTPS...)
+	return pms
+}
+
 type PortMap struct {
-	ListenPort        int  `yaml:"listen"`
-	TargetPort        int  `yaml:"target"`
-	RequireAuthCookie bool `yaml:"require_auth_cookie"`
+	ListenPort        int    `yaml:"listen"`
+	TargetPort        int    `yaml:"target"`
+	RequireAuthCookie bool   `yaml:"require_auth_cookie"`
+	HealthCheckPath   string `yaml:"health_check_path"`
 }
 
 type Parameter struct {
@@ -179,6 +213,8 @@ type Parameter struct {
 	Default     string            `yaml:"default"`
 	Description string            `yaml:"description"`
 	Options     []ParameterOption `yaml:"options"`
+	Type        string            `yaml:"type"` // empty or "secret"
+	Sensitive   bool              `yaml:"sensitive"`
 }
 
 type ParameterOption struct {
@@ -262,13 +298,7 @@ func NewConfig(ctx context.Context, p *ConfigParams) (*Config, error) {
 	if p.Path == "" {
 		slog.Info(f("no config file specified, using default config with domain suffix: %s", domain))
 	} else {
-		var content []byte
-		var err error
-		if strings.HasPrefix(p.Path, "s3://") {
-			content, err = loadFromS3(ctx, cfg.awscfg, p.Path)
-		} else {
-			content, err = loadFromFile(p.Path)
-		}
+		content, err := loadFromFileOrS3(ctx, cfg.awscfg, p.Path)
 		if err != nil {
 			return nil, fmt.Errorf("cannot load config: %s: %w", p.Path, err)
 		}
@@ -297,6 +327,15 @@ func NewConfig(ctx context.Context, p *ConfigParams) (*Config, error) {
 			}
 			v.Regexp = *paramRegex
 		}
+		switch v.Type {
+		case "":
+		case ParameterTypeSecret:
+			if v.Env == "" {
+				return nil, fmt.Errorf("parameter %s of the secret type requires env", v.Name)
+			}
+		default:
+			return nil, fmt.Errorf("invalid parameter type: %s: %s", v.Name, v.Type)
+		}
 	}
 
 	if strings.HasPrefix(cfg.HtmlDir, "s3://") {
@@ -312,12 +351,101 @@ func NewConfig(ctx context.Context, p *ConfigParams) (*Config, error) {
 		slog.Info(f("You can access to http://mirage.localtest.me:%d/", cfg.Listen.HTTP[0].ListenPort))
 	}
 
+	if err := cfg.loadCertificates(ctx); err != nil {
+		return nil, fmt.Errorf("cannot load tls certificates: %w", err)
+	}
+
+	if cfg.Purge != nil {
+		if err := cfg.Purge.validate(); err != nil {
+			return nil, fmt.Errorf("invalid purge config: %w", err)
+		}
+	}
+
+	if cfg.ScaleToZero != nil {
+		if err := cfg.ScaleToZero.validate(); err != nil {
+			return nil, fmt.Errorf("invalid scale_to_zero config: %w", err)
+		}
+	}
+
+	if cfg.Notifications != nil {
+		if err := cfg.Notifications.validate(); err != nil {
+			return nil, fmt.Errorf("invalid notifications config: %w", err)
+		}
+	}
+
+	if cfg.TaskOverrides != nil {
+		if err := cfg.TaskOverrides.validate(); err != nil {
+			return nil, fmt.Errorf("invalid task_overrides config: %w", err)
+		}
+	}
+
+	if cfg.Auth != nil && cfg.Auth.Token != nil {
+		if err := cfg.Auth.Token.validate(); err != nil {
+			return nil, fmt.Errorf("invalid auth.token config: %w", err)
+		}
+	}
+	if cfg.Auth != nil && cfg.Auth.OIDC != nil {
+		if err := cfg.Auth.OIDC.validate(cfg.Auth.CookieSecret); err != nil {
+			return nil, fmt.Errorf("invalid auth.oidc config: %w", err)
+		}
+		cfg.Auth.OIDC.init(cfg.Auth.CookieSecret)
+	}
+	if cfg.Auth != nil && cfg.Auth.Roles != nil {
+		if err := cfg.Auth.Roles.validate(); err != nil {
+			return nil, fmt.Errorf("invalid auth.roles config: %w", err)
+		}
+	}
+
+	if cfg.Redaction != nil {
+		if err := cfg.Redaction.validate(); err != nil {
+			return nil, fmt.Errorf("invalid redaction config: %w", err)
+		}
+	}
+	cfg.redactor = newRedactor(cfg.Redaction, cfg.Parameter)
+
+	if cfg.Logs == nil {
+		cfg.Logs = &LogsCfg{}
+	}
+	if err := cfg.Logs.validate(); err != nil {
+		return nil, fmt.Errorf("invalid logs config: %w", err)
+	}
+
+	if cfg.TaskCache == nil {
+		cfg.TaskCache = &TaskCacheCfg{}
+	}
+	if err := cfg.TaskCache.validate(); err != nil {
+		return nil, fmt.Errorf("invalid task_cache config: %w", err)
+	}
+
+	if cfg.Secrets == nil {
+		cfg.Secrets = &SecretsCfg{}
+	}
+	if err := cfg.Secrets.validate(); err != nil {
+		return nil, fmt.Errorf("invalid secrets config: %w", err)
+	}
+	cfg.secrets = cfg.newSecretStore()
+
+	if h, err := cfg.newHistoryStore(); err != nil {
+		return nil, fmt.Errorf("cannot open history store: %w", err)
+	} else {
+		cfg.history = h
+		cfg.cleanups = append(cfg.cleanups, h.Close)
+	}
+
 	cfg.ECS.capacityProviderStrategy = cfg.ECS.CapacityProviderStrategy.toSDK()
 	cfg.ECS.networkConfiguration = cfg.ECS.NetworkConfiguration.toSDK()
 
 	if err := cfg.fillECSDefaults(ctx); err != nil {
 		slog.Warn(f("failed to fill ECS defaults: %s", err))
 	}
+	if err := cfg.ECS.setupTargets(cfg.localMode); err != nil {
+		return nil, fmt.Errorf("invalid ECS targets: %w", err)
+	}
+	if r, err := cfg.newPresetRegistry(); err != nil {
+		return nil, fmt.Errorf("invalid presets: %w", err)
+	} else {
+		cfg.presets = r
+	}
 	return cfg, nil
 }
 
@@ -425,17 +553,22 @@ func (c *Config) fillECSDefaults(ctx context.Context) error {
 func (cfg *Config) AuthMiddlewareForWeb(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		req := c.Request()
-		ok, err := cfg.Auth.Do(req, c.Response(),
-			cfg.Auth.ByToken, cfg.Auth.ByAmznOIDC, cfg.Auth.ByBasic,
+		id, err := cfg.Auth.Do(req, c.Response(),
+			cfg.Auth.ByToken, cfg.Auth.ByAmznOIDC, cfg.Auth.ByOIDC, cfg.Auth.ByBasic,
 		)
 		if err != nil {
 			slog.Error(f("auth error: %s", err))
 			return echo.ErrInternalServerError
 		}
-		if !ok {
+		if id == nil {
 			slog.Warn("all auth methods failed")
+			if cfg.Auth.OIDC != nil && req.Method == http.MethodGet && req.Header.Get("HX-Request") == "" {
+				// browsers start the OIDC login flow
+				return c.Redirect(http.StatusFound, OIDCLoginPath+"?"+url.Values{"next": {req.URL.RequestURI()}}.Encode())
+			}
 			return echo.ErrUnauthorized
 		}
+		c.Set(identityContextKey, id)
 
 		// check origin header
 		if req.Method == http.MethodPost {
@@ -474,19 +607,30 @@ func (cfg *Config) AuthMiddlewareForWeb(next echo.HandlerFunc) echo.HandlerFunc
 func (cfg *Config) AuthMiddlewareForAPI(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		// API allows only token auth
-		ok, err := cfg.Auth.Do(c.Request(), c.Response(), cfg.Auth.ByToken)
+		id, err := cfg.Auth.Do(c.Request(), c.Response(), cfg.Auth.ByToken)
 		if err != nil {
 			slog.Error(f("auth error: %s", err))
 			return echo.ErrInternalServerError
 		}
-		if !ok {
+		if id == nil {
 			slog.Warn(f("all auth methods failed"))
 			return echo.ErrUnauthorized
 		}
+		c.Set(identityContextKey, id)
 		return next(c)
 	}
 }
 
+const identityContextKey = "mirage-ecs-identity"
+
+// identityOf returns the identity of the authorized client.
+func identityOf(c echo.Context) *Identity {
+	if id, ok := c.Get(identityContextKey).(*Identity); ok {
+		return id
+	}
+	return AnonymousIdentity
+}
+
 func (cfg *Config) CompatMiddlewareForAPI(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		req := c.Request()
@@ -504,6 +648,13 @@ func (cfg *Config) CompatMiddlewareForAPI(next echo.HandlerFunc) echo.HandlerFun
 	}
 }
 
+func loadFromFileOrS3(ctx context.Context, awscfg *aws.Config, p string) ([]byte, error) {
+	if strings.HasPrefix(p, "s3://") {
+		return loadFromS3(ctx, awscfg, p)
+	}
+	return loadFromFile(p)
+}
+
 func loadFromFile(p string) ([]byte, error) {
 	f, err := os.Open(p)
 	if err != nil {
@@ -4,11 +4,25 @@ import (
 	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	mirageecs "github.com/acidlemon/mirage-ecs/v2"
 )
 
+// newTestConfig returns a config of the local mode from the YAML.
+func newTestConfig(t *testing.T, yaml string) (*mirageecs.Config, error) {
+	t.Helper()
+	conf := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(conf, []byte("---\n"+yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return mirageecs.NewConfig(context.Background(), &mirageecs.ConfigParams{
+		Path:      conf,
+		LocalMode: true,
+	})
+}
+
 func TestNewConfig(t *testing.T) {
 	f, err := ioutil.TempFile("", "")
 	if err != nil {
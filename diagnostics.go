@@ -0,0 +1,73 @@
+package mirageecs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/samber/lo"
+)
+
+// ContainerInfo is a status of a container in the task, to diagnose why the task stopped.
+type ContainerInfo struct {
+	Name            string `json:"name"`
+	LastStatus      string `json:"last_status"`
+	ExitCode        *int32 `json:"exit_code,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+	EcsHealthStatus string `json:"ecs_health_status,omitempty"` // HEALTHY, UNHEALTHY or UNKNOWN reported by ECS
+	Image           string `json:"image,omitempty"`
+	ImageDigest     string `json:"image_digest,omitempty"`
+}
+
+// String returns a short summary of the container. e.g. "app: exit 1 (OutOfMemoryError)"
+func (c *ContainerInfo) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name + ": ")
+	if c.ExitCode != nil {
+		fmt.Fprintf(&b, "exit %d", *c.ExitCode)
+	} else {
+		b.WriteString(c.LastStatus)
+	}
+	if c.Reason != "" {
+		fmt.Fprintf(&b, " (%s)", c.Reason)
+	}
+	return b.String()
+}
+
+// Failed reports whether the container exited with non-zero code or has a reason.
+func (c *ContainerInfo) Failed() bool {
+	return (c.ExitCode != nil && *c.ExitCode != 0) || c.Reason != ""
+}
+
+func newContainerInfos(task *types.Task) []*ContainerInfo {
+	return lo.Map(task.Containers, func(c types.Container, _ int) *ContainerInfo {
+		return &ContainerInfo{
+			Name:            aws.ToString(c.Name),
+			LastStatus:      aws.ToString(c.LastStatus),
+			ExitCode:        c.ExitCode,
+			Reason:          aws.ToString(c.Reason),
+			EcsHealthStatus: string(c.HealthStatus),
+			Image:           aws.ToString(c.Image),
+			ImageDigest:     aws.ToString(c.ImageDigest),
+		}
+	})
+}
+
+// setDiagnostics sets the stop reasons and the container statuses of the task.
+func (info *Information) setDiagnostics(task *types.Task) {
+	info.StoppedReason = aws.ToString(task.StoppedReason)
+	info.StopCode = string(task.StopCode)
+	info.EcsHealthStatus = string(task.HealthStatus)
+	if task.StoppedAt != nil {
+		t := task.StoppedAt.In(time.Local)
+		info.StoppedAt = &t
+	}
+	info.Containers = newContainerInfos(task)
+}
+
+// FailedContainers returns containers exited abnormally.
+func (info *Information) FailedContainers() []*ContainerInfo {
+	return lo.Filter(info.Containers, func(c *ContainerInfo, _ int) bool { return c.Failed() })
+}
@@ -0,0 +1,107 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestSetDiagnostics(t *testing.T) {
+	stoppedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	task := &types.Task{
+		StoppedReason: aws.String("Essential container in task exited"),
+		StopCode:      types.TaskStopCodeEssentialContainerExited,
+		StoppedAt:     &stoppedAt,
+		HealthStatus:  types.HealthStatusUnhealthy,
+		Containers: []types.Container{
+			{
+				Name:         aws.String("app"),
+				LastStatus:   aws.String("STOPPED"),
+				ExitCode:     aws.Int32(137),
+				Reason:       aws.String("OutOfMemoryError: Container killed due to memory usage"),
+				HealthStatus: types.HealthStatusUnhealthy,
+				Image:        aws.String("example/app:latest"),
+				ImageDigest:  aws.String("sha256:0123"),
+			},
+			{
+				Name:       aws.String("sidecar"),
+				LastStatus: aws.String("STOPPED"),
+				ExitCode:   aws.Int32(0),
+			},
+		},
+	}
+	info := &mirageecs.Information{LastStatus: "STOPPED"}
+	info.SetDiagnostics(task)
+
+	if info.StopCode != "EssentialContainerExited" || info.StoppedReason != "Essential container in task exited" {
+		t.Errorf("unexpected stop code %s and reason %s", info.StopCode, info.StoppedReason)
+	}
+	if info.EcsHealthStatus != "UNHEALTHY" || info.StoppedAt == nil || !info.StoppedAt.Equal(stoppedAt) {
+		t.Errorf("unexpected health status %s and stopped at %v", info.EcsHealthStatus, info.StoppedAt)
+	}
+	if len(info.Containers) != 2 {
+		t.Fatalf("unexpected containers %v", info.Containers)
+	}
+	app := info.Containers[0]
+	if app.ImageDigest != "sha256:0123" || app.Image != "example/app:latest" || app.EcsHealthStatus != "UNHEALTHY" {
+		t.Errorf("unexpected container %#v", app)
+	}
+	failed := info.FailedContainers()
+	if len(failed) != 1 || failed[0].String() != "app: exit 137 (OutOfMemoryError: Container killed due to memory usage)" {
+		t.Errorf("unexpected failed containers %v", failed)
+	}
+}
+
+func TestApiListIncludeStopped(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := newTestConfig(t, "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+	runner := m.Runner()
+	for _, subdomain := range []string{"running", "stopped"} {
+		if _, err := runner.Launch(ctx, subdomain, mirageecs.TaskParameter{}, mirageecs.LaunchOption{}, "dummy"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := runner.TerminateBySubdomain(ctx, "stopped"); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(query string) map[string]*mirageecs.Information {
+		t.Helper()
+		res, err := ts.Client().Get(ts.URL + "/api/list" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var r mirageecs.APIListResponse
+		if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		infos := map[string]*mirageecs.Information{}
+		for _, info := range r.Result {
+			infos[info.SubDomain] = info
+		}
+		return infos
+	}
+	if infos := list(""); len(infos) != 1 || infos["running"] == nil {
+		t.Errorf("only running tasks should be listed: %v", infos)
+	}
+	infos := list("?include_stopped=true")
+	if len(infos) != 2 {
+		t.Fatalf("stopped tasks should be listed: %v", infos)
+	}
+	if s := infos["stopped"]; s.StopCode != "UserInitiated" || s.StoppedReason == "" || s.StoppedAt == nil {
+		t.Errorf("stopped task should have diagnostics: %#v", s)
+	}
+}
@@ -207,6 +207,35 @@ func testE2EAPI(t *testing.T, reqs map[string]string, contentType string, compat
 		}
 	})
 
+	t.Run("/api/history", func(t *testing.T) {
+		res, err := client.Get(ts.URL + "/api/history?subdomain=mytask")
+		if err != nil {
+			t.Error(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			t.Errorf("status code should be 200: %d", res.StatusCode)
+		}
+		var r mirageecs.APIHistoryResponse
+		json.NewDecoder(res.Body).Decode(&r)
+		if len(r.Result) != 2 {
+			t.Errorf("history should have 2 records %#v", r)
+			return
+		}
+		if r.Result[0].Action != "terminate" || r.Result[1].Action != "launch" {
+			t.Errorf("unexpected actions %s, %s", r.Result[0].Action, r.Result[1].Action)
+		}
+		if r.Result[1].Actor != "none:anonymous" {
+			t.Errorf("unexpected actor %s", r.Result[1].Actor)
+		}
+		if r.Result[1].Parameters["branch"] != "develop" || r.Result[1].Taskdefs[0] != "dummy" {
+			t.Errorf("unexpected launch record %#v", r.Result[1])
+		}
+		if r.Result[1].Result != "succeeded" {
+			t.Errorf("launch should be succeeded %#v", r.Result[1])
+		}
+	})
+
 	t.Run("/api/launch with form", func(t *testing.T) {
 		req, _ := http.NewRequest("POST", ts.URL+"/api/launch", strings.NewReader(e2eRequestsForm["/api/launch"]))
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
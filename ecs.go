@@ -3,6 +3,7 @@ package mirageecs
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log/slog"
 	"sort"
@@ -17,9 +18,9 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	cw "github.com/aws/aws-sdk-go-v2/service/cloudwatch"
 	cwTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
-	cwlogs "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
 
 	"golang.org/x/sync/errgroup"
 )
@@ -27,19 +28,43 @@ import (
 var taskDefinitionCache = ttlcache.NewCache() // no need to expire because taskdef is immutable.
 
 type Information struct {
-	ID         string            `json:"id"`
-	ShortID    string            `json:"short_id"`
-	SubDomain  string            `json:"subdomain"`
-	GitBranch  string            `json:"branch"`
-	TaskDef    string            `json:"taskdef"`
-	IPAddress  string            `json:"ipaddress"`
-	Created    time.Time         `json:"created"`
-	LastStatus string            `json:"last_status"`
-	PortMap    map[string]int    `json:"port_map"`
-	Env        map[string]string `json:"env"`
-	Tags       []types.Tag       `json:"tags"`
+	ID            string            `json:"id"`
+	ShortID       string            `json:"short_id"`
+	SubDomain     string            `json:"subdomain"`
+	GitBranch     string            `json:"branch"`
+	TaskDef       string            `json:"taskdef"`
+	IPAddress     string            `json:"ipaddress"`
+	Created       time.Time         `json:"created"`
+	LastStatus    string            `json:"last_status"`
+	DesiredStatus string            `json:"desired_status,omitempty"`
+	PortMap       map[string]int    `json:"port_map"`
+	Env           map[string]string `json:"env"`
+	Tags          []types.Tag       `json:"tags"`
+
+	StoppedReason   string           `json:"stopped_reason,omitempty"`
+	StopCode        string           `json:"stop_code,omitempty"` // e.g. EssentialContainerExited, UserInitiated
+	StoppedAt       *time.Time       `json:"stopped_at,omitempty"`
+	EcsHealthStatus string           `json:"ecs_health_status,omitempty"` // HEALTHY, UNHEALTHY or UNKNOWN reported by ECS. see Health for the health checks of mirage-ecs
+	Containers      []*ContainerInfo `json:"containers,omitempty"`
+	Target          string           `json:"target,omitempty"` // name of the ECS target
+	Owner           string           `json:"owner,omitempty"`  // identity who launched the task
+
+	ExpireAt         *time.Time `json:"expire_at,omitempty"`
+	RemainingSeconds int64      `json:"remaining_seconds,omitempty"`
+
+	Ready  bool   `json:"ready"`
+	Health string `json:"health,omitempty"` // healthy, starting or no_route
+
+	task      *types.Task
+	overrides *TaskOverrides // overrides by the launch request, to wake up the same task
+}
 
-	task *types.Task
+// stoppedUnexpectedly reports whether the task was stopped by other than StopTask (e.g. the essential container exited).
+func (info *Information) stoppedUnexpectedly() bool {
+	if info.LastStatus != statusStopped || info.task == nil {
+		return false
+	}
+	return info.task.StopCode != types.TaskStopCodeUserInitiated
 }
 
 func (info Information) ShouldBePurged(duration time.Duration, excludesMap map[string]struct{}, excludeTagsMap map[string]string) bool {
@@ -82,7 +107,8 @@ func (p TaskParameter) ToECSKeyValuePairs(subdomain string, configParams Paramet
 	)
 	for _, v := range configParams {
 		v := v
-		if p[v.Name] == "" {
+		if p[v.Name] == "" || v.IsSecret() {
+			// secrets are injected by ToECSSecrets
 			continue
 		}
 		kvp = append(kvp, types.KeyValuePair{
@@ -127,6 +153,10 @@ func (p TaskParameter) ToEnv(subdomain string, configParams Parameters, enc func
 		if p[v.Name] == "" {
 			continue
 		}
+		if v.IsSecret() {
+			env[strings.ToUpper(v.Env)] = RedactedValue
+			continue
+		}
 		env[strings.ToUpper(v.Env)] = p[v.Name]
 	}
 	return env
@@ -135,6 +165,7 @@ func (p TaskParameter) ToEnv(subdomain string, configParams Parameters, enc func
 const (
 	TagManagedBy   = "ManagedBy"
 	TagSubdomain   = "Subdomain"
+	TagOwner       = "Owner"
 	TagValueMirage = "Mirage"
 
 	EnvSubdomain    = "SUBDOMAIN"
@@ -144,12 +175,36 @@ const (
 	statusStopped = string(types.DesiredStatusStopped)
 )
 
+// LaunchResult is a result of launching a task of the task definition.
+type LaunchResult struct {
+	TaskDef    string        `json:"taskdef"`
+	ID         string        `json:"id,omitempty"` // task ARN
+	ShortID    string        `json:"short_id,omitempty"`
+	Failures   []*ECSFailure `json:"failures,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	RolledBack bool          `json:"rolled_back,omitempty"`
+}
+
+// ECSFailure is a failure reported by ecs:RunTask.
+type ECSFailure struct {
+	Arn    string `json:"arn,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
 type TaskRunner interface {
-	Launch(ctx context.Context, subdomain string, param TaskParameter, taskdefs ...string) error
-	Logs(ctx context.Context, subdomain string, since time.Time, tail int) ([]string, error)
+	// Launch launches tasks for each taskdef. Results are returned in the order of taskdefs even if an error occurs.
+	// When some of the tasks failed to launch, the launched tasks are stopped.
+	Launch(ctx context.Context, subdomain string, param TaskParameter, opt LaunchOption, taskdefs ...string) ([]*LaunchResult, error)
+	// Logs returns log events of the running tasks of the subdomain in chronological order.
+	Logs(ctx context.Context, subdomain string, since time.Time, tail int) ([]*LogEvent, error)
 	Trace(ctx context.Context, id string) (string, error)
+	// Describe returns the task of the ARN or the short ID, bypassing the cache.
+	// It returns nil when the task is not found or not managed by Mirage.
+	Describe(ctx context.Context, id string) (*Information, error)
 	Terminate(ctx context.Context, subdomain string) error
 	TerminateBySubdomain(ctx context.Context, subdomain string) error
+	SetExpiration(ctx context.Context, subdomain string, expireAt time.Time) error
 	List(ctx context.Context, status string) ([]*Information, error)
 	SetProxyControlChannel(ch chan *proxyControl)
 	GetAccessCount(ctx context.Context, subdomain string, duration time.Duration) (int64, error)
@@ -158,37 +213,70 @@ type TaskRunner interface {
 
 type ECS struct {
 	cfg            *Config
-	svc            *ecs.Client
-	logsSvc        *cwlogs.Client
+	targets        []*ecsTarget // the first one is the default target
 	cwSvc          *cw.Client
-	tracer         *tracer.Tracer
+	sqsSvc         *sqs.Client
 	proxyControlCh chan *proxyControl
 }
 
 func NewECSTaskRunner(cfg *Config) TaskRunner {
-	tr, err := tracer.NewWithConfig(*cfg.awscfg)
+	e := &ECS{
+		cfg:   cfg,
+		cwSvc: cw.NewFromConfig(*cfg.awscfg),
+	}
+	t, err := newECSTarget(DefaultECSTarget, &cfg.ECS, *cfg.awscfg)
 	if err != nil {
 		panic(err)
 	}
-	e := &ECS{
-		cfg:     cfg,
-		svc:     ecs.NewFromConfig(*cfg.awscfg),
-		logsSvc: cwlogs.NewFromConfig(*cfg.awscfg),
-		cwSvc:   cw.NewFromConfig(*cfg.awscfg),
-		tracer:  tr,
+	e.targets = append(e.targets, t)
+	for _, tc := range cfg.ECS.Targets {
+		t, err := newECSTarget(tc.Name, &tc.ECSCfg, *cfg.awscfg)
+		if err != nil {
+			panic(err)
+		}
+		e.targets = append(e.targets, t)
+	}
+	for _, t := range e.targets {
+		t.states = newTaskStateCache(cfg.TaskCache, &ecsTaskFetcher{e: e, t: t})
+	}
+	if cfg.TaskCache != nil && cfg.TaskCache.SQSQueueURL != "" {
+		e.sqsSvc = sqs.NewFromConfig(*cfg.awscfg)
 	}
 	return e
 }
 
+// target returns the target by name. Empty name means the default target.
+func (e *ECS) target(name string) (*ecsTarget, error) {
+	if name == "" {
+		return e.targets[0], nil
+	}
+	for _, t := range e.targets {
+		if t.name == name {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown ECS target %s", name)
+}
+
+// targetOf returns the target which runs the task.
+func (e *ECS) targetOf(taskArn string) *ecsTarget {
+	for _, t := range e.targets {
+		if t.owns(taskArn) {
+			return t
+		}
+	}
+	return e.targets[0]
+}
+
 func (e *ECS) SetProxyControlChannel(ch chan *proxyControl) {
 	e.proxyControlCh = ch
 }
 
-func (e *ECS) launchTask(ctx context.Context, subdomain string, taskdef string, option TaskParameter) error {
+func (e *ECS) launchTask(ctx context.Context, t *ecsTarget, subdomain string, taskdef string, option TaskParameter, opt LaunchOption, res *LaunchResult) error {
 	cfg := e.cfg
 
-	slog.Info(f("launching task subdomain:%s taskdef:%s", subdomain, taskdef))
-	tdOut, err := e.svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+	slog.Info(f("launching task subdomain:%s taskdef:%s target:%s", subdomain, taskdef, t.name))
+	tdOut, err := t.svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
 		TaskDefinition: aws.String(taskdef),
 	})
 	if err != nil {
@@ -209,57 +297,132 @@ func (e *ECS) launchTask(ctx context.Context, subdomain string, taskdef string,
 			},
 		)
 	}
-	slog.Debug(f("Task Override: %v", ov))
+	opt.Overrides.apply(ov)
+	slog.Debug(f("Task Override: %v", cfg.Redactor().TaskOverride(ov)))
+
+	runTaskdef := taskdef
+	if secrets := option.ToECSSecrets(cfg.Parameter); len(secrets) > 0 {
+		if runTaskdef, err = e.registerTaskDefinitionWithSecrets(ctx, t, tdOut.TaskDefinition, secrets); err != nil {
+			return err
+		}
+	}
 
-	tags := option.ToECSTags(subdomain, cfg.Parameter)
+	tags := append(option.ToECSTags(subdomain, cfg.Parameter), opt.toECSTags()...)
 	runtaskInput := &ecs.RunTaskInput{
-		CapacityProviderStrategy: cfg.ECS.capacityProviderStrategy,
-		Cluster:                  aws.String(cfg.ECS.Cluster),
-		TaskDefinition:           aws.String(taskdef),
-		NetworkConfiguration:     cfg.ECS.networkConfiguration,
-		LaunchType:               types.LaunchType(*cfg.ECS.LaunchType),
+		CapacityProviderStrategy: t.cfg.capacityProviderStrategy,
+		Cluster:                  aws.String(t.cfg.Cluster),
+		TaskDefinition:           aws.String(runTaskdef),
+		NetworkConfiguration:     t.cfg.networkConfiguration,
+		LaunchType:               types.LaunchType(aws.ToString(t.cfg.LaunchType)),
 		Overrides:                ov,
 		Count:                    aws.Int32(1),
 		Tags:                     tags,
-		EnableExecuteCommand:     aws.ToBool(cfg.ECS.EnableExecuteCommand),
+		EnableExecuteCommand:     aws.ToBool(t.cfg.EnableExecuteCommand),
 	}
-	slog.Debug(f("RunTaskInput: %v", runtaskInput))
-	out, err := e.svc.RunTask(ctx, runtaskInput)
+	slog.Debug(f("RunTaskInput: %v", cfg.Redactor().RunTaskInput(runtaskInput)))
+	out, err := t.svc.RunTask(ctx, runtaskInput)
 	if err != nil {
 		return err
 	}
-	if len(out.Failures) > 0 {
-		f := out.Failures[0]
-		return fmt.Errorf(
-			"run task failed. reason:%s arn:%s", *f.Reason, *f.Arn,
-		)
+	for _, f := range out.Failures {
+		res.Failures = append(res.Failures, &ECSFailure{
+			Arn:    aws.ToString(f.Arn),
+			Reason: aws.ToString(f.Reason),
+			Detail: aws.ToString(f.Detail),
+		})
+	}
+	if len(out.Tasks) == 0 {
+		if len(res.Failures) > 0 {
+			f := res.Failures[0]
+			return fmt.Errorf("run task failed. reason:%s arn:%s", f.Reason, f.Arn)
+		}
+		return fmt.Errorf("run task failed. no tasks are started")
 	}
 	task := out.Tasks[0]
-	slog.Info(f("launced task ARN: %s", *task.TaskArn))
+	res.ID = aws.ToString(task.TaskArn)
+	res.ShortID = shortenArn(res.ID)
+	slog.Info(f("launced task ARN: %s", res.ID))
+	t.states.markStale(res.ID)
 	return nil
 }
 
-func (e *ECS) Launch(ctx context.Context, subdomain string, option TaskParameter, taskdefs ...string) error {
+func (e *ECS) Launch(ctx context.Context, subdomain string, option TaskParameter, opt LaunchOption, taskdefs ...string) ([]*LaunchResult, error) {
+	t, err := e.target(opt.Target)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.checkOverrideContainers(ctx, t, opt.Overrides, taskdefs); err != nil {
+		return nil, err
+	}
 	if infos, err := e.find(ctx, subdomain); err != nil {
-		return fmt.Errorf("failed to get subdomain %s: %w", subdomain, err)
+		return nil, fmt.Errorf("failed to get subdomain %s: %w", subdomain, err)
 	} else if len(infos) > 0 {
 		slog.Info(f("subdomain %s is already running %d tasks. Terminating...", subdomain, len(infos)))
 		err := e.TerminateBySubdomain(ctx, subdomain)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	slog.Info(f("launching subdomain:%s taskdefs:%v", subdomain, taskdefs))
 
+	results := make([]*LaunchResult, len(taskdefs))
 	var eg errgroup.Group
-	for _, taskdef := range taskdefs {
+	for i, taskdef := range taskdefs {
+		res := &LaunchResult{TaskDef: taskdef}
+		results[i] = res
 		taskdef := taskdef
 		eg.Go(func() error {
-			return e.launchTask(ctx, subdomain, taskdef, option)
+			err := e.launchTask(ctx, t, subdomain, taskdef, option, opt, res)
+			if err != nil {
+				res.Error = err.Error()
+			}
+			return err
 		})
 	}
-	return eg.Wait()
+	if err := eg.Wait(); err != nil {
+		e.rollback(ctx, subdomain, results)
+		return results, err
+	}
+	return results, nil
+}
+
+// checkOverrideContainers checks the overridden containers are defined in the task definitions.
+func (e *ECS) checkOverrideContainers(ctx context.Context, t *ecsTarget, o *TaskOverrides, taskdefs []string) error {
+	if o.isEmpty() || len(o.Containers) == 0 {
+		return nil
+	}
+	var names []string
+	for _, taskdef := range taskdefs {
+		out, err := t.svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+			TaskDefinition: aws.String(taskdef),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to describe task definition: %w", err)
+		}
+		for _, c := range out.TaskDefinition.ContainerDefinitions {
+			names = append(names, aws.ToString(c.Name))
+		}
+	}
+	return o.checkContainers(names)
+}
+
+// rollback stops the tasks launched successfully, to avoid leaving a part of the subdomain.
+func (e *ECS) rollback(ctx context.Context, subdomain string, results []*LaunchResult) {
+	// the ctx may be canceled already
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), APICallTimeout)
+	defer cancel()
+	for _, res := range results {
+		if res.ID == "" {
+			continue
+		}
+		slog.Warn(f("rolling back task %s of subdomain %s", res.ID, subdomain))
+		if err := e.stopTask(ctx, e.targetOf(res.ID), res.ID, "Rolled back by Mirage"); err != nil {
+			slog.Error(f("failed to roll back task %s: %s", res.ID, err))
+			continue
+		}
+		res.RolledBack = true
+	}
 }
 
 func (e *ECS) Trace(ctx context.Context, id string) (string, error) {
@@ -267,15 +430,55 @@ func (e *ECS) Trace(ctx context.Context, id string) (string, error) {
 		Stdout:   true,
 		Duration: 5 * time.Minute,
 	}
+	t, err := e.targetOfTaskID(ctx, id)
+	if err != nil {
+		return "", err
+	}
 	buf := &strings.Builder{}
-	e.tracer.SetOutput(buf)
-	if err := e.tracer.Run(ctx, e.cfg.ECS.Cluster, id, tracerOpt); err != nil {
+	t.tracer.SetOutput(buf)
+	if err := t.tracer.Run(ctx, t.cfg.Cluster, id, tracerOpt); err != nil {
 		return "", err
 	}
 	return buf.String(), nil
 }
 
-func (e *ECS) Logs(ctx context.Context, subdomain string, since time.Time, tail int) ([]string, error) {
+func (e *ECS) Describe(ctx context.Context, id string) (*Information, error) {
+	targets := e.targets
+	if strings.HasPrefix(id, "arn:") && len(e.targets) > 1 {
+		targets = lo.Filter(e.targets, func(t *ecsTarget, _ int) bool { return t.owns(id) })
+	}
+	for _, t := range targets {
+		infos, err := t.states.fetcher.DescribeTasks(ctx, []string{id})
+		if err != nil {
+			return nil, fmt.Errorf("target %s: %w", t.name, err)
+		}
+		if len(infos) > 0 {
+			return infos[0], nil
+		}
+	}
+	return nil, nil
+}
+
+// targetOfTaskID returns the target which runs the task of the ARN or the short ID.
+func (e *ECS) targetOfTaskID(ctx context.Context, id string) (*ecsTarget, error) {
+	if strings.HasPrefix(id, "arn:") || len(e.targets) == 1 {
+		return e.targetOf(id), nil
+	}
+	for _, status := range []string{statusRunning, statusStopped} {
+		infos, err := e.List(ctx, status)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if info.ShortID == id {
+				return e.targetOf(info.ID), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("task %s is not found", id)
+}
+
+func (e *ECS) Logs(ctx context.Context, subdomain string, since time.Time, tail int) ([]*LogEvent, error) {
 	infos, err := e.find(ctx, subdomain)
 	if err != nil {
 		return nil, err
@@ -284,7 +487,7 @@ func (e *ECS) Logs(ctx context.Context, subdomain string, since time.Time, tail
 		return nil, fmt.Errorf("subdomain %s is not found", subdomain)
 	}
 
-	var logs []string
+	var logs []*LogEvent
 	var eg errgroup.Group
 	var mu sync.Mutex
 	for _, info := range infos {
@@ -297,12 +500,15 @@ func (e *ECS) Logs(ctx context.Context, subdomain string, since time.Time, tail
 			return err
 		})
 	}
-	return logs, eg.Wait()
+	err = eg.Wait()
+	sortLogEvents(logs)
+	return tailLogEvents(logs, tail), err
 }
 
-func (e *ECS) logs(ctx context.Context, info *Information, since time.Time, tail int) ([]string, error) {
+func (e *ECS) logs(ctx context.Context, info *Information, since time.Time, tail int) ([]*LogEvent, error) {
 	task := info.task
-	taskdefOut, err := e.svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+	t := e.targetOf(info.ID)
+	taskdefOut, err := t.svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
 		TaskDefinition: task.TaskDefinitionArn,
 		Include:        []types.TaskDefinitionField{types.TaskDefinitionFieldTags},
 	})
@@ -310,68 +516,54 @@ func (e *ECS) logs(ctx context.Context, info *Information, since time.Time, tail
 		return nil, fmt.Errorf("failed to describe task definition: %w", err)
 	}
 
-	streams := make(map[string][]string)
+	logs := []*LogEvent{}
 	for _, c := range taskdefOut.TaskDefinition.ContainerDefinitions {
 		c := c
-		logConf := c.LogConfiguration
-		if logConf == nil {
+		name := aws.ToString(c.Name)
+		src, err := e.cfg.Logs.logSourceOf(&c, &logContainer{
+			Container: name,
+			TaskID:    info.ShortID,
+			Family:    aws.ToString(taskdefOut.TaskDefinition.Family),
+			created:   info.Created,
+		})
+		if err != nil {
+			slog.Warn(f("container %s: %s", name, err))
 			continue
 		}
-		if logConf.LogDriver != types.LogDriverAwslogs {
-			slog.Warn(f("LogDriver %s is not supported", logConf.LogDriver))
+		if src == nil {
 			continue
 		}
-		group := logConf.Options["awslogs-group"]
-		streamPrefix := logConf.Options["awslogs-stream-prefix"]
-		if group == "" || streamPrefix == "" {
-			slog.Warn(f("invalid options. awslogs-group %s awslogs-stream-prefix %s", group, streamPrefix))
-			continue
+		slog.Debug(f("get log events of container %s from %s start:%s", name, src, since))
+		evs, err := src.events(ctx, t.logsSvc, since, tail)
+		if err != nil {
+			slog.Warn(f("failed to get log events of container %s from %s: %s", name, src, err))
 		}
-		// streamName: prefix/containerName/taskID
-		streams[group] = append(
-			streams[group],
-			fmt.Sprintf("%s/%s/%s", streamPrefix, *c.Name, info.ShortID),
-		)
-	}
-
-	logs := []string{}
-	for group, streamNames := range streams {
-		group := group
-		for _, stream := range streamNames {
-			stream := stream
-			slog.Debug(f("get log events from group:%s stream:%s start:%s", group, stream, since))
-			in := &cwlogs.GetLogEventsInput{
-				LogGroupName:  aws.String(group),
-				LogStreamName: aws.String(stream),
-			}
-			if !since.IsZero() {
-				in.StartTime = aws.Int64(since.Unix() * 1000)
-			}
-			eventsOut, err := e.logsSvc.GetLogEvents(ctx, in)
-			if err != nil {
-				slog.Warn(f("failed to get log events from group %s stream %s: %s", group, stream, err))
-				continue
-			}
-			slog.Debug(f("%d log events", len(eventsOut.Events)))
-			for _, ev := range eventsOut.Events {
-				logs = append(logs, *ev.Message)
-			}
+		for _, ev := range evs {
+			ev.TaskID = info.ShortID
+			ev.Container = name
 		}
+		logs = append(logs, evs...)
 	}
-	if tail > 0 && len(logs) >= tail {
-		return logs[len(logs)-tail:], nil
-	}
-	return logs, nil
+	sortLogEvents(logs)
+	return tailLogEvents(logs, tail), nil
 }
 
 func (e *ECS) Terminate(ctx context.Context, taskArn string) error {
+	return e.stopTask(ctx, e.targetOf(taskArn), taskArn, "Terminate requested by Mirage")
+}
+
+func (e *ECS) stopTask(ctx context.Context, t *ecsTarget, taskArn string, reason string) error {
 	slog.Info(f("stop task %s", taskArn))
-	_, err := e.svc.StopTask(ctx, &ecs.StopTaskInput{
-		Cluster: aws.String(e.cfg.ECS.Cluster),
+	_, err := t.svc.StopTask(ctx, &ecs.StopTaskInput{
+		Cluster: aws.String(t.cfg.Cluster),
 		Task:    aws.String(taskArn),
-		Reason:  aws.String("Terminate requested by Mirage"),
+		Reason:  aws.String(reason),
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	t.states.markStale(taskArn)
+	return nil
 }
 
 func (e *ECS) TerminateBySubdomain(ctx context.Context, subdomain string) error {
@@ -396,6 +588,29 @@ func (e *ECS) TerminateBySubdomain(ctx context.Context, subdomain string) error
 	return eg.Wait()
 }
 
+func (e *ECS) SetExpiration(ctx context.Context, subdomain string, expireAt time.Time) error {
+	infos, err := e.find(ctx, subdomain)
+	if err != nil {
+		return err
+	}
+	if len(infos) == 0 {
+		return fmt.Errorf("subdomain %s is not running", subdomain)
+	}
+	for _, info := range infos {
+		slog.Info(f("set expiration of task %s to %s", info.ID, expireAt.Format(time.RFC3339)))
+		t := e.targetOf(info.ID)
+		_, err := t.svc.TagResource(ctx, &ecs.TagResourceInput{
+			ResourceArn: aws.String(info.ID),
+			Tags:        []types.Tag{expireAtTag(expireAt)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to tag task %s: %w", info.ID, err)
+		}
+		t.states.markStale(info.ID)
+	}
+	return nil
+}
+
 func (e *ECS) find(ctx context.Context, subdomain string) ([]*Information, error) {
 	var results []*Information
 
@@ -414,29 +629,85 @@ func (e *ECS) find(ctx context.Context, subdomain string) ([]*Information, error
 func (e *ECS) List(ctx context.Context, desiredStatus string) ([]*Information, error) {
 	slog.Debug(f("call ecs.List(%s)", desiredStatus))
 	infos := []*Information{}
-	var nextToken *string
-	cluster := aws.String(e.cfg.ECS.Cluster)
-	include := []types.TaskField{types.TaskFieldTags}
-	for {
-		listOut, err := e.svc.ListTasks(ctx, &ecs.ListTasksInput{
-			Cluster:       cluster,
-			NextToken:     nextToken,
-			DesiredStatus: types.DesiredStatus(desiredStatus),
+	var errs []error
+	var eg errgroup.Group
+	var mu sync.Mutex
+	for _, t := range e.targets {
+		t := t
+		eg.Go(func() error {
+			is, err := t.states.list(ctx, desiredStatus)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				// a failed target doesn't hide the tasks of the others
+				slog.Warn(f("failed to list tasks of target %s: %s", t.name, err))
+				errs = append(errs, fmt.Errorf("target %s: %w", t.name, err))
+				return nil
+			}
+			infos = append(infos, is...)
+			return nil
+		})
+	}
+	eg.Wait()
+	if len(errs) == len(e.targets) && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].SubDomain < infos[j].SubDomain
+	})
+
+	return infos, nil
+}
+
+// RefreshTaskStates refreshes the cached task states of all the targets.
+func (e *ECS) RefreshTaskStates(ctx context.Context) error {
+	var eg errgroup.Group
+	for _, t := range e.targets {
+		t := t
+		eg.Go(func() error {
+			if err := t.states.refresh(ctx); err != nil {
+				return fmt.Errorf("target %s: %w", t.name, err)
+			}
+			return nil
 		})
+	}
+	return eg.Wait()
+}
+
+// ecsTaskFetcher gets tasks of the target from ECS.
+type ecsTaskFetcher struct {
+	e *ECS
+	t *ecsTarget
+}
+
+func (ft *ecsTaskFetcher) ListTaskArns(ctx context.Context, desiredStatus string) ([]string, error) {
+	var arns []string
+	p := ecs.NewListTasksPaginator(ft.t.svc, &ecs.ListTasksInput{
+		Cluster:       aws.String(ft.t.cfg.Cluster),
+		DesiredStatus: types.DesiredStatus(desiredStatus),
+	})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("failed to list tasks: %w", err)
 		}
-		if len(listOut.TaskArns) == 0 {
-			return []*Information{}, nil
-		}
+		arns = append(arns, out.TaskArns...)
+	}
+	return arns, nil
+}
 
-		tasksOut, err := e.svc.DescribeTasks(ctx, &ecs.DescribeTasksInput{
-			Cluster: cluster,
-			Tasks:   listOut.TaskArns,
-			Include: include,
+func (ft *ecsTaskFetcher) DescribeTasks(ctx context.Context, arns []string) ([]*Information, error) {
+	e, t := ft.e, ft.t
+	infos := []*Information{}
+	for _, chunk := range lo.Chunk(arns, describeTasksMaxTasks) {
+		tasksOut, err := t.svc.DescribeTasks(ctx, &ecs.DescribeTasksInput{
+			Cluster: aws.String(t.cfg.Cluster),
+			Tasks:   chunk,
+			Include: []types.TaskField{types.TaskFieldTags},
 		})
 		if err != nil {
-			return infos, fmt.Errorf("failed to describe tasks: %w", err)
+			return nil, fmt.Errorf("failed to describe tasks: %w", err)
 		}
 
 		for _, task := range tasksOut.Tasks {
@@ -446,18 +717,22 @@ func (e *ECS) List(ctx context.Context, desiredStatus string) ([]*Information, e
 				continue
 			}
 			info := &Information{
-				ID:         *task.TaskArn,
-				ShortID:    shortenArn(*task.TaskArn),
-				SubDomain:  decodeTagValue(getTagsFromTask(&task, "Subdomain")),
-				GitBranch:  getEnvironmentFromTask(&task, "GIT_BRANCH"),
-				TaskDef:    shortenArn(*task.TaskDefinitionArn),
-				IPAddress:  getIPV4AddressFromTask(&task),
-				LastStatus: *task.LastStatus,
-				Env:        getEnvironmentsFromTask(&task),
-				Tags:       task.Tags,
-				task:       &task,
+				ID:            *task.TaskArn,
+				ShortID:       shortenArn(*task.TaskArn),
+				SubDomain:     decodeTagValue(getTagsFromTask(&task, "Subdomain")),
+				GitBranch:     getEnvironmentFromTask(&task, "GIT_BRANCH"),
+				TaskDef:       shortenArn(*task.TaskDefinitionArn),
+				IPAddress:     getIPV4AddressFromTask(&task),
+				LastStatus:    *task.LastStatus,
+				DesiredStatus: aws.ToString(task.DesiredStatus),
+				Env:           getEnvironmentsFromTask(&task),
+				Tags:          task.Tags,
+				Target:        t.name,
+				Owner:         getTagsFromTask(&task, TagOwner),
+				task:          &task,
+				overrides:     taskOverridesOf(&task, e.cfg.Parameter),
 			}
-			if portMap, err := e.portMapInTask(ctx, &task); err != nil {
+			if portMap, err := e.portMapInTask(ctx, t, &task); err != nil {
 				slog.Warn(f("failed to get portMap in task %s %s", *task.TaskArn, err))
 			} else {
 				info.PortMap = portMap
@@ -465,19 +740,11 @@ func (e *ECS) List(ctx context.Context, desiredStatus string) ([]*Information, e
 			if task.StartedAt != nil {
 				info.Created = (*task.StartedAt).In(time.Local)
 			}
+			info.setDiagnostics(&task)
+			redactSecretEnv(info.Env, task.Tags, e.cfg.Parameter)
 			infos = append(infos, info)
 		}
-
-		nextToken = listOut.NextToken
-		if nextToken == nil {
-			break
-		}
 	}
-
-	sort.Slice(infos, func(i, j int) bool {
-		return infos[i].SubDomain < infos[j].SubDomain
-	})
-
 	return infos, nil
 }
 
@@ -506,9 +773,13 @@ func getIPV4AddressFromTask(task *types.Task) string {
 }
 
 func getTagsFromTask(task *types.Task, name string) string {
-	for _, t := range task.Tags {
-		if *t.Key == name {
-			return *t.Value
+	return tagValue(task.Tags, name)
+}
+
+func tagValue(tags []types.Tag, name string) string {
+	for _, t := range tags {
+		if aws.ToString(t.Key) == name {
+			return aws.ToString(t.Value)
 		}
 	}
 	return ""
@@ -552,13 +823,13 @@ func decodeTagValue(s string) string {
 	return string(d)
 }
 
-func (e *ECS) portMapInTask(ctx context.Context, task *types.Task) (map[string]int, error) {
+func (e *ECS) portMapInTask(ctx context.Context, t *ecsTarget, task *types.Task) (map[string]int, error) {
 	portMap := make(map[string]int)
 	tdArn := *task.TaskDefinitionArn
 	td, err := taskDefinitionCache.Get(tdArn)
 	if err != nil && err == ttlcache.ErrNotFound {
 		slog.Debug(f("cache miss for %s", tdArn))
-		out, err := e.svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+		out, err := t.svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
 			TaskDefinition: &tdArn,
 		})
 		if err != nil {
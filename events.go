@@ -0,0 +1,154 @@
+package mirageecs
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	EventLaunchRequested    = "launch_requested"
+	EventLaunched           = "launched"
+	EventLaunchFailed       = "launch_failed"
+	EventTerminateRequested = "terminate_requested"
+	EventTaskStatus         = "task_status"
+	EventTaskStopped        = "task_stopped"
+	EventRouteAdded         = "route_added"
+	EventRouteRemoved       = "route_removed"
+	EventRoute53Changed     = "route53_changed"
+	EventPurged             = "purged"
+	EventExpiring           = "expiring"
+	EventExpired            = "expired"
+	EventSlept              = "slept"
+	EventWoken              = "woken"
+
+	eventBufferSize      = 100
+	eventSubscriberQueue = 64
+	eventKeepAlive       = 30 * time.Second
+)
+
+// Event is a lifecycle event of tasks.
+type Event struct {
+	ID        int64             `json:"id"`
+	Type      string            `json:"type"`
+	Subdomain string            `json:"subdomain,omitempty"`
+	TaskID    string            `json:"task_id,omitempty"`
+	Status    string            `json:"status,omitempty"`
+	Actor     string            `json:"actor,omitempty"`
+	Detail    map[string]string `json:"detail,omitempty"`
+	Time      time.Time         `json:"time"`
+}
+
+// EventBus delivers events to subscribers. It keeps recent events for reconnecting clients.
+type EventBus struct {
+	mu          sync.Mutex
+	seq         int64
+	buffer      []*Event
+	subscribers map[chan *Event]struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[chan *Event]struct{}),
+	}
+}
+
+// Publish sends the event to all subscribers. Events are dropped for slow subscribers.
+func (b *EventBus) Publish(ev *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	ev.ID = b.seq
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	b.buffer = append(b.buffer, ev)
+	if len(b.buffer) > eventBufferSize {
+		b.buffer = b.buffer[len(b.buffer)-eventBufferSize:]
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			slog.Warn(f("event subscriber is too slow. dropped event %d", ev.ID))
+		}
+	}
+}
+
+// Subscribe returns a channel of events and a function to unsubscribe.
+// Events after lastID in the buffer are sent at first.
+func (b *EventBus) Subscribe(lastID int64) (<-chan *Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan *Event, eventSubscriberQueue+eventBufferSize)
+	if lastID > 0 {
+		for _, ev := range b.buffer {
+			if ev.ID > lastID {
+				ch <- ev
+			}
+		}
+	}
+	b.subscribers[ch] = struct{}{}
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, ch)
+	}
+}
+
+// EventBus returns the event bus of task lifecycle events.
+func (c *Config) EventBus() *EventBus {
+	c.eventsOnce.Do(func() {
+		c.events = NewEventBus()
+	})
+	return c.events
+}
+
+// Events streams events as Server-Sent Events.
+func (api *WebApi) Events(c echo.Context) error {
+	subdomain := c.QueryParam("subdomain")
+	var lastID int64
+	if s := c.Request().Header.Get("Last-Event-ID"); s != "" {
+		lastID, _ = strconv.ParseInt(s, 10, 64)
+	}
+	ch, unsubscribe := api.cfg.EventBus().Subscribe(lastID)
+	defer unsubscribe()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	fmt.Fprint(res, ": connected\n\n")
+	res.Flush()
+
+	tk := time.NewTicker(eventKeepAlive)
+	defer tk.Stop()
+	ctx := c.Request().Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tk.C:
+			fmt.Fprint(res, ": keep-alive\n\n")
+		case ev := <-ch:
+			if subdomain != "" && ev.Subdomain != subdomain {
+				continue
+			}
+			b, err := json.Marshal(ev)
+			if err != nil {
+				slog.Warn(f("failed to marshal event: %s", err))
+				continue
+			}
+			fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, b)
+		}
+		res.Flush()
+	}
+}
@@ -0,0 +1,103 @@
+package mirageecs_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestEventBus(t *testing.T) {
+	bus := mirageecs.NewEventBus()
+	bus.Publish(&mirageecs.Event{Type: "a"})
+	bus.Publish(&mirageecs.Event{Type: "b"})
+
+	ch, unsubscribe := bus.Subscribe(1)
+	defer unsubscribe()
+	bus.Publish(&mirageecs.Event{Type: "c"})
+	for _, expect := range []string{"b", "c"} {
+		select {
+		case ev := <-ch:
+			if ev.Type != expect {
+				t.Errorf("expected %s, got %s", expect, ev.Type)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %s is not received", expect)
+		}
+	}
+}
+
+func TestEventsAPI(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+		Domain:    "localtest.me",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	go m.SyncECSToMirage(ctx)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/events?subdomain=mytask", nil)
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+
+	go func() {
+		ts.Client().Post(ts.URL+"/api/launch", "application/json",
+			strings.NewReader(`{"subdomain":"othertask","taskdef":["dummy"],"branch":"main"}`))
+		ts.Client().Post(ts.URL+"/api/launch", "application/json",
+			strings.NewReader(`{"subdomain":"mytask","taskdef":["dummy"],"branch":"main"}`))
+	}()
+
+	events := make(chan *mirageecs.Event)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var ev mirageecs.Event
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				t.Errorf("invalid event %s", data)
+				continue
+			}
+			events <- &ev
+		}
+	}()
+
+	// route_added and launched may be published in any order
+	received := map[string]bool{}
+	for len(received) < 3 {
+		select {
+		case ev := <-events:
+			if ev.Subdomain != "mytask" {
+				t.Errorf("unexpected event %#v", ev)
+			}
+			received[ev.Type] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("events are not received: %v", received)
+		}
+	}
+	for _, expect := range []string{"launch_requested", "launched", "route_added"} {
+		if !received[expect] {
+			t.Errorf("event %s is not received: %v", expect, received)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package mirageecs
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/samber/lo"
+)
+
+const (
+	TagExpireAt = "ExpireAt"
+
+	reaperInterval = time.Minute
+)
+
+// LaunchOption is an option for launching tasks other than parameters.
+type LaunchOption struct {
+	// ExpireAt is the time when tasks will be terminated. Zero means never.
+	ExpireAt time.Time
+	// Target is the name of the ECS target to launch tasks. Empty means the default target.
+	Target string
+	// Tags are additional tags of tasks.
+	Tags map[string]string
+	// Overrides are overrides of tasks by the launch request.
+	Overrides *TaskOverrides
+	// Owner is the identity who launches tasks.
+	Owner string
+}
+
+func (o LaunchOption) toECSTags() []types.Tag {
+	var tags []types.Tag
+	if !o.ExpireAt.IsZero() {
+		tags = append(tags, expireAtTag(o.ExpireAt))
+	}
+	if o.Owner != "" {
+		tags = append(tags, types.Tag{Key: aws.String(TagOwner), Value: aws.String(o.Owner)})
+	}
+	keys := lo.Keys(o.Tags)
+	sort.Strings(keys)
+	for _, k := range keys {
+		tags = append(tags, types.Tag{Key: aws.String(k), Value: aws.String(o.Tags[k])})
+	}
+	return tags
+}
+
+func expireAtTag(t time.Time) types.Tag {
+	return types.Tag{
+		Key:   aws.String(TagExpireAt),
+		Value: aws.String(t.UTC().Format(time.RFC3339)),
+	}
+}
+
+// parseExpiration returns the expiration time from ttl (duration string, e.g. "8h") or expireAt (RFC3339).
+// Returns zero time if both are empty.
+func parseExpiration(ttl, expireAt string, now time.Time) (time.Time, error) {
+	switch {
+	case ttl != "" && expireAt != "":
+		return time.Time{}, fmt.Errorf("ttl and expire_at are exclusive")
+	case ttl != "":
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid ttl %s: %w", ttl, err)
+		}
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("ttl must be positive: %s", ttl)
+		}
+		return now.Add(d), nil
+	case expireAt != "":
+		t, err := time.Parse(time.RFC3339, expireAt)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid expire_at %s: %w", expireAt, err)
+		}
+		if !t.After(now) {
+			return time.Time{}, fmt.Errorf("expire_at must be in the future: %s", expireAt)
+		}
+		return t, nil
+	}
+	return time.Time{}, nil
+}
+
+// updateExpiration sets ExpireAt and RemainingSeconds from the ExpireAt tag.
+func (info *Information) updateExpiration(now time.Time) {
+	info.ExpireAt = nil
+	info.RemainingSeconds = 0
+	for _, t := range info.Tags {
+		if aws.ToString(t.Key) != TagExpireAt {
+			continue
+		}
+		v := aws.ToString(t.Value)
+		exp, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			slog.Warn(f("invalid %s tag %s subdomain: %s", TagExpireAt, v, info.SubDomain))
+			return
+		}
+		exp = exp.In(time.Local)
+		info.ExpireAt = &exp
+		if r := exp.Sub(now); r > 0 {
+			info.RemainingSeconds = int64(r.Seconds())
+		}
+	}
+}
+
+// Expired reports whether the task has passed its expiration.
+func (info *Information) Expired(now time.Time) bool {
+	return info.ExpireAt != nil && !info.ExpireAt.After(now)
+}
+
+// RemainingLifetime returns the remaining lifetime for display. Empty means no expiration.
+func (info *Information) RemainingLifetime() string {
+	if info.ExpireAt == nil {
+		return ""
+	}
+	if info.RemainingSeconds <= 0 {
+		return "expired"
+	}
+	m := info.RemainingSeconds / 60
+	if m == 0 {
+		return "<1m"
+	}
+	return fmt.Sprintf("%dh%02dm", m/60, m%60)
+}
@@ -0,0 +1,147 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestParseExpiration(t *testing.T) {
+	now := time.Date(2023, 3, 13, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		ttl      string
+		expireAt string
+		expect   time.Time
+		isErr    bool
+	}{
+		{"", "", time.Time{}, false},
+		{"8h", "", now.Add(8 * time.Hour), false},
+		{"", "2023-03-13T09:00:00+09:00", now, true}, // not in the future
+		{"", "2023-03-13T10:00:00+09:00", now.Add(time.Hour), false},
+		{"8h", "2023-03-13T10:00:00+09:00", time.Time{}, true},
+		{"-1h", "", time.Time{}, true},
+		{"8", "", time.Time{}, true},
+		{"", "tomorrow", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := mirageecs.ParseExpiration(tt.ttl, tt.expireAt, now)
+		if tt.isErr {
+			if err == nil {
+				t.Errorf("ttl=%s expire_at=%s: expected error", tt.ttl, tt.expireAt)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ttl=%s expire_at=%s: unexpected error %s", tt.ttl, tt.expireAt, err)
+			continue
+		}
+		if !got.Equal(tt.expect) {
+			t.Errorf("ttl=%s expire_at=%s: expected %s, got %s", tt.ttl, tt.expireAt, tt.expect, got)
+		}
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+		Domain:    "localtest.me",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	post := func(path, body string) *http.Response {
+		t.Helper()
+		res, err := ts.Client().Post(ts.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	list := func() []*mirageecs.APITaskInfo {
+		t.Helper()
+		res, err := ts.Client().Get(ts.URL + "/api/list")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var r mirageecs.APIListResponse
+		json.NewDecoder(res.Body).Decode(&r)
+		return r.Result
+	}
+
+	res := post("/api/launch", `{"subdomain":"short","taskdef":["dummy"],"branch":"main","ttl":"1h"}`)
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		t.Fatalf("launch failed: %d %s", res.StatusCode, body)
+	}
+	res = post("/api/launch", `{"subdomain":"forever","taskdef":["dummy"],"branch":"main"}`)
+	res.Body.Close()
+
+	for _, info := range list() {
+		switch info.SubDomain {
+		case "short":
+			if info.ExpireAt == nil || info.RemainingSeconds <= 3500 || info.RemainingSeconds > 3600 {
+				t.Errorf("unexpected expiration %v %d", info.ExpireAt, info.RemainingSeconds)
+			}
+		case "forever":
+			if info.ExpireAt != nil {
+				t.Errorf("forever must not have expiration %v", info.ExpireAt)
+			}
+		}
+	}
+
+	t.Run("invalid ttl", func(t *testing.T) {
+		res := post("/api/launch", `{"subdomain":"invalid","taskdef":["dummy"],"branch":"main","ttl":"forever"}`)
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("status code should be 400: %d", res.StatusCode)
+		}
+	})
+
+	t.Run("extend", func(t *testing.T) {
+		res := post("/api/extend", `{"subdomain":"short","ttl":"8h"}`)
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("extend failed: %d", res.StatusCode)
+		}
+		for _, info := range list() {
+			if info.SubDomain == "short" && info.RemainingSeconds <= 7*3600 {
+				t.Errorf("expiration is not extended %v", info.ExpireAt)
+			}
+		}
+	})
+
+	t.Run("extend not running", func(t *testing.T) {
+		res := post("/api/extend", `{"subdomain":"unknown","ttl":"8h"}`)
+		res.Body.Close()
+		if res.StatusCode != http.StatusInternalServerError {
+			t.Errorf("status code should be 500: %d", res.StatusCode)
+		}
+	})
+
+	t.Run("terminate expired", func(t *testing.T) {
+		res := post("/api/extend", `{"subdomain":"short","expire_at":"`+time.Now().Add(time.Second).Format(time.RFC3339Nano)+`"}`)
+		res.Body.Close()
+		time.Sleep(1100 * time.Millisecond)
+		if err := m.WebApi.TerminateExpired(ctx); err != nil {
+			t.Fatal(err)
+		}
+		infos := list()
+		if len(infos) != 1 || infos[0].SubDomain != "forever" {
+			t.Errorf("only forever should be running %#v", infos)
+		}
+	})
+}
@@ -1,5 +1,157 @@
 package mirageecs
 
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
 var (
-	ValidateSubdomain = validateSubdomain
+	ValidateSubdomain     = validateSubdomain
+	NewACMEManagerForTest = newACMEManager
+	ParseExpiration       = parseExpiration
+	SignWebhookPayload    = signWebhookPayload
 )
+
+func (api *WebApi) TerminateExpired(ctx context.Context) error {
+	return api.terminateExpired(ctx)
+}
+
+func (m *Mirage) Runner() TaskRunner {
+	return m.runner
+}
+
+func (api *WebApi) RunScheduledPurge(ctx context.Context) error {
+	return api.runScheduledPurge(ctx)
+}
+
+func (m *Mirage) SyncECSToMirage(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	m.syncECSToMirage(ctx, &wg)
+}
+
+func (api *WebApi) RunScaleToZero(ctx context.Context) error {
+	return api.runScaleToZero(ctx)
+}
+
+func (c *NotificationsCfg) Validate() error {
+	return c.validate()
+}
+
+func ECSTargetOwns(cfg *ECSCfg, taskArn string) bool {
+	return (&ecsTarget{cfg: cfg}).owns(taskArn)
+}
+
+func (o *TaskOverrides) CheckContainers(names []string) error {
+	return o.checkContainers(names)
+}
+
+var TaskOverridesOf = taskOverridesOf
+
+var SecretsDigest = secretsDigest
+
+func (o *TaskOverrides) Apply(ov *types.TaskOverride) {
+	o.apply(ov)
+}
+
+// LocalSecretValue returns the secret stored by the local stand-in of SSM Parameter Store.
+func (c *Config) LocalSecretValue(name string) (string, bool) {
+	s, ok := c.secretStore().(*localSecretStore)
+	if !ok {
+		return "", false
+	}
+	return s.get(name)
+}
+
+func (c *RolesCfg) RoleOf(id *Identity) string {
+	return c.roleOf(id)
+}
+
+type LogsClient = logsClient
+
+func (c *LogsCfg) Validate() error {
+	return c.validate()
+}
+
+// ContainerLogs reads logs of the container by the log source selected by its log configuration.
+func (c *LogsCfg) ContainerLogs(ctx context.Context, client LogsClient, def *types.ContainerDefinition, family, taskID string, created, since time.Time, tail int) ([]*LogEvent, error) {
+	src, err := c.logSourceOf(def, &logContainer{Container: aws.ToString(def.Name), TaskID: taskID, Family: family, created: created})
+	if err != nil || src == nil {
+		return nil, err
+	}
+	return src.events(ctx, client, since, tail)
+}
+
+func (info *Information) SetDiagnostics(task *types.Task) {
+	info.setDiagnostics(task)
+}
+
+type TaskFetcher = taskFetcher
+type TaskStateCache = taskStateCache
+
+var ParseTaskStateChange = parseTaskStateChange
+
+func (c *TaskCacheCfg) Validate() error {
+	return c.validate()
+}
+
+// NewTaskStateCache returns the cache with the clock for testing.
+func NewTaskStateCache(cfg *TaskCacheCfg, fetcher TaskFetcher, now func() time.Time) *TaskStateCache {
+	c := newTaskStateCache(cfg, fetcher)
+	c.now = now
+	return c
+}
+
+func (c *taskStateCache) List(ctx context.Context, desiredStatus string) ([]*Information, error) {
+	return c.list(ctx, desiredStatus)
+}
+
+func (c *taskStateCache) Refresh(ctx context.Context) error {
+	return c.refresh(ctx)
+}
+
+func (c *taskStateCache) MarkStale(taskArn string) {
+	c.markStale(taskArn)
+}
+
+func (c *taskStateCache) SetEvents(enabled bool) {
+	c.setEvents(enabled)
+}
+
+func SetProxyHandlerLifetime(d time.Duration) func() {
+	orig := proxyHandlerLifetime
+	proxyHandlerLifetime = d
+	return func() { proxyHandlerLifetime = orig }
+}
+
+func SetHealthCheckMaxDuration(d time.Duration) func() {
+	orig := healthCheckMaxDuration
+	healthCheckMaxDuration = d
+	return func() { healthCheckMaxDuration = orig }
+}
+
+// NewECSWithTaskFetchers returns ECS of the targets that list tasks by the fetchers.
+func NewECSWithTaskFetchers(cfg *TaskCacheCfg, fetchers ...TaskFetcher) *ECS {
+	e := &ECS{}
+	for i, fetcher := range fetchers {
+		e.targets = append(e.targets, &ecsTarget{
+			name:   fmt.Sprintf("target%d", i),
+			states: newTaskStateCache(cfg, fetcher),
+		})
+	}
+	return e
+}
+
+// UpdateInformations calls fn for each information under the lock of the runner.
+func (e *LocalTaskRunner) UpdateInformations(fn func(*Information)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, info := range e.Informations {
+		fn(info)
+	}
+}
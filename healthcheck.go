@@ -0,0 +1,109 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	HealthStatusHealthy  = "healthy"
+	HealthStatusStarting = "starting"
+	HealthStatusNoRoute  = "no_route"
+
+	healthCheckInterval = 2 * time.Second
+	healthCheckTimeout  = 3 * time.Second
+)
+
+// healthCheckMaxDuration is a duration to give up the health check.
+// The check is restarted by the next sync of routes while the handler is not ready.
+var healthCheckMaxDuration = 30 * time.Minute
+
+var healthCheckClient = &http.Client{
+	Timeout: healthCheckTimeout,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		// 3xx is healthy
+		return http.ErrUseLastResponse
+	},
+}
+
+// checkHealth returns nil if the backend responds 2xx or 3xx to the path.
+func checkHealth(ctx context.Context, u string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "mirage-ecs-health-check/"+Version)
+	res, err := healthCheckClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("unhealthy status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// startHealthCheck starts the health check unless the handler is ready or being checked.
+func (h *proxyHandler) startHealthCheck(subdomain string, destURL *url.URL, path string) {
+	if h.ready.Load() || !h.checking.CompareAndSwap(false, true) {
+		return
+	}
+	go h.runHealthCheck(subdomain, destURL, path)
+}
+
+// runHealthCheck checks the backend until it passes, and marks the handler as ready.
+func (h *proxyHandler) runHealthCheck(subdomain string, destURL *url.URL, path string) {
+	defer h.checking.Store(false)
+	u := destURL.JoinPath(path).String()
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckMaxDuration)
+	defer cancel()
+	tk := time.NewTicker(healthCheckInterval)
+	defer tk.Stop()
+	slog.Info(f("health check of subdomain %s started: %s", subdomain, u))
+	for {
+		if h.removed.Load() {
+			return
+		}
+		err := checkHealth(ctx, u)
+		if err == nil {
+			slog.Info(f("health check of subdomain %s passed: %s", subdomain, u))
+			h.ready.Store(true)
+			return
+		}
+		slog.Debug(f("health check of subdomain %s failed: %s %s", subdomain, u, err))
+		select {
+		case <-ctx.Done():
+			slog.Warn(f("health check of subdomain %s did not pass in %s: %s", subdomain, healthCheckMaxDuration, u))
+			return
+		case <-tk.C:
+		}
+	}
+}
+
+var startingPage = template.Must(template.New("starting").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>Starting {{ . }}</title>
+</head>
+<body>
+<h1>Starting {{ . }}</h1>
+<p>This environment is starting up and not ready yet. This page will be reloaded automatically.</p>
+</body>
+</html>
+`))
+
+func serveStartingPage(w http.ResponseWriter, subdomain string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Retry-After", "5")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	startingPage.Execute(w, subdomain)
+}
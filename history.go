@@ -0,0 +1,412 @@
+package mirageecs
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	HistoryActionLaunch    = "launch"
+	HistoryActionTerminate = "terminate"
+	HistoryActionPurge     = "purge"
+	HistoryActionExtend    = "extend"
+	HistoryActionExpire    = "expire"
+	HistoryActionSleep     = "sleep"
+	HistoryActionWake      = "wake"
+
+	HistoryResultSucceeded = "succeeded"
+	HistoryResultFailed    = "failed"
+
+	DefaultHistoryLimit    = 100
+	MaxHistoryLimit        = 1000
+	memoryHistoryStoreSize = 1000
+
+	DefaultHistoryIndex = "requested_at-index"
+
+	// historyKind is the partition key of the index on requested_at. All the records have the same value.
+	historyKind = "history"
+	// sleepingKind and sleepingID are the keys of sleeping subdomains in the dynamodb table.
+	sleepingKind = "sleeping"
+	sleepingID   = "sleeping"
+	// historyTimeFormat is a fixed width format to sort requested_at as strings.
+	historyTimeFormat = "2006-01-02T15:04:05.000000000Z"
+)
+
+// HistoryRecord is a record of launch and terminate operations.
+type HistoryRecord struct {
+	ID          string            `json:"id"`
+	Action      string            `json:"action"`
+	Subdomain   string            `json:"subdomain"`
+	TaskID      string            `json:"task_id,omitempty"`
+	Actor       string            `json:"actor"`
+	Parameters  map[string]string `json:"parameters,omitempty"`
+	Taskdefs    []string          `json:"taskdefs,omitempty"`
+	Result      string            `json:"result"`
+	Error       string            `json:"error,omitempty"`
+	RequestedAt time.Time         `json:"requested_at"`
+	CompletedAt time.Time         `json:"completed_at"`
+}
+
+func newHistoryRecord(action, subdomain string, actor *Identity) *HistoryRecord {
+	now := time.Now()
+	return &HistoryRecord{
+		// sortable by time
+		ID:          fmt.Sprintf("%019d-%s", now.UnixNano(), generateRandomHexID(8)),
+		Action:      action,
+		Subdomain:   subdomain,
+		Actor:       actor.String(),
+		RequestedAt: now,
+	}
+}
+
+// complete sets the result of the operation.
+func (r *HistoryRecord) complete(err error) {
+	r.CompletedAt = time.Now()
+	if err != nil {
+		r.Result = HistoryResultFailed
+		r.Error = err.Error()
+	} else {
+		r.Result = HistoryResultSucceeded
+	}
+}
+
+// HistoryStore stores HistoryRecords.
+type HistoryStore interface {
+	Put(ctx context.Context, r *HistoryRecord) error
+	// List returns records in reverse chronological order. Empty subdomain means all subdomains.
+	List(ctx context.Context, subdomain string, limit int) ([]*HistoryRecord, error)
+	Close() error
+}
+
+type HistoryCfg struct {
+	Store string `yaml:"store"` // memory (default), bolt or dynamodb
+	Path  string `yaml:"path"`  // file path for bolt
+	Table string `yaml:"table"` // table name for dynamodb
+	Index string `yaml:"index"` // global secondary index on requested_at for dynamodb
+}
+
+func (c *Config) newHistoryStore() (HistoryStore, error) {
+	switch c.History.Store {
+	case "", "memory":
+		return NewMemoryHistoryStore(memoryHistoryStoreSize), nil
+	case "bolt":
+		if c.History.Path == "" {
+			return nil, fmt.Errorf("history.path is required for bolt store")
+		}
+		return NewBoltHistoryStore(c.History.Path)
+	case "dynamodb":
+		if c.History.Table == "" {
+			return nil, fmt.Errorf("history.table is required for dynamodb store")
+		}
+		index := c.History.Index
+		if index == "" {
+			index = DefaultHistoryIndex
+		}
+		return NewDynamoDBHistoryStore(dynamodb.NewFromConfig(*c.awscfg), c.History.Table, index), nil
+	default:
+		return nil, fmt.Errorf("unknown history.store %s", c.History.Store)
+	}
+}
+
+// HistoryStore returns the store of launch history.
+func (c *Config) HistoryStore() HistoryStore {
+	if c.history == nil {
+		// Config is not created by NewConfig
+		c.history = NewMemoryHistoryStore(memoryHistoryStoreSize)
+	}
+	return c.history
+}
+
+// MemoryHistoryStore keeps the latest records in memory. Records are lost at restart.
+type MemoryHistoryStore struct {
+	mu      sync.RWMutex
+	size    int
+	records []*HistoryRecord
+}
+
+func NewMemoryHistoryStore(size int) *MemoryHistoryStore {
+	return &MemoryHistoryStore{size: size}
+}
+
+func (s *MemoryHistoryStore) Put(_ context.Context, r *HistoryRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, r)
+	if len(s.records) > s.size {
+		s.records = s.records[len(s.records)-s.size:]
+	}
+	return nil
+}
+
+func (s *MemoryHistoryStore) List(_ context.Context, subdomain string, limit int) ([]*HistoryRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rs := []*HistoryRecord{}
+	for i := len(s.records) - 1; i >= 0 && len(rs) < limit; i-- {
+		if subdomain == "" || s.records[i].Subdomain == subdomain {
+			rs = append(rs, s.records[i])
+		}
+	}
+	return rs, nil
+}
+
+func (s *MemoryHistoryStore) Close() error {
+	return nil
+}
+
+var (
+	boltHistoryBucket  = []byte("history")
+	boltSleepingBucket = []byte("sleeping")
+)
+
+// BoltHistoryStore stores records in a local bbolt database file.
+type BoltHistoryStore struct {
+	db *bolt.DB
+}
+
+func NewBoltHistoryStore(path string) (*BoltHistoryStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open history db %s: %w", path, err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltHistoryBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltSleepingBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltHistoryStore{db: db}, nil
+}
+
+func (s *BoltHistoryStore) Put(_ context.Context, r *HistoryRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltHistoryBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return bucket.Put(key, b)
+	})
+}
+
+func (s *BoltHistoryStore) List(_ context.Context, subdomain string, limit int) ([]*HistoryRecord, error) {
+	rs := []*HistoryRecord{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltHistoryBucket).Cursor()
+		for k, v := c.Last(); k != nil && len(rs) < limit; k, v = c.Prev() {
+			var r HistoryRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				slog.Warn(f("invalid history record %x: %s", k, err))
+				continue
+			}
+			if subdomain == "" || r.Subdomain == subdomain {
+				rs = append(rs, &r)
+			}
+		}
+		return nil
+	})
+	return rs, err
+}
+
+func (s *BoltHistoryStore) PutSleeping(_ context.Context, sl *SleepingSubdomain) error {
+	b, err := marshalSleeping(sl)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltSleepingBucket).Put([]byte(sl.Subdomain), b)
+	})
+}
+
+func (s *BoltHistoryStore) DeleteSleeping(_ context.Context, subdomain string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltSleepingBucket).Delete([]byte(subdomain))
+	})
+}
+
+func (s *BoltHistoryStore) ListSleeping(_ context.Context) ([]*SleepingSubdomain, error) {
+	var sls []*SleepingSubdomain
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltSleepingBucket).ForEach(func(k, v []byte) error {
+			sl, err := unmarshalSleeping(v)
+			if err != nil {
+				slog.Warn(f("invalid sleeping subdomain %s: %s", k, err))
+				return nil
+			}
+			sls = append(sls, sl)
+			return nil
+		})
+	})
+	return sls, err
+}
+
+func (s *BoltHistoryStore) Close() error {
+	return s.db.Close()
+}
+
+// dynamoDBClient is a subset of the DynamoDB API used by DynamoDBHistoryStore.
+type dynamoDBClient interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+// DynamoDBHistoryStore stores records in a DynamoDB table.
+// The table must have a partition key "subdomain" (S) and a sort key "id" (S),
+// and a global secondary index with a partition key "kind" (S) and a sort key "requested_at" (S) to list all subdomains.
+// Sleeping subdomains are stored in the same table with the id "sleeping".
+type DynamoDBHistoryStore struct {
+	svc   dynamoDBClient
+	table string
+	index string
+}
+
+func NewDynamoDBHistoryStore(svc dynamoDBClient, table, index string) *DynamoDBHistoryStore {
+	return &DynamoDBHistoryStore{svc: svc, table: table, index: index}
+}
+
+func (s *DynamoDBHistoryStore) Put(ctx context.Context, r *HistoryRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = s.svc.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]ddbTypes.AttributeValue{
+			"subdomain":    &ddbTypes.AttributeValueMemberS{Value: r.Subdomain},
+			"id":           &ddbTypes.AttributeValueMemberS{Value: r.ID},
+			"kind":         &ddbTypes.AttributeValueMemberS{Value: historyKind},
+			"requested_at": &ddbTypes.AttributeValueMemberS{Value: r.RequestedAt.UTC().Format(historyTimeFormat)},
+			"record":       &ddbTypes.AttributeValueMemberS{Value: string(b)},
+		},
+	})
+	return err
+}
+
+func (s *DynamoDBHistoryStore) List(ctx context.Context, subdomain string, limit int) ([]*HistoryRecord, error) {
+	in := &dynamodb.QueryInput{
+		TableName:              aws.String(s.table),
+		KeyConditionExpression: aws.String("subdomain = :s"),
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":s": &ddbTypes.AttributeValueMemberS{Value: subdomain},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(int32(limit)),
+	}
+	if subdomain == "" {
+		// the latest records of all subdomains by the index on requested_at
+		in.IndexName = aws.String(s.index)
+		in.KeyConditionExpression = aws.String("kind = :k")
+		in.ExpressionAttributeValues = map[string]ddbTypes.AttributeValue{
+			":k": &ddbTypes.AttributeValueMemberS{Value: historyKind},
+		}
+	}
+	rs := make([]*HistoryRecord, 0, limit)
+	p := dynamodb.NewQueryPaginator(s.svc, in)
+	for p.HasMorePages() && len(rs) < limit {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			v, ok := item["record"].(*ddbTypes.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+			var r HistoryRecord
+			if err := json.Unmarshal([]byte(v.Value), &r); err != nil {
+				slog.Warn(f("invalid history record: %s", err))
+				continue
+			}
+			rs = append(rs, &r)
+		}
+	}
+	if len(rs) > limit {
+		rs = rs[:limit]
+	}
+	return rs, nil
+}
+
+func (s *DynamoDBHistoryStore) PutSleeping(ctx context.Context, sl *SleepingSubdomain) error {
+	b, err := marshalSleeping(sl)
+	if err != nil {
+		return err
+	}
+	_, err = s.svc.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]ddbTypes.AttributeValue{
+			"subdomain":    &ddbTypes.AttributeValueMemberS{Value: sl.Subdomain},
+			"id":           &ddbTypes.AttributeValueMemberS{Value: sleepingID},
+			"kind":         &ddbTypes.AttributeValueMemberS{Value: sleepingKind},
+			"requested_at": &ddbTypes.AttributeValueMemberS{Value: sl.SleptAt.UTC().Format(historyTimeFormat)},
+			"sleeping":     &ddbTypes.AttributeValueMemberS{Value: string(b)},
+		},
+	})
+	return err
+}
+
+func (s *DynamoDBHistoryStore) DeleteSleeping(ctx context.Context, subdomain string) error {
+	_, err := s.svc.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]ddbTypes.AttributeValue{
+			"subdomain": &ddbTypes.AttributeValueMemberS{Value: subdomain},
+			"id":        &ddbTypes.AttributeValueMemberS{Value: sleepingID},
+		},
+	})
+	return err
+}
+
+func (s *DynamoDBHistoryStore) ListSleeping(ctx context.Context) ([]*SleepingSubdomain, error) {
+	var sls []*SleepingSubdomain
+	p := dynamodb.NewQueryPaginator(s.svc, &dynamodb.QueryInput{
+		TableName:              aws.String(s.table),
+		IndexName:              aws.String(s.index),
+		KeyConditionExpression: aws.String("kind = :k"),
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":k": &ddbTypes.AttributeValueMemberS{Value: sleepingKind},
+		},
+	})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			v, ok := item["sleeping"].(*ddbTypes.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+			sl, err := unmarshalSleeping([]byte(v.Value))
+			if err != nil {
+				slog.Warn(f("invalid sleeping subdomain: %s", err))
+				continue
+			}
+			sls = append(sls, sl)
+		}
+	}
+	return sls, nil
+}
+
+func (s *DynamoDBHistoryStore) Close() error {
+	return nil
+}
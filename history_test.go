@@ -0,0 +1,242 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func testHistoryStore(t *testing.T, store mirageecs.HistoryStore) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, sub := range []string{"foo", "bar", "foo", "baz", "foo"} {
+		r := &mirageecs.HistoryRecord{
+			ID:          string(rune('a' + i)),
+			Action:      mirageecs.HistoryActionLaunch,
+			Subdomain:   sub,
+			RequestedAt: now.Add(time.Duration(i) * time.Second),
+		}
+		if err := store.Put(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rs, err := store.List(ctx, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 5 || rs[0].ID != "e" || rs[4].ID != "a" {
+		t.Errorf("unexpected records %#v", rs)
+	}
+	rs, err = store.List(ctx, "foo", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 2 || rs[0].ID != "e" || rs[1].ID != "c" {
+		t.Errorf("unexpected records for foo %#v", rs)
+	}
+	rs, err = store.List(ctx, "unknown", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 0 {
+		t.Errorf("records for unknown must be empty %#v", rs)
+	}
+}
+
+func testSleepingStore(t *testing.T, store mirageecs.SleepingStore) {
+	ctx := context.Background()
+	expireAt := time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)
+	for _, sub := range []string{"foo", "bar", "foo"} {
+		sl := &mirageecs.SleepingSubdomain{
+			Subdomain: sub,
+			Parameter: mirageecs.TaskParameter{"branch": sub},
+			Taskdefs:  []string{"app:1"},
+			Option:    mirageecs.LaunchOption{ExpireAt: expireAt, Owner: "basic:alice"},
+			SleptAt:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		}
+		if err := store.PutSleeping(ctx, sl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.DeleteSleeping(ctx, "bar"); err != nil {
+		t.Fatal(err)
+	}
+	sls, err := store.ListSleeping(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sls) != 1 || sls[0].Subdomain != "foo" || sls[0].Parameter["branch"] != "foo" {
+		t.Fatalf("unexpected sleeping subdomains %#v", sls)
+	}
+	if o := sls[0].Option; !o.ExpireAt.Equal(expireAt) || o.Owner != "basic:alice" {
+		t.Errorf("the launch option must be restored %#v", o)
+	}
+}
+
+func TestMemoryHistoryStore(t *testing.T) {
+	testHistoryStore(t, mirageecs.NewMemoryHistoryStore(10))
+}
+
+func TestMemoryHistoryStoreSize(t *testing.T) {
+	store := mirageecs.NewMemoryHistoryStore(2)
+	ctx := context.Background()
+	for _, id := range []string{"a", "b", "c"} {
+		store.Put(ctx, &mirageecs.HistoryRecord{ID: id, Subdomain: "foo"})
+	}
+	rs, _ := store.List(ctx, "", 10)
+	if len(rs) != 2 || rs[0].ID != "c" || rs[1].ID != "b" {
+		t.Errorf("unexpected records %#v", rs)
+	}
+}
+
+func TestBoltHistoryStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := mirageecs.NewBoltHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testHistoryStore(t, store)
+	testSleepingStore(t, store)
+	store.Close()
+
+	// records are persisted
+	store, err = mirageecs.NewBoltHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	rs, err := store.List(context.Background(), "bar", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 1 || rs[0].ID != "b" {
+		t.Errorf("unexpected records %#v", rs)
+	}
+	if sls, _ := store.ListSleeping(context.Background()); len(sls) != 1 || sls[0].Subdomain != "foo" {
+		t.Errorf("unexpected sleeping subdomains %#v", sls)
+	}
+}
+
+// fakeDynamoDB emulates the table and the index on requested_at. It returns 2 items per page.
+type fakeDynamoDB struct {
+	items   []map[string]ddbTypes.AttributeValue
+	queries []*dynamodb.QueryInput
+}
+
+func (d *fakeDynamoDB) PutItem(ctx context.Context, in *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	d.DeleteItem(ctx, &dynamodb.DeleteItemInput{Key: in.Item})
+	d.items = append(d.items, in.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (d *fakeDynamoDB) DeleteItem(_ context.Context, in *dynamodb.DeleteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	str := func(v ddbTypes.AttributeValue) string {
+		return v.(*ddbTypes.AttributeValueMemberS).Value
+	}
+	for i, item := range d.items {
+		if str(item["subdomain"]) == str(in.Key["subdomain"]) && str(item["id"]) == str(in.Key["id"]) {
+			d.items = append(d.items[:i], d.items[i+1:]...)
+			break
+		}
+	}
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func (d *fakeDynamoDB) Query(_ context.Context, in *dynamodb.QueryInput, _ ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	d.queries = append(d.queries, in)
+	str := func(v ddbTypes.AttributeValue) string {
+		return v.(*ddbTypes.AttributeValueMemberS).Value
+	}
+	pk, sk := "subdomain", "id"
+	if in.IndexName != nil {
+		pk, sk = "kind", "requested_at"
+	}
+	var items []map[string]ddbTypes.AttributeValue
+	for _, item := range d.items {
+		for _, v := range in.ExpressionAttributeValues {
+			if str(item[pk]) == str(v) {
+				items = append(items, item)
+			}
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return str(items[i][sk]) > str(items[j][sk])
+	})
+	offset := 0
+	if in.ExclusiveStartKey != nil {
+		offset, _ = strconv.Atoi(str(in.ExclusiveStartKey["offset"]))
+	}
+	out := &dynamodb.QueryOutput{}
+	end := min(offset+2, len(items))
+	out.Items = items[offset:end]
+	if end < len(items) {
+		out.LastEvaluatedKey = map[string]ddbTypes.AttributeValue{
+			"offset": &ddbTypes.AttributeValueMemberS{Value: strconv.Itoa(end)},
+		}
+	}
+	return out, nil
+}
+
+func TestDynamoDBHistoryStore(t *testing.T) {
+	svc := &fakeDynamoDB{}
+	store := mirageecs.NewDynamoDBHistoryStore(svc, "history", "requested_at-index")
+	testSleepingStore(t, store)
+	svc.queries = nil
+	// sleeping subdomains are not listed in the history
+	testHistoryStore(t, store)
+	for _, in := range svc.queries {
+		if aws.ToBool(in.ScanIndexForward) {
+			t.Error("records must be queried in descending order")
+		}
+	}
+	if in := svc.queries[0]; aws.ToString(in.IndexName) != "requested_at-index" {
+		t.Errorf("records of all subdomains must be queried by the index: %#v", in)
+	}
+}
+
+func TestTerminateByIDHistory(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := newTestConfig(t, "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+	results, err := m.Runner().Launch(ctx, "byid", mirageecs.TaskParameter{"branch": "main"}, mirageecs.LaunchOption{}, "dummy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ts.Client().Post(ts.URL+"/api/terminate", "application/json", strings.NewReader(`{"id":"`+results[0].ID+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", res.StatusCode)
+	}
+
+	res, err = ts.Client().Get(ts.URL + "/api/history?subdomain=byid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var r mirageecs.APIHistoryResponse
+	json.NewDecoder(res.Body).Decode(&r)
+	if len(r.Result) != 1 || r.Result[0].Action != mirageecs.HistoryActionTerminate || r.Result[0].TaskID != results[0].ID {
+		t.Errorf("termination by id should be recorded with the subdomain %#v", r.Result)
+	}
+}
@@ -0,0 +1,170 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+const (
+	LaunchStatusRunning = "running"
+	LaunchStatusStopped = "stopped"
+	LaunchStatusTimeout = "timeout"
+
+	DefaultLaunchWaitTimeout = 5 * time.Minute
+	MaxLaunchWaitTimeout     = 30 * time.Minute
+
+	launchWaitInterval = 5 * time.Second
+)
+
+// parseWaitTimeout returns the timeout of launch with wait=true.
+func parseWaitTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return DefaultLaunchWaitTimeout, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid wait_timeout %s: %w", s, err)
+	}
+	if d <= 0 || d > MaxLaunchWaitTimeout {
+		return 0, fmt.Errorf("wait_timeout must be in (0, %s]: %s", MaxLaunchWaitTimeout, s)
+	}
+	return d, nil
+}
+
+// launchWaiter finds tasks launched for the subdomain.
+type launchWaiter struct {
+	subdomain string
+	ids       map[string]struct{}
+}
+
+func newLaunchWaiter(subdomain string, results []*LaunchResult) *launchWaiter {
+	w := &launchWaiter{
+		subdomain: subdomain,
+		ids:       make(map[string]struct{}, len(results)),
+	}
+	for _, id := range launchedTaskIDs(results) {
+		w.ids[id] = struct{}{}
+	}
+	return w
+}
+
+// listTasks returns running (including pending) and stopped tasks of the subdomain.
+func (api *WebApi) listTasks(ctx context.Context, subdomain string) ([]*Information, error) {
+	var infos []*Information
+	for _, status := range []string{statusRunning, statusStopped} {
+		is, err := api.runner.List(ctx, status)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, lo.Filter(is, func(info *Information, _ int) bool {
+			return info.SubDomain == subdomain
+		})...)
+	}
+	return infos, nil
+}
+
+// launched returns the tasks launched by the waiting request.
+func (w *launchWaiter) launched(infos []*Information) []*Information {
+	return lo.Filter(infos, func(info *Information, _ int) bool {
+		_, ok := w.ids[info.ID]
+		return ok
+	})
+}
+
+// launchStatus returns the final status of the tasks. Empty means not yet.
+func launchStatus(infos []*Information, count int) string {
+	if len(infos) < count {
+		return ""
+	}
+	if lo.SomeBy(infos, func(info *Information) bool { return info.LastStatus == statusStopped }) {
+		return LaunchStatusStopped
+	}
+	if lo.EveryBy(infos, func(info *Information) bool {
+		// tasks without ports are never routed
+		return info.LastStatus == statusRunning && (info.Ready || len(info.PortMap) == 0)
+	}) {
+		return LaunchStatusRunning
+	}
+	return ""
+}
+
+// waitLaunch waits until all the launched tasks are running and routed, or any of them is stopped.
+func (api *WebApi) waitLaunch(ctx context.Context, w *launchWaiter, timeout time.Duration) (int, *APILaunchResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	tk := time.NewTicker(launchWaitInterval)
+	defer tk.Stop()
+
+	var infos []*Information
+	for {
+		if all, err := api.listTasks(ctx, w.subdomain); err != nil {
+			slog.Warn(f("failed to list tasks of %s: %s", w.subdomain, err))
+		} else {
+			infos = w.launched(all)
+			api.setHealthStatus(infos)
+			if status := launchStatus(infos, len(w.ids)); status != "" {
+				return launchWaitResponse(status, infos)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return launchWaitResponse(LaunchStatusTimeout, infos)
+		case <-tk.C:
+		}
+	}
+}
+
+func launchWaitResponse(status string, infos []*Information) (int, *APILaunchResponse, error) {
+	res := &APILaunchResponse{
+		Result: "ok",
+		Status: status,
+		Tasks:  lo.Map(infos, func(info *Information, _ int) *APILaunchedTask { return newAPILaunchedTask(info) }),
+	}
+	switch status {
+	case LaunchStatusStopped:
+		err := fmt.Errorf("launched tasks are stopped")
+		res.Result = err.Error()
+		return http.StatusInternalServerError, res, err
+	case LaunchStatusTimeout:
+		err := fmt.Errorf("timed out waiting for launched tasks")
+		res.Result = err.Error()
+		return http.StatusGatewayTimeout, res, err
+	}
+	return http.StatusOK, res, nil
+}
+
+// launchedTaskIDs returns ARNs of the launched tasks.
+func launchedTaskIDs(results []*LaunchResult) []string {
+	return lo.FilterMap(results, func(r *LaunchResult, _ int) (string, bool) {
+		return r.ID, r.ID != "" && !r.RolledBack
+	})
+}
+
+func newAPILaunchedTask(info *Information) *APILaunchedTask {
+	t := &APILaunchedTask{
+		ID:            info.ID,
+		ShortID:       info.ShortID,
+		TaskDef:       info.TaskDef,
+		IPAddress:     info.IPAddress,
+		PortMap:       info.PortMap,
+		LastStatus:    info.LastStatus,
+		Ready:         info.Ready,
+		StoppedReason: info.StoppedReason,
+	}
+	if info.task != nil {
+		for _, c := range info.task.Containers {
+			t.Containers = append(t.Containers, &APIContainerStatus{
+				Name:       lo.FromPtr(c.Name),
+				LastStatus: lo.FromPtr(c.LastStatus),
+				ExitCode:   c.ExitCode,
+				Reason:     lo.FromPtr(c.Reason),
+			})
+		}
+	}
+	return t
+}
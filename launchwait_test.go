@@ -0,0 +1,76 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestLaunchWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+		Domain:    "localtest.me",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	go m.SyncECSToMirage(ctx)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	launch := func(body string) (int, *mirageecs.APILaunchResponse) {
+		t.Helper()
+		res, err := ts.Client().Post(ts.URL+"/api/launch", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var r mirageecs.APILaunchResponse
+		if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, &r
+	}
+
+	if code, r := launch(`{"subdomain":"mytask","taskdef":["dummy"],"branch":"main","wait":true,"wait_timeout":"1h"}`); code != http.StatusBadRequest {
+		t.Errorf("unexpected response %d %#v", code, r)
+	}
+
+	// relaunch the same subdomain. the old task must not be waited.
+	for i := 0; i < 2; i++ {
+		code, r := launch(`{"subdomain":"mytask","taskdef":["dummy"],"branch":"main","wait":true,"wait_timeout":"30s"}`)
+		if code != http.StatusOK {
+			t.Fatalf("unexpected response %d %#v", code, r)
+		}
+		if r.Status != mirageecs.LaunchStatusRunning || len(r.Tasks) != 1 {
+			t.Fatalf("unexpected response %#v", r)
+		}
+		task := r.Tasks[0]
+		if task.LastStatus != "RUNNING" || !task.Ready || task.IPAddress != "127.0.0.1" || task.PortMap["httpd"] == 0 {
+			t.Errorf("unexpected task %#v", task)
+		}
+		if len(r.Launched) != 1 || r.Launched[0].ID != task.ID {
+			t.Errorf("unexpected launched %#v", r.Launched)
+		}
+	}
+
+	// without wait
+	code, r := launch(`{"subdomain":"mytask2","taskdef":["dummy"],"branch":"main"}`)
+	if code != http.StatusOK || r.Result != "ok" || r.Status != "" || len(r.Tasks) != 0 {
+		t.Errorf("unexpected response %d %#v", code, r)
+	}
+	if len(r.Launched) != 1 {
+		t.Fatalf("unexpected launched %#v", r.Launched)
+	}
+	if l := r.Launched[0]; l.TaskDef != "dummy" || !strings.HasPrefix(l.ID, "arn:aws:ecs:") || !strings.HasSuffix(l.ID, "/"+l.ShortID) {
+		t.Errorf("unexpected launched %#v", l)
+	}
+}
@@ -0,0 +1,226 @@
+package mirageecs
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/lo"
+)
+
+const (
+	ListStatusRunning = "running"
+	ListStatusStopped = "stopped"
+	ListStatusAll     = "all"
+
+	ListSortSubdomain = "subdomain"
+	ListSortCreated   = "created"
+	ListSortExpireAt  = "expire_at"
+
+	MaxListLimit = 1000
+
+	listSortTimeFormat = "2006-01-02T15:04:05.000000000Z"
+)
+
+// listQuery is a filter, an order and a page of /api/list.
+type listQuery struct {
+	status    string
+	subdomain string // glob pattern
+	branch    string
+	family    string
+	owner     string
+	tags      map[string]*string // nil value matches any values
+	secrets   *SecretsCfg
+
+	sortKey string
+	desc    bool
+	limit   int
+	after   *listCursor
+}
+
+// listCursor points the last item of the previous page.
+// Query is the digest of the query that issued the cursor, because the cursor is meaningless for other sorts and filters.
+type listCursor struct {
+	Key   string `json:"k"`
+	ID    string `json:"id"`
+	Query string `json:"q"`
+}
+
+func (c *listCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeListCursor(s string) (*listCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var c listCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &c, nil
+}
+
+// parseListQuery parses query parameters of /api/list.
+// Filters by values masked in responses are refused not to reveal them.
+func parseListQuery(c echo.Context, cfg *Config) (*listQuery, error) {
+	r := cfg.Redactor()
+	q := &listQuery{
+		status:    ListStatusRunning,
+		subdomain: c.QueryParam("subdomain"),
+		branch:    c.QueryParam("branch"),
+		family:    c.QueryParam("family"),
+		owner:     c.QueryParam("owner"),
+		sortKey:   ListSortSubdomain,
+		secrets:   cfg.Secrets,
+	}
+	if c.QueryParam("include_stopped") == "true" {
+		q.status = ListStatusAll
+	}
+	if s := c.QueryParam("status"); s != "" {
+		if !lo.Contains([]string{ListStatusRunning, ListStatusStopped, ListStatusAll}, s) {
+			return nil, fmt.Errorf("invalid status %s", s)
+		}
+		q.status = s
+	}
+	if q.subdomain != "" {
+		if _, err := path.Match(q.subdomain, ""); err != nil {
+			return nil, fmt.Errorf("invalid subdomain pattern %s", q.subdomain)
+		}
+	}
+	if q.branch != "" && r.IsSensitiveEnv(DefaultParameter.Env) {
+		return nil, fmt.Errorf("branch is sensitive and cannot be filtered")
+	}
+	for _, t := range c.QueryParams()["tag"] {
+		if q.tags == nil {
+			q.tags = map[string]*string{}
+		}
+		k, v, found := strings.Cut(t, ":")
+		if k == "" {
+			return nil, fmt.Errorf("invalid tag %s", t)
+		}
+		if r.IsSensitiveTag(k) {
+			return nil, fmt.Errorf("tag %s is sensitive and cannot be filtered", k)
+		}
+		q.tags[k] = lo.Ternary(found, &v, nil)
+	}
+	if s := c.QueryParam("sort"); s != "" {
+		q.desc = strings.HasPrefix(s, "-")
+		q.sortKey = strings.TrimPrefix(s, "-")
+		if !lo.Contains([]string{ListSortSubdomain, ListSortCreated, ListSortExpireAt}, q.sortKey) {
+			return nil, fmt.Errorf("invalid sort %s", s)
+		}
+	}
+	if s := c.QueryParam("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MaxListLimit {
+			return nil, fmt.Errorf("limit must be 1-%d", MaxListLimit)
+		}
+		q.limit = n
+	}
+	if s := c.QueryParam("cursor"); s != "" {
+		cur, err := decodeListCursor(s)
+		if err != nil {
+			return nil, err
+		}
+		if cur.Query != q.digest() {
+			return nil, fmt.Errorf("cursor does not match the status, the filters or the sort")
+		}
+		q.after = cur
+	}
+	return q, nil
+}
+
+// digest returns a digest of the status, the filters and the sort of the query.
+func (q *listQuery) digest() string {
+	b, _ := json.Marshal([]interface{}{q.status, q.subdomain, q.branch, q.family, q.owner, q.tags, q.sortKey, q.desc})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// statuses returns the task statuses to list.
+func (q *listQuery) statuses() []string {
+	switch q.status {
+	case ListStatusStopped:
+		return []string{statusStopped}
+	case ListStatusAll:
+		return []string{statusRunning, statusStopped}
+	}
+	return []string{statusRunning}
+}
+
+func (q *listQuery) match(info *Information) bool {
+	if q.subdomain != "" {
+		if ok, _ := path.Match(q.subdomain, info.SubDomain); !ok {
+			return false
+		}
+	}
+	if q.branch != "" && info.GitBranch != q.branch {
+		return false
+	}
+	if q.family != "" && q.secrets.familyOf(info.TaskDef) != q.family {
+		return false
+	}
+	if q.owner != "" && info.Owner != q.owner {
+		return false
+	}
+	for k, v := range q.tags {
+		t, ok := lo.Find(info.Tags, func(t types.Tag) bool { return aws.ToString(t.Key) == k })
+		if !ok || (v != nil && aws.ToString(t.Value) != *v) {
+			return false
+		}
+	}
+	return true
+}
+
+// sortKeyOf returns the sort key of the information as a string.
+func (q *listQuery) sortKeyOf(info *Information) string {
+	switch q.sortKey {
+	case ListSortCreated:
+		return info.Created.UTC().Format(listSortTimeFormat)
+	case ListSortExpireAt:
+		if info.ExpireAt == nil {
+			return ""
+		}
+		return info.ExpireAt.UTC().Format(listSortTimeFormat)
+	}
+	return info.SubDomain
+}
+
+// less compares two items by the sort key and the task ID.
+func (q *listQuery) less(ak, aid, bk, bid string) bool {
+	if ak == bk {
+		return lo.Ternary(q.desc, aid > bid, aid < bid)
+	}
+	return lo.Ternary(q.desc, ak > bk, ak < bk)
+}
+
+// apply filters, sorts and pages the informations. It returns the cursor of the next page if exists.
+func (q *listQuery) apply(infos []*Information) ([]*Information, string) {
+	infos = lo.Filter(infos, func(info *Information, _ int) bool { return q.match(info) })
+	sort.SliceStable(infos, func(i, j int) bool {
+		return q.less(q.sortKeyOf(infos[i]), infos[i].ID, q.sortKeyOf(infos[j]), infos[j].ID)
+	})
+	if q.after != nil {
+		infos = lo.Filter(infos, func(info *Information, _ int) bool {
+			return q.less(q.after.Key, q.after.ID, q.sortKeyOf(info), info.ID)
+		})
+	}
+	if q.limit == 0 || len(infos) <= q.limit {
+		return infos, ""
+	}
+	infos = infos[:q.limit]
+	last := infos[len(infos)-1]
+	return infos, (&listCursor{Key: q.sortKeyOf(last), ID: last.ID, Query: q.digest()}).encode()
+}
@@ -0,0 +1,159 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestApiListQuery(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := newTestConfig(t, `parameters:
+  - name: team
+    env: TEAM
+  - name: api_key
+    env: API_KEY
+    sensitive: true
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+	runner := m.Runner()
+
+	launches := []struct {
+		subdomain string
+		branch    string
+		team      string
+		taskdef   string
+		owner     string
+	}{
+		{"pr-1", "feature/a", "red", "app:1", "basic:alice"},
+		{"pr-2", "feature/b", "blue", "app:2", "basic:bob"},
+		{"pr-3", "feature/a", "red", "worker:1", "basic:alice"},
+		{"main", "main", "blue", "app:3", "token:ci"},
+	}
+	for _, l := range launches {
+		param := mirageecs.TaskParameter{"branch": l.branch, "team": l.team}
+		if _, err := runner.Launch(ctx, l.subdomain, param, mirageecs.LaunchOption{Owner: l.owner}, l.taskdef); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := runner.TerminateBySubdomain(ctx, "pr-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(query url.Values) (int, []string, string) {
+		t.Helper()
+		res, err := ts.Client().Get(ts.URL + "/api/list?" + query.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return res.StatusCode, nil, ""
+		}
+		var r mirageecs.APIListResponse
+		if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		var subdomains []string
+		for _, info := range r.Result {
+			subdomains = append(subdomains, info.SubDomain)
+		}
+		return res.StatusCode, subdomains, r.NextCursor
+	}
+
+	tests := []struct {
+		name  string
+		query url.Values
+		want  string
+	}{
+		{"default", url.Values{}, "main,pr-1,pr-3"},
+		{"stopped", url.Values{"status": {"stopped"}}, "pr-2"},
+		{"all", url.Values{"status": {"all"}}, "main,pr-1,pr-2,pr-3"},
+		{"subdomain glob", url.Values{"subdomain": {"pr-*"}}, "pr-1,pr-3"},
+		{"branch", url.Values{"branch": {"feature/a"}}, "pr-1,pr-3"},
+		{"family", url.Values{"family": {"app"}, "status": {"all"}}, "main,pr-1,pr-2"},
+		{"owner", url.Values{"owner": {"basic:alice"}}, "pr-1,pr-3"},
+		{"tag value", url.Values{"tag": {"team:blue"}, "status": {"all"}}, "main,pr-2"},
+		{"tag key", url.Values{"tag": {"team"}}, "main,pr-1,pr-3"},
+		{"tags", url.Values{"tag": {"team:red", "branch:feature/a"}, "family": {"worker"}}, "pr-3"},
+		{"sort desc", url.Values{"sort": {"-subdomain"}}, "pr-3,pr-1,main"},
+		{"sort created", url.Values{"sort": {"created"}}, "pr-1,pr-3,main"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, got, _ := list(tt.query)
+			if code != http.StatusOK {
+				t.Fatalf("unexpected status %d", code)
+			}
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("got %v, want %s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("pagination", func(t *testing.T) {
+		var all []string
+		query := url.Values{"status": {"all"}, "limit": {"3"}}
+		for i := 0; i < 3; i++ {
+			_, got, next := list(query)
+			all = append(all, got...)
+			if next == "" {
+				break
+			}
+			query.Set("cursor", next)
+		}
+		if strings.Join(all, ",") != "main,pr-1,pr-2,pr-3" {
+			t.Errorf("unexpected pages %v", all)
+		}
+	})
+
+	t.Run("cursor of another query", func(t *testing.T) {
+		_, _, next := list(url.Values{"status": {"all"}, "limit": {"1"}})
+		for _, query := range []url.Values{
+			{"status": {"all"}, "limit": {"1"}, "sort": {"-subdomain"}},
+			{"status": {"all"}, "limit": {"1"}, "sort": {"created"}},
+			{"status": {"running"}, "limit": {"1"}},
+			{"status": {"all"}, "limit": {"1"}, "tag": {"team"}},
+		} {
+			query.Set("cursor", next)
+			if code, _, _ := list(query); code != http.StatusBadRequest {
+				t.Errorf("%v should be bad request, got %d", query, code)
+			}
+		}
+		if code, _, _ := list(url.Values{"status": {"all"}, "limit": {"2"}, "cursor": {next}}); code != http.StatusOK {
+			t.Errorf("limit can be changed, got %d", code)
+		}
+	})
+
+	for _, query := range []url.Values{
+		{"status": {"pending"}},
+		{"sort": {"branch"}},
+		{"limit": {"0"}},
+		{"cursor": {"broken"}},
+		{"subdomain": {"[pr"}},
+		{"tag": {"api_key:secret"}},
+	} {
+		if code, _, _ := list(query); code != http.StatusBadRequest {
+			t.Errorf("%v should be bad request, got %d", query, code)
+		}
+	}
+
+	// the task definition registered to inject secrets
+	if _, err := runner.Launch(ctx, "secret", mirageecs.TaskParameter{"branch": "main"}, mirageecs.LaunchOption{}, "app-mirage-secrets:1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, got, _ := list(url.Values{"family": {"app"}}); strings.Join(got, ",") != "main,pr-1,secret" {
+		t.Errorf("family should match tasks with secrets: %v", got)
+	}
+}
@@ -11,15 +11,21 @@ import (
 	"net/url"
 	"sort"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
 	"github.com/samber/lo"
 )
 
 // LocalTaskRunner is a mock implementation of TaskRunner.
 type LocalTaskRunner struct {
+	// Informations is guarded by mu, because the tasks are launched and terminated by background jobs.
 	Informations []*Information
 
+	mu sync.RWMutex
+
 	stopServerFuncs map[string]func()
 	cfg             *Config
 	proxyControlCh  chan *proxyControl
@@ -38,12 +44,19 @@ func (e *LocalTaskRunner) SetProxyControlChannel(ch chan *proxyControl) {
 }
 
 func (e *LocalTaskRunner) List(_ context.Context, status string) ([]*Information, error) {
-	infos := lo.Filter(e.Informations, func(info *Information, _ int) bool {
-		return info.LastStatus == status
+	e.mu.RLock()
+	infos := lo.FilterMap(e.Informations, func(info *Information, _ int) (*Information, bool) {
+		cp := *info
+		return &cp, info.LastStatus == status
 	})
+	e.mu.RUnlock()
 	sort.Slice(infos, func(i, j int) bool {
 		return infos[i].Created.After(infos[j].Created)
 	})
+	now := time.Now()
+	for _, info := range infos {
+		info.updateExpiration(now)
+	}
 	return infos, nil
 }
 
@@ -51,58 +64,106 @@ func (e *LocalTaskRunner) Trace(_ context.Context, id string) (string, error) {
 	return fmt.Sprintf("mock trace of %s", id), nil
 }
 
-func (e *LocalTaskRunner) Launch(ctx context.Context, subdomain string, option TaskParameter, taskdefs ...string) error {
-	if info, ok := e.find(subdomain); ok {
+func (e *LocalTaskRunner) Describe(_ context.Context, id string) (*Information, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if info, ok := lo.Find(e.Informations, func(info *Information) bool { return info.ID == id || info.ShortID == id }); ok {
+		cp := *info
+		return &cp, nil
+	}
+	return nil, nil
+}
+
+func (e *LocalTaskRunner) Launch(ctx context.Context, subdomain string, option TaskParameter, opt LaunchOption, taskdefs ...string) ([]*LaunchResult, error) {
+	e.mu.RLock()
+	info, ok := e.find(subdomain)
+	e.mu.RUnlock()
+	if ok {
 		slog.Info(f("subdomain %s is already running task id %s. Terminating...", subdomain, info.ShortID))
 		err := e.TerminateBySubdomain(ctx, subdomain)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 	id := generateRandomHexID(32)
 	env := option.ToEnv(subdomain, e.cfg.Parameter, e.cfg.EncodeSubdomain)
+	if opt.Overrides != nil {
+		// mock server has no containers, so all the environments are merged
+		for _, co := range opt.Overrides.Containers {
+			for k, v := range co.Environment {
+				env[k] = v
+			}
+		}
+	}
 	slog.Info(f("Launching a new mock task: subdomain=%s, taskdef=%s, id=%s", subdomain, taskdefs[0], id))
 	contents := fmt.Sprintf("Hello, Mirage! subdomain: %s\n%#v", subdomain, env)
 	port, stopServerFunc := runMockServer(contents)
+	arn := "arn:aws:ecs:ap-northeast-1:123456789012:task/mirage/" + id
+	e.mu.Lock()
 	e.Informations = append(e.Informations, &Information{
-		ID:         "arn:aws:ecs:ap-northeast-1:123456789012:task/mirage/" + id,
-		ShortID:    id,
-		SubDomain:  subdomain,
-		GitBranch:  option["branch"],
-		TaskDef:    taskdefs[0],
-		IPAddress:  "127.0.0.1",
-		Created:    time.Now().UTC(),
-		LastStatus: statusRunning,
+		ID:            arn,
+		ShortID:       id,
+		SubDomain:     subdomain,
+		GitBranch:     option["branch"],
+		TaskDef:       taskdefs[0],
+		IPAddress:     "127.0.0.1",
+		Created:       time.Now().UTC(),
+		LastStatus:    statusRunning,
+		DesiredStatus: statusRunning,
 		PortMap: map[string]int{
 			"httpd": port,
 		},
-		Env:  env,
-		Tags: option.ToECSTags(subdomain, e.cfg.Parameter),
+		Env:    env,
+		Tags:   append(option.ToECSTags(subdomain, e.cfg.Parameter), opt.toECSTags()...),
+		Target: lo.Ternary(opt.Target == "", DefaultECSTarget, opt.Target),
+		Owner:  opt.Owner,
+
+		overrides: opt.Overrides,
 	})
 	e.stopServerFuncs[id] = stopServerFunc
+	e.mu.Unlock()
 	e.proxyControlCh <- &proxyControl{
 		Action:    proxyAdd,
 		Subdomain: subdomain,
 		IPAddress: "127.0.0.1",
 		Port:      port,
 	}
-	return nil
+	// mock server serves only the first taskdef
+	return []*LaunchResult{{TaskDef: taskdefs[0], ID: arn, ShortID: id}}, nil
 }
 
-func (e *LocalTaskRunner) Logs(_ context.Context, subdomain string, since time.Time, tail int) ([]string, error) {
+func (e *LocalTaskRunner) Logs(_ context.Context, subdomain string, since time.Time, tail int) ([]*LogEvent, error) {
 	// Logs returns logs of the specified subdomain.
-	return []string{"Sorry. mock server logs are empty."}, nil
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	info, ok := e.find(subdomain)
+	if !ok {
+		return nil, fmt.Errorf("subdomain %s is not found", subdomain)
+	}
+	if !since.IsZero() && since.After(info.Created) {
+		return []*LogEvent{}, nil
+	}
+	return []*LogEvent{{
+		TaskID:    info.ShortID,
+		Container: "mock",
+		Timestamp: info.Created,
+		Message:   "Sorry. mock server logs are empty.",
+	}}, nil
 }
 
 func (e *LocalTaskRunner) Terminate(ctx context.Context, id string) error {
-	for _, info := range e.Informations {
-		if info.ID == id {
-			return e.TerminateBySubdomain(ctx, info.SubDomain)
-		}
+	e.mu.RLock()
+	info, ok := lo.Find(e.Informations, func(info *Information) bool {
+		return info.ID == id
+	})
+	e.mu.RUnlock()
+	if ok {
+		return e.TerminateBySubdomain(ctx, info.SubDomain)
 	}
 	return nil
 }
 
+// find returns the running task of the subdomain. The caller must hold mu.
 func (e *LocalTaskRunner) find(subdomain string) (*Information, bool) {
 	for _, info := range e.Informations {
 		if info.SubDomain == subdomain && info.LastStatus == statusRunning {
@@ -114,20 +175,48 @@ func (e *LocalTaskRunner) find(subdomain string) (*Information, bool) {
 
 func (e *LocalTaskRunner) TerminateBySubdomain(ctx context.Context, subdomain string) error {
 	slog.Info(f("Terminating a mock task: subdomain=%s", subdomain))
-	if info, ok := e.find(subdomain); ok {
-		if stop := e.stopServerFuncs[info.ShortID]; stop != nil {
-			stop()
-		}
-		e.proxyControlCh <- &proxyControl{
-			Action:    proxyRemove,
-			Subdomain: subdomain,
-		}
+	e.mu.Lock()
+	info, ok := e.find(subdomain)
+	var stop func()
+	if ok {
+		stop = e.stopServerFuncs[info.ShortID]
+		delete(e.stopServerFuncs, info.ShortID)
+		now := time.Now().UTC()
 		info.LastStatus = statusStopped
+		info.DesiredStatus = statusStopped
+		info.StopCode = string(types.TaskStopCodeUserInitiated)
+		info.StoppedReason = "Terminate requested by Mirage"
+		info.StoppedAt = &now
 		e.Informations = lo.Filter(e.Informations, func(i *Information, _ int) bool {
 			return i.ShortID != info.ShortID
 		})
 		e.Informations = append(e.Informations, info)
 	}
+	e.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if stop != nil {
+		stop()
+	}
+	e.proxyControlCh <- &proxyControl{
+		Action:    proxyRemove,
+		Subdomain: subdomain,
+	}
+	return nil
+}
+
+func (e *LocalTaskRunner) SetExpiration(_ context.Context, subdomain string, expireAt time.Time) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	info, ok := e.find(subdomain)
+	if !ok {
+		return fmt.Errorf("subdomain %s is not running", subdomain)
+	}
+	info.Tags = lo.Filter(info.Tags, func(t types.Tag, _ int) bool {
+		return aws.ToString(t.Key) != TagExpireAt
+	})
+	info.Tags = append(info.Tags, expireAtTag(expireAt))
 	return nil
 }
 
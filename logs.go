@@ -0,0 +1,240 @@
+package mirageecs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/samber/lo"
+)
+
+const (
+	// logFollowInterval is an interval to poll new log events in follow mode.
+	logFollowInterval = 3 * time.Second
+	// insightsFollowInterval is used instead of logFollowInterval when logs.insights is configured,
+	// because each poll starts Logs Insights queries that are billed by the scanned bytes.
+	insightsFollowInterval = 30 * time.Second
+
+	logTimestampFormat = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// LogEvent is a log event of a container.
+type LogEvent struct {
+	TaskID    string    `json:"task_id"`
+	Container string    `json:"container"`
+	Timestamp time.Time `json:"timestamp"`
+	Message   string    `json:"message"`
+}
+
+// String returns the log line prefixed with the timestamp and the container name.
+func (ev *LogEvent) String() string {
+	return fmt.Sprintf("%s [%s] %s", ev.Timestamp.UTC().Format(logTimestampFormat), ev.Container, ev.Message)
+}
+
+func (ev *LogEvent) key() string {
+	return ev.TaskID + "/" + ev.Container + "/" + strconv.FormatInt(ev.Timestamp.UnixMilli(), 10) + "/" + ev.Message
+}
+
+// sortLogEvents sorts events by the timestamp. Events at the same time keep the order of each stream.
+func sortLogEvents(evs []*LogEvent) {
+	sort.SliceStable(evs, func(i, j int) bool {
+		return evs[i].Timestamp.Before(evs[j].Timestamp)
+	})
+}
+
+func tailLogEvents(evs []*LogEvent, tail int) []*LogEvent {
+	if tail > 0 && len(evs) > tail {
+		return evs[len(evs)-tail:]
+	}
+	return evs
+}
+
+// parseLogsQuery parses query parameters of the logs request.
+func parseLogsQuery(c echo.Context) (string, time.Time, int, error) {
+	subdomain := c.QueryParam("subdomain")
+	since := c.QueryParam("since")
+	tail := c.QueryParam("tail")
+
+	if subdomain == "" {
+		return "", time.Time{}, 0, fmt.Errorf("parameter required: subdomain")
+	}
+
+	var sinceTime time.Time
+	if since != "" {
+		var err error
+		sinceTime, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			return "", time.Time{}, 0, fmt.Errorf("cannot parse since: %s", err)
+		}
+	}
+	var tailN int
+	if tail != "" {
+		if tail == "all" {
+			tailN = 0
+		} else if n, err := strconv.Atoi(tail); err != nil {
+			return "", time.Time{}, 0, fmt.Errorf("cannot parse tail: %s", err)
+		} else {
+			tailN = n
+		}
+	}
+	return subdomain, sinceTime, tailN, nil
+}
+
+func (api *WebApi) logs(c echo.Context) (int, []string, error) {
+	subdomain, since, tail, err := parseLogsQuery(c)
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), APICallTimeout)
+	defer cancel()
+	evs, err := api.runner.Logs(ctx, subdomain, since, tail)
+	if err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+	return http.StatusOK, lo.Map(evs, func(ev *LogEvent, _ int) string { return ev.String() }), nil
+}
+
+// isRunning reports whether the subdomain has running tasks.
+func (api *WebApi) isRunning(ctx context.Context, subdomain string) (bool, error) {
+	infos, err := api.runner.List(ctx, statusRunning)
+	if err != nil {
+		return false, err
+	}
+	return lo.ContainsBy(infos, func(info *Information) bool { return info.SubDomain == subdomain }), nil
+}
+
+// followLogs polls new log events of the subdomain and emits them until the tasks stop or ctx is done.
+func (api *WebApi) followLogs(ctx context.Context, subdomain string, since time.Time, tail int, emit func([]*LogEvent) error) error {
+	// events at the last timestamp are returned again by the next poll
+	seen := map[string]struct{}{}
+	tk := time.NewTicker(api.cfg.Logs.followInterval())
+	defer tk.Stop()
+	for {
+		running, err := api.isRunning(ctx, subdomain)
+		if err != nil {
+			return err
+		}
+		cctx, cancel := context.WithTimeout(ctx, APICallTimeout)
+		evs, err := api.runner.Logs(cctx, subdomain, since, tail)
+		cancel()
+		if err != nil {
+			if !running {
+				return nil
+			}
+			return err
+		}
+		evs = lo.Filter(evs, func(ev *LogEvent, _ int) bool {
+			_, ok := seen[ev.key()]
+			return !ok
+		})
+		if len(evs) > 0 {
+			if err := emit(evs); err != nil {
+				return err
+			}
+			last := evs[len(evs)-1].Timestamp
+			if !last.Equal(since) {
+				seen = map[string]struct{}{}
+			}
+			since = last
+			for _, ev := range evs {
+				if ev.Timestamp.Equal(last) {
+					seen[ev.key()] = struct{}{}
+				}
+			}
+		}
+		if !running {
+			return nil
+		}
+		tail = 0
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tk.C:
+		}
+	}
+}
+
+// streamLogs streams log events in follow mode.
+// Server-Sent Events is used when the client accepts text/event-stream, otherwise chunked plain text.
+func (api *WebApi) streamLogs(c echo.Context, sse bool) error {
+	subdomain, since, tail, err := parseLogsQuery(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APICommonResponse{Result: err.Error()})
+	}
+	res := c.Response()
+	if sse {
+		res.Header().Set(echo.HeaderContentType, "text/event-stream")
+		res.Header().Set(echo.HeaderConnection, "keep-alive")
+	} else {
+		res.Header().Set(echo.HeaderContentType, echo.MIMETextPlainCharsetUTF8)
+	}
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	err = api.followLogs(c.Request().Context(), subdomain, since, tail, func(evs []*LogEvent) error {
+		for _, ev := range evs {
+			if sse {
+				b, err := json.Marshal(ev)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(res, "event: log\ndata: %s\n\n", b)
+			} else {
+				fmt.Fprintln(res, ev.String())
+			}
+		}
+		res.Flush()
+		return nil
+	})
+	if err != nil {
+		slog.Warn(f("failed to follow logs of %s: %s", subdomain, err))
+		if sse {
+			fmt.Fprintf(res, "event: error\ndata: %s\n\n", strconv.Quote(err.Error()))
+		}
+	}
+	if sse {
+		fmt.Fprint(res, "event: end\ndata: {}\n\n")
+	}
+	res.Flush()
+	return nil
+}
+
+func acceptsEventStream(req *http.Request) bool {
+	return strings.Contains(req.Header.Get(echo.HeaderAccept), "text/event-stream")
+}
+
+func (api *WebApi) ApiLogs(c echo.Context) error {
+	if c.QueryParam("follow") == "true" {
+		return api.streamLogs(c, acceptsEventStream(c.Request()))
+	}
+	code, logs, err := api.logs(c)
+	if err != nil {
+		return c.JSON(code, APICommonResponse{Result: err.Error()})
+	}
+	return c.JSON(code, APILogsResponse{Result: logs})
+}
+
+// Logs renders the log viewer of the subdomain.
+func (api *WebApi) Logs(c echo.Context) error {
+	subdomain := c.QueryParam("subdomain")
+	if subdomain == "" {
+		return c.String(http.StatusBadRequest, "subdomain required")
+	}
+	return c.Render(http.StatusOK, "logs.html", map[string]interface{}{
+		"subdomain": subdomain,
+	})
+}
+
+// LogsStream streams logs of the subdomain to the log viewer by Server-Sent Events.
+func (api *WebApi) LogsStream(c echo.Context) error {
+	return api.streamLogs(c, true)
+}
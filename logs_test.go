@@ -0,0 +1,98 @@
+package mirageecs_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestLogEventString(t *testing.T) {
+	ev := &mirageecs.LogEvent{
+		Container: "app",
+		Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.FixedZone("JST", 9*3600)),
+		Message:   "hello",
+	}
+	if s := ev.String(); s != "2024-01-01T18:04:05.006Z [app] hello" {
+		t.Errorf("unexpected line %s", s)
+	}
+}
+
+func TestApiLogs(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := newTestConfig(t, "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+	runner := m.Runner()
+	if _, err := runner.Launch(ctx, "logs", mirageecs.TaskParameter{}, mirageecs.LaunchOption{}, "dummy"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("logs", func(t *testing.T) {
+		res, err := ts.Client().Get(ts.URL + "/api/logs?subdomain=logs&tail=10")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var r mirageecs.APILogsResponse
+		if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Result) != 1 || !strings.Contains(r.Result[0], " [mock] ") {
+			t.Errorf("lines should be prefixed with the container name: %v", r.Result)
+		}
+	})
+
+	t.Run("follow", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/logs?subdomain=logs&follow=true", nil)
+		req.Header.Set("Accept", "text/event-stream")
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("unexpected content type %s", ct)
+		}
+		var events []string
+		sc := bufio.NewScanner(res.Body)
+		for sc.Scan() {
+			line := sc.Text()
+			if !strings.HasPrefix(line, "event: ") {
+				continue
+			}
+			ev := strings.TrimPrefix(line, "event: ")
+			events = append(events, ev)
+			if ev == "log" {
+				// the stream ends when the task stops
+				if err := runner.TerminateBySubdomain(ctx, "logs"); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if strings.Join(events, ",") != "log,end" {
+			t.Errorf("unexpected events %v", events)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		res, err := ts.Client().Get(ts.URL + "/api/logs?subdomain=missing")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusInternalServerError {
+			t.Errorf("expected 500, got %d", res.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,367 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cwlogs "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwlogstypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/samber/lo"
+)
+
+const (
+	// DefaultFireLensLogGroup and DefaultFireLensLogStream follow the options of the cloudwatch_logs output plugin of Fluent Bit.
+	// FireLens tags logs as {container name}-firelens-{task ID}.
+	DefaultFireLensLogGroup  = "${options.log_group_name}"
+	DefaultFireLensLogStream = "${options.log_stream_prefix}${container}-firelens-${task_id}"
+
+	DefaultInsightsQuery = `fields @timestamp, @message | filter @logStream like "${task_id}"`
+
+	// insightsMaxLimit is the maximum number of results of a Logs Insights query.
+	insightsMaxLimit = 10000
+	// insightsLookBack is a margin before the task creation to query logs.
+	insightsLookBack = time.Minute
+	// insightsStopTimeout is a timeout to stop the query after the request is canceled.
+	insightsStopTimeout = 5 * time.Second
+)
+
+// insightsPollInterval is an interval to poll the results of a Logs Insights query.
+var insightsPollInterval = time.Second
+
+// LogsCfg configures how logs of containers are read.
+type LogsCfg struct {
+	// FireLens reads logs of awsfirelens containers from CloudWatch Logs.
+	FireLens *FireLensLogsCfg `yaml:"firelens"`
+	// Insights reads logs by CloudWatch Logs Insights queries for containers not supported by the other sources.
+	Insights *InsightsLogsCfg `yaml:"insights"`
+}
+
+// FireLensLogsCfg has templates of the log group and the log stream of FireLens containers.
+type FireLensLogsCfg struct {
+	LogGroup  string `yaml:"log_group"`
+	LogStream string `yaml:"log_stream"`
+}
+
+// InsightsLogsCfg has templates of the log groups and the query of Logs Insights.
+type InsightsLogsCfg struct {
+	LogGroups []string `yaml:"log_groups"`
+	Query     string   `yaml:"query"`
+}
+
+func (c *LogsCfg) validate() error {
+	if c.FireLens == nil {
+		c.FireLens = &FireLensLogsCfg{}
+	}
+	if err := c.FireLens.validate(); err != nil {
+		return fmt.Errorf("firelens: %w", err)
+	}
+	if c.Insights != nil {
+		if err := c.Insights.validate(); err != nil {
+			return fmt.Errorf("insights: %w", err)
+		}
+	}
+	return nil
+}
+
+// followInterval returns the interval to poll logs in follow mode.
+func (c *LogsCfg) followInterval() time.Duration {
+	if c != nil && c.Insights != nil {
+		return insightsFollowInterval
+	}
+	return logFollowInterval
+}
+
+func (c *FireLensLogsCfg) validate() error {
+	if c.LogGroup == "" {
+		c.LogGroup = DefaultFireLensLogGroup
+	}
+	if c.LogStream == "" {
+		c.LogStream = DefaultFireLensLogStream
+	}
+	if err := validateLogTemplate(c.LogGroup); err != nil {
+		return fmt.Errorf("invalid log_group: %w", err)
+	}
+	if err := validateLogTemplate(c.LogStream); err != nil {
+		return fmt.Errorf("invalid log_stream: %w", err)
+	}
+	return nil
+}
+
+func (c *InsightsLogsCfg) validate() error {
+	if len(c.LogGroups) == 0 {
+		return fmt.Errorf("log_groups is required")
+	}
+	if c.Query == "" {
+		c.Query = DefaultInsightsQuery
+	}
+	for i, g := range c.LogGroups {
+		if err := validateLogTemplate(g); err != nil {
+			return fmt.Errorf("invalid log_groups[%d]: %w", i, err)
+		}
+	}
+	if err := validateLogTemplate(c.Query); err != nil {
+		return fmt.Errorf("invalid query: %w", err)
+	}
+	return nil
+}
+
+// validateLogTemplate checks all the variables in the template are known.
+func validateLogTemplate(s string) error {
+	var unknown []string
+	os.Expand(s, func(name string) string {
+		if !lo.Contains(logTemplateVars, name) && !strings.HasPrefix(name, logTemplateOptionPrefix) {
+			unknown = append(unknown, name)
+		}
+		return ""
+	})
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown variables %s", strings.Join(unknown, ","))
+	}
+	return nil
+}
+
+// logsClient is the CloudWatch Logs API used by log sources.
+type logsClient interface {
+	GetLogEvents(context.Context, *cwlogs.GetLogEventsInput, ...func(*cwlogs.Options)) (*cwlogs.GetLogEventsOutput, error)
+	StartQuery(context.Context, *cwlogs.StartQueryInput, ...func(*cwlogs.Options)) (*cwlogs.StartQueryOutput, error)
+	GetQueryResults(context.Context, *cwlogs.GetQueryResultsInput, ...func(*cwlogs.Options)) (*cwlogs.GetQueryResultsOutput, error)
+	StopQuery(context.Context, *cwlogs.StopQueryInput, ...func(*cwlogs.Options)) (*cwlogs.StopQueryOutput, error)
+}
+
+const logTemplateOptionPrefix = "options."
+
+// logTemplateVars are variables of templates expanded as ${name}. ${options.NAME} is an option of the log configuration.
+var logTemplateVars = []string{"container", "task_id", "family"}
+
+// logContainer is a container of the task to read logs.
+type logContainer struct {
+	Container string
+	TaskID    string
+	Family    string
+	Options   map[string]string
+
+	created time.Time
+}
+
+// expand expands variables in the template.
+func (c *logContainer) expand(s string) string {
+	return os.Expand(s, func(name string) string {
+		switch name {
+		case "container":
+			return c.Container
+		case "task_id":
+			return c.TaskID
+		case "family":
+			return c.Family
+		}
+		return c.Options[strings.TrimPrefix(name, logTemplateOptionPrefix)]
+	})
+}
+
+// logSource reads log events of a container.
+type logSource interface {
+	events(ctx context.Context, client logsClient, since time.Time, tail int) ([]*LogEvent, error)
+	String() string
+}
+
+// logSourceOf selects the log source of the container by its log configuration.
+// It returns nil when the logs of the container cannot be read.
+func (c *LogsCfg) logSourceOf(def *types.ContainerDefinition, lc *logContainer) (logSource, error) {
+	if c == nil {
+		// Config is not created by NewConfig
+		c = &LogsCfg{}
+		if err := c.validate(); err != nil {
+			return nil, err
+		}
+	}
+	conf := def.LogConfiguration
+	if conf == nil {
+		return nil, nil
+	}
+	lc.Options = conf.Options
+	switch conf.LogDriver {
+	case types.LogDriverAwslogs:
+		group := conf.Options["awslogs-group"]
+		streamPrefix := conf.Options["awslogs-stream-prefix"]
+		if group == "" || streamPrefix == "" {
+			return nil, fmt.Errorf("invalid options. awslogs-group %s awslogs-stream-prefix %s", group, streamPrefix)
+		}
+		// streamName: prefix/containerName/taskID
+		return &streamLogSource{group: group, stream: fmt.Sprintf("%s/%s/%s", streamPrefix, lc.Container, lc.TaskID)}, nil
+	case types.LogDriverAwsfirelens:
+		group, stream := lc.expand(c.FireLens.LogGroup), lc.expand(c.FireLens.LogStream)
+		if group != "" && stream != "" {
+			return &streamLogSource{group: group, stream: stream}, nil
+		}
+	}
+	if c.Insights != nil {
+		return c.Insights.source(lc), nil
+	}
+	slog.Warn(f("LogDriver %s of container %s is not supported. configure logs.insights to query the logs", conf.LogDriver, lc.Container))
+	return nil, nil
+}
+
+// streamLogSource reads a log stream by GetLogEvents. It is used for awslogs and FireLens.
+type streamLogSource struct {
+	group  string
+	stream string
+}
+
+func (s *streamLogSource) String() string {
+	return fmt.Sprintf("group:%s stream:%s", s.group, s.stream)
+}
+
+// events gets all the log events of the stream since the time.
+// When tail is specified without since, it reads the stream backward until tail events are found.
+func (s *streamLogSource) events(ctx context.Context, client logsClient, since time.Time, tail int) ([]*LogEvent, error) {
+	backward := tail > 0 && since.IsZero()
+	in := &cwlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(s.group),
+		LogStreamName: aws.String(s.stream),
+		StartFromHead: aws.Bool(!backward),
+	}
+	if !since.IsZero() {
+		in.StartTime = aws.Int64(since.UnixMilli())
+	}
+	var evs []*LogEvent
+	for {
+		out, err := client.GetLogEvents(ctx, in)
+		if err != nil {
+			return evs, err
+		}
+		page := lo.Map(out.Events, func(ev cwlogstypes.OutputLogEvent, _ int) *LogEvent {
+			return &LogEvent{
+				Timestamp: time.UnixMilli(aws.ToInt64(ev.Timestamp)),
+				Message:   aws.ToString(ev.Message),
+			}
+		})
+		slog.Debug(f("%d log events from %s", len(page), s))
+		// pages may be empty in the middle of the stream. the same token is returned at the end
+		var next *string
+		if backward {
+			evs = append(page, evs...)
+			next = out.NextBackwardToken
+			if len(evs) >= tail {
+				break
+			}
+		} else {
+			evs = append(evs, page...)
+			next = out.NextForwardToken
+		}
+		if next == nil || aws.ToString(next) == aws.ToString(in.NextToken) {
+			break
+		}
+		in.NextToken = next
+	}
+	return tailLogEvents(evs, tail), nil
+}
+
+func (c *InsightsLogsCfg) source(lc *logContainer) logSource {
+	s := &insightsLogSource{query: lc.expand(c.Query), created: lc.created}
+	for _, g := range c.LogGroups {
+		if g := lc.expand(g); g != "" {
+			s.groups = append(s.groups, g)
+		}
+	}
+	return s
+}
+
+// insightsLogSource reads logs by a CloudWatch Logs Insights query.
+type insightsLogSource struct {
+	groups  []string
+	query   string
+	created time.Time
+}
+
+func (s *insightsLogSource) String() string {
+	return fmt.Sprintf("groups:%s query:%s", strings.Join(s.groups, ","), s.query)
+}
+
+func (s *insightsLogSource) events(ctx context.Context, client logsClient, since time.Time, tail int) ([]*LogEvent, error) {
+	start := since
+	if start.IsZero() {
+		start = s.created.Add(-insightsLookBack)
+	}
+	limit := insightsMaxLimit
+	if tail > 0 && tail < limit {
+		limit = tail
+	}
+	out, err := client.StartQuery(ctx, &cwlogs.StartQueryInput{
+		LogGroupNames: s.groups,
+		QueryString:   aws.String(s.query + " | sort @timestamp desc"),
+		StartTime:     aws.Int64(start.Unix()),
+		EndTime:       aws.Int64(time.Now().Unix()),
+		Limit:         aws.Int32(int32(limit)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start query: %w", err)
+	}
+	for {
+		res, err := client.GetQueryResults(ctx, &cwlogs.GetQueryResultsInput{QueryId: out.QueryId})
+		if err != nil {
+			if ctx.Err() != nil {
+				stopQuery(client, out.QueryId)
+			}
+			return nil, fmt.Errorf("failed to get query results: %w", err)
+		}
+		switch res.Status {
+		case cwlogstypes.QueryStatusComplete:
+			evs := lo.FilterMap(res.Results, func(fields []cwlogstypes.ResultField, _ int) (*LogEvent, bool) {
+				return insightsLogEvent(fields, since)
+			})
+			sort.SliceStable(evs, func(i, j int) bool {
+				return evs[i].Timestamp.Before(evs[j].Timestamp)
+			})
+			slog.Debug(f("%d log events from %s", len(evs), s))
+			return evs, nil
+		case cwlogstypes.QueryStatusScheduled, cwlogstypes.QueryStatusRunning:
+		default:
+			return nil, fmt.Errorf("query %s is %s", aws.ToString(out.QueryId), res.Status)
+		}
+		select {
+		case <-ctx.Done():
+			stopQuery(client, out.QueryId)
+			return nil, ctx.Err()
+		case <-time.After(insightsPollInterval):
+		}
+	}
+}
+
+// stopQuery stops the query abandoned by the canceled request, so that it does not scan logs any more.
+func stopQuery(client logsClient, id *string) {
+	ctx, cancel := context.WithTimeout(context.Background(), insightsStopTimeout)
+	defer cancel()
+	if _, err := client.StopQuery(ctx, &cwlogs.StopQueryInput{QueryId: id}); err != nil {
+		slog.Warn(f("failed to stop query %s: %s", aws.ToString(id), err))
+	}
+}
+
+// insightsLogEvent converts a result row of the query to a log event.
+// The query results are in seconds, so events before since are dropped here.
+func insightsLogEvent(fields []cwlogstypes.ResultField, since time.Time) (*LogEvent, bool) {
+	ev := &LogEvent{}
+	for _, fl := range fields {
+		switch aws.ToString(fl.Field) {
+		case "@timestamp":
+			// e.g. 2024-01-02 03:04:05.678
+			ts, err := time.Parse("2006-01-02 15:04:05.000", aws.ToString(fl.Value))
+			if err != nil {
+				return nil, false
+			}
+			ev.Timestamp = ts
+		case "@message":
+			ev.Message = aws.ToString(fl.Value)
+		}
+	}
+	if ev.Timestamp.IsZero() || ev.Timestamp.Before(since) {
+		return nil, false
+	}
+	return ev, true
+}
@@ -0,0 +1,204 @@
+package mirageecs_test
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cwlogs "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwlogstypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+var logsBaseTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// fakeLogsClient serves log streams by pages of 2 events.
+type fakeLogsClient struct {
+	streams map[string][]string // group/stream -> messages
+	queries []*cwlogs.StartQueryInput
+	running bool // queries never complete
+	stopped []string
+}
+
+func (c *fakeLogsClient) GetLogEvents(_ context.Context, in *cwlogs.GetLogEventsInput, _ ...func(*cwlogs.Options)) (*cwlogs.GetLogEventsOutput, error) {
+	msgs, ok := c.streams[aws.ToString(in.LogGroupName)+"/"+aws.ToString(in.LogStreamName)]
+	if !ok {
+		return nil, fmt.Errorf("stream %s is not found", aws.ToString(in.LogStreamName))
+	}
+	const pageSize = 2
+	// token is the start index of the page
+	start := 0
+	if aws.ToBool(in.StartFromHead) {
+		if in.NextToken != nil {
+			start, _ = strconv.Atoi(strings.TrimPrefix(aws.ToString(in.NextToken), "f/"))
+		}
+	} else {
+		start = len(msgs) - pageSize
+		if in.NextToken != nil {
+			start, _ = strconv.Atoi(strings.TrimPrefix(aws.ToString(in.NextToken), "b/"))
+		}
+	}
+	end := start + pageSize
+	if start < 0 {
+		start = 0
+	}
+	if end > len(msgs) {
+		end = len(msgs)
+	}
+	out := &cwlogs.GetLogEventsOutput{
+		NextForwardToken:  aws.String(fmt.Sprintf("f/%d", end)),
+		NextBackwardToken: aws.String(fmt.Sprintf("b/%d", start-pageSize)),
+	}
+	for i := start; i < end; i++ {
+		ts := logsBaseTime.Add(time.Duration(i) * time.Second)
+		if in.StartTime != nil && ts.UnixMilli() < aws.ToInt64(in.StartTime) {
+			continue
+		}
+		out.Events = append(out.Events, cwlogstypes.OutputLogEvent{
+			Timestamp: aws.Int64(ts.UnixMilli()),
+			Message:   aws.String(msgs[i]),
+		})
+	}
+	if start == 0 && !aws.ToBool(in.StartFromHead) {
+		// the same token at the head of the stream
+		out.NextBackwardToken = in.NextToken
+	}
+	return out, nil
+}
+
+func (c *fakeLogsClient) StartQuery(_ context.Context, in *cwlogs.StartQueryInput, _ ...func(*cwlogs.Options)) (*cwlogs.StartQueryOutput, error) {
+	c.queries = append(c.queries, in)
+	return &cwlogs.StartQueryOutput{QueryId: aws.String("q1")}, nil
+}
+
+func (c *fakeLogsClient) GetQueryResults(_ context.Context, in *cwlogs.GetQueryResultsInput, _ ...func(*cwlogs.Options)) (*cwlogs.GetQueryResultsOutput, error) {
+	row := func(ts time.Time, msg string) []cwlogstypes.ResultField {
+		return []cwlogstypes.ResultField{
+			{Field: aws.String("@timestamp"), Value: aws.String(ts.Format("2006-01-02 15:04:05.000"))},
+			{Field: aws.String("@message"), Value: aws.String(msg)},
+		}
+	}
+	if c.running {
+		return &cwlogs.GetQueryResultsOutput{Status: cwlogstypes.QueryStatusRunning}, nil
+	}
+	return &cwlogs.GetQueryResultsOutput{
+		Status: cwlogstypes.QueryStatusComplete,
+		// sorted by @timestamp desc
+		Results: [][]cwlogstypes.ResultField{
+			row(logsBaseTime.Add(time.Second), "second"),
+			row(logsBaseTime, "first"),
+		},
+	}, nil
+}
+
+func (c *fakeLogsClient) StopQuery(_ context.Context, in *cwlogs.StopQueryInput, _ ...func(*cwlogs.Options)) (*cwlogs.StopQueryOutput, error) {
+	c.stopped = append(c.stopped, aws.ToString(in.QueryId))
+	return &cwlogs.StopQueryOutput{Success: true}, nil
+}
+
+func messagesOf(evs []*mirageecs.LogEvent) string {
+	var ms []string
+	for _, ev := range evs {
+		ms = append(ms, ev.Message)
+	}
+	return strings.Join(ms, ",")
+}
+
+func TestLogSources(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeLogsClient{streams: map[string][]string{
+		"/ecs/app/ecs/app/0123":              {"a1", "a2", "a3", "a4", "a5"},
+		"/firelens/app/fl-app-firelens-0123": {"f1", "f2", "f3"},
+		"/custom/myfamily/app-0123":          {"c1"},
+	}}
+	defaultCfg := &mirageecs.LogsCfg{
+		Insights: &mirageecs.InsightsLogsCfg{LogGroups: []string{"/insights/${container}"}},
+	}
+	if err := defaultCfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	customCfg := &mirageecs.LogsCfg{
+		FireLens: &mirageecs.FireLensLogsCfg{
+			LogGroup:  "/custom/${family}",
+			LogStream: "${container}-${task_id}",
+		},
+	}
+	if err := customCfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	container := func(driver types.LogDriver, opts map[string]string) *types.ContainerDefinition {
+		return &types.ContainerDefinition{
+			Name:             aws.String("app"),
+			LogConfiguration: &types.LogConfiguration{LogDriver: driver, Options: opts},
+		}
+	}
+	awslogs := container(types.LogDriverAwslogs, map[string]string{"awslogs-group": "/ecs/app", "awslogs-stream-prefix": "ecs"})
+	firelens := container(types.LogDriverAwsfirelens, map[string]string{"Name": "cloudwatch_logs", "log_group_name": "/firelens/app", "log_stream_prefix": "fl-"})
+
+	tests := []struct {
+		name  string
+		cfg   *mirageecs.LogsCfg
+		def   *types.ContainerDefinition
+		since time.Time
+		tail  int
+		want  string
+	}{
+		{"awslogs all", defaultCfg, awslogs, time.Time{}, 0, "a1,a2,a3,a4,a5"},
+		{"awslogs tail", defaultCfg, awslogs, time.Time{}, 3, "a3,a4,a5"},
+		{"awslogs since", defaultCfg, awslogs, logsBaseTime.Add(3 * time.Second), 0, "a4,a5"},
+		{"firelens options", defaultCfg, firelens, time.Time{}, 0, "f1,f2,f3"},
+		{"firelens template", customCfg, container(types.LogDriverAwsfirelens, map[string]string{"Name": "cloudwatch_logs"}), time.Time{}, 0, "c1"},
+		{"insights for other drivers", defaultCfg, container(types.LogDriverFluentd, nil), time.Time{}, 0, "first,second"},
+		{"insights for firelens without cloudwatch", defaultCfg, container(types.LogDriverAwsfirelens, map[string]string{"Name": "datadog"}), time.Time{}, 0, "first,second"},
+		{"no sources", customCfg, container(types.LogDriverFluentd, nil), time.Time{}, 0, ""},
+		{"no log configuration", defaultCfg, &types.ContainerDefinition{Name: aws.String("app")}, time.Time{}, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evs, err := tt.cfg.ContainerLogs(ctx, client, tt.def, "myfamily", "0123", logsBaseTime, tt.since, tt.tail)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := messagesOf(evs); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("insights query", func(t *testing.T) {
+		q := client.queries[0]
+		if g := q.LogGroupNames; len(g) != 1 || g[0] != "/insights/app" {
+			t.Errorf("unexpected log groups %v", g)
+		}
+		if s := aws.ToString(q.QueryString); !strings.Contains(s, `filter @logStream like "0123"`) {
+			t.Errorf("unexpected query %s", s)
+		}
+		if st := aws.ToInt64(q.StartTime); st != logsBaseTime.Add(-time.Minute).Unix() {
+			t.Errorf("query should start before the task creation: %d", st)
+		}
+	})
+
+	t.Run("canceled insights query", func(t *testing.T) {
+		client := &fakeLogsClient{running: true}
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if _, err := defaultCfg.ContainerLogs(ctx, client, container(types.LogDriverFluentd, nil), "myfamily", "0123", logsBaseTime, time.Time{}, 0); err == nil {
+			t.Error("the canceled query should fail")
+		}
+		if len(client.stopped) != 1 || client.stopped[0] != "q1" {
+			t.Errorf("the canceled query should be stopped %v", client.stopped)
+		}
+	})
+
+	if err := (&mirageecs.LogsCfg{Insights: &mirageecs.InsightsLogsCfg{}}).Validate(); err == nil {
+		t.Error("insights without log_groups should be invalid")
+	}
+	if err := (&mirageecs.LogsCfg{FireLens: &mirageecs.FireLensLogsCfg{LogStream: "${task}"}}).Validate(); err == nil {
+		t.Error("unknown variables should be invalid")
+	}
+}
@@ -2,6 +2,7 @@ package mirageecs
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"log/slog"
@@ -11,6 +12,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/samber/lo"
 )
 
 var Version = "current"
@@ -20,9 +23,11 @@ type Mirage struct {
 	WebApi       *WebApi
 	ReverseProxy *ReverseProxy
 	Route53      *Route53
+	ACME         *ACMEManager
 
 	runner         TaskRunner
 	proxyControlCh chan *proxyControl
+	taskStatuses   map[string]string // task ID -> last status
 }
 
 func New(ctx context.Context, cfg *Config) *Mirage {
@@ -37,6 +42,20 @@ func New(ctx context.Context, cfg *Config) *Mirage {
 		Route53:        NewRoute53(ctx, cfg),
 		runner:         runner,
 		proxyControlCh: ch,
+		taskStatuses:   make(map[string]string),
+	}
+	m.WebApi.proxy = m.ReverseProxy
+	if cfg.ScaleToZero != nil {
+		if err := m.WebApi.sleeping.restore(ctx); err != nil {
+			slog.Error(f("failed to restore sleeping subdomains: %s", err))
+		}
+	}
+	if cfg.ACME != nil {
+		if acm, err := NewACMEManager(ctx, cfg); err != nil {
+			slog.Error(f("failed to initialize acme: %s", err))
+		} else {
+			m.ACME = acm
+		}
 	}
 	return m
 }
@@ -48,34 +67,36 @@ func (m *Mirage) Run(ctx context.Context) error {
 	errors := make(chan error, 10)
 	for _, v := range m.Config.Listen.HTTP {
 		wg.Add(1)
-		go func(port int) {
-			defer wg.Done()
-			laddr := fmt.Sprintf("%s:%d", m.Config.Listen.ForeignAddress, port)
-			listener, err := net.Listen("tcp", laddr)
-			if err != nil {
-				slog.Error(f("cannot listen %s: %s", laddr, err))
-				errors <- err
-				cancel()
-				return
-			}
-
-			mux := http.NewServeMux()
-			mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
-				m.ServeHTTPWithPort(w, req, port)
-			})
-			slog.Info(f("listen addr: %s", laddr))
-			srv := &http.Server{
-				Handler: mux,
-			}
-			go srv.Serve(listener)
-			<-ctx.Done()
-			slog.Info(f("shutdown server: %s", laddr))
-			srv.Shutdown(ctx)
-		}(v.ListenPort)
+		go m.serve(ctx, cancel, &wg, errors, v.ListenPort, nil)
+	}
+	for _, v := range m.Config.Listen.HTTPS {
+		wg.Add(1)
+		go m.serve(ctx, cancel, &wg, errors, v.ListenPort, m.Config.Certificates().TLSConfig())
 	}
 
-	wg.Add(2)
+	if m.ACME != nil {
+		wg.Add(1)
+		go m.ACME.Run(ctx, &wg)
+	}
+	if m.Config.Purge != nil {
+		wg.Add(1)
+		go m.runPurgeScheduler(ctx, &wg)
+	}
+	if m.Config.ScaleToZero != nil {
+		wg.Add(1)
+		go m.runScaleToZero(ctx, &wg)
+	}
+	if m.Config.Notifications != nil && len(m.Config.Notifications.Webhooks) > 0 {
+		wg.Add(1)
+		go m.runNotifier(ctx, &wg)
+	}
+	if r, ok := m.runner.(taskStateEventReceiver); ok && m.Config.TaskCache != nil && m.Config.TaskCache.SQSQueueURL != "" {
+		wg.Add(1)
+		go m.runTaskStateEvents(ctx, &wg, r)
+	}
+	wg.Add(3)
 	go m.syncECSToMirage(ctx, &wg)
+	go m.runReaper(ctx, &wg)
 	go m.RunAccessCountCollector(ctx, &wg)
 	wg.Wait()
 	slog.Info("shutdown mirage-ecs")
@@ -88,6 +109,38 @@ func (m *Mirage) Run(ctx context.Context) error {
 	return nil
 }
 
+// serve runs a HTTP server on the port. When tlsConfig is not nil, the server terminates TLS.
+func (m *Mirage) serve(ctx context.Context, cancel func(), wg *sync.WaitGroup, errors chan error, port int, tlsConfig *tls.Config) {
+	defer wg.Done()
+	laddr := fmt.Sprintf("%s:%d", m.Config.Listen.ForeignAddress, port)
+	listener, err := net.Listen("tcp", laddr)
+	if err != nil {
+		slog.Error(f("cannot listen %s: %s", laddr, err))
+		errors <- err
+		cancel()
+		return
+	}
+	scheme := "http"
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+		scheme = "https"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		m.ServeHTTPWithPort(w, req, port)
+	})
+	slog.Info(f("listen addr: %s (%s)", laddr, scheme))
+	srv := &http.Server{
+		Handler:   mux,
+		TLSConfig: tlsConfig,
+	}
+	go srv.Serve(listener)
+	<-ctx.Done()
+	slog.Info(f("shutdown server: %s", laddr))
+	srv.Shutdown(ctx)
+}
+
 func (m *Mirage) ServeHTTPWithPort(w http.ResponseWriter, req *http.Request, port int) {
 	host := strings.ToLower(strings.Split(req.Host, ":")[0])
 
@@ -99,6 +152,11 @@ func (m *Mirage) ServeHTTPWithPort(w http.ResponseWriter, req *http.Request, por
 		m.ReverseProxy.ServeHTTPWithPort(w, req, port)
 
 	case strings.HasSuffix(host, m.Config.Host.ReverseProxySuffix):
+		subdomain := strings.ToLower(strings.Split(host, ".")[0])
+		if m.WebApi.wakeUp(subdomain) {
+			m.wakeUpAndServe(w, req, port, subdomain)
+			return
+		}
 		msg := fmt.Sprintf("%s is not found", host)
 		slog.Warn(msg)
 		http.Error(w, msg, http.StatusNotFound)
@@ -119,6 +177,31 @@ func (m *Mirage) isTaskHost(host string) bool {
 	return false
 }
 
+// wakeUpAndServe waits for the woken subdomain being routed up to wake_timeout.
+// If the subdomain is not ready, it serves a waking page.
+func (m *Mirage) wakeUpAndServe(w http.ResponseWriter, req *http.Request, port int, subdomain string) {
+	if timeout := m.Config.ScaleToZero.WakeTimeout; timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		tk := time.NewTicker(time.Second)
+		defer tk.Stop()
+	WAIT:
+		for m.ReverseProxy.FindHandler(subdomain, port) == nil {
+			select {
+			case <-ctx.Done():
+				break WAIT
+			case <-tk.C:
+			}
+		}
+	}
+	if m.ReverseProxy.Exists(subdomain) {
+		// serves a starting page if not ready
+		m.ReverseProxy.ServeHTTPWithPort(w, req, port)
+		return
+	}
+	serveWakingPage(w, subdomain)
+}
+
 func (m *Mirage) isWebApiHost(host string) bool {
 	return isSameHost(m.Config.Host.WebApi, host)
 }
@@ -153,6 +236,113 @@ const (
 	CloudWatchDimensionName   = "subdomain"
 )
 
+// runReaper terminates expired subdomains periodically.
+func (m *Mirage) runReaper(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	tk := time.NewTicker(reaperInterval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			slog.Debug("runReaper() is done")
+			return
+		}
+		if err := m.WebApi.terminateExpired(ctx); err != nil {
+			slog.Warn(f("failed to terminate expired subdomains: %s", err))
+		}
+	}
+}
+
+// runPurgeScheduler purges subdomains periodically by the purge config.
+func (m *Mirage) runPurgeScheduler(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	slog.Info(f("purge scheduler is enabled. interval=%s", m.Config.Purge.Interval))
+	tk := time.NewTicker(m.Config.Purge.Interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			slog.Debug("runPurgeScheduler() is done")
+			return
+		}
+		if err := m.WebApi.runScheduledPurge(ctx); err != nil {
+			slog.Warn(f("scheduled purge failed: %s", err))
+		}
+	}
+}
+
+// runScaleToZero stops idle subdomains periodically.
+func (m *Mirage) runScaleToZero(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	slog.Info(f("scale to zero is enabled. idle_duration=%s interval=%s", m.Config.ScaleToZero.IdleDuration, m.Config.ScaleToZero.Interval))
+	tk := time.NewTicker(m.Config.ScaleToZero.Interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			slog.Debug("runScaleToZero() is done")
+			return
+		}
+		if err := m.WebApi.runScaleToZero(ctx); err != nil {
+			slog.Warn(f("scale to zero failed: %s", err))
+		}
+	}
+}
+
+// runNotifier delivers lifecycle events to webhooks.
+func (m *Mirage) runNotifier(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	slog.Info(f("notifications are enabled. %d webhooks", len(m.Config.Notifications.Webhooks)))
+	NewNotifier(m.Config.Notifications).Run(ctx, m.Config.EventBus())
+	slog.Debug("runNotifier() is done")
+}
+
+// runTaskStateEvents receives task state change events to refresh the task state cache.
+func (m *Mirage) runTaskStateEvents(ctx context.Context, wg *sync.WaitGroup, r taskStateEventReceiver) {
+	defer wg.Done()
+	slog.Info(f("task state change events are enabled. queue=%s", m.Config.TaskCache.SQSQueueURL))
+	r.ReceiveTaskStateEvents(ctx)
+	slog.Debug("runTaskStateEvents() is done")
+}
+
+// publishTaskStatuses publishes events of tasks whose status has changed.
+func (app *Mirage) publishTaskStatuses(infos ...[]*Information) {
+	seen := make(map[string]struct{}, len(app.taskStatuses))
+	for _, info := range lo.Flatten(infos) {
+		seen[info.ID] = struct{}{}
+		prev, known := app.taskStatuses[info.ID]
+		if prev == info.LastStatus {
+			continue
+		}
+		app.taskStatuses[info.ID] = info.LastStatus
+		app.Config.EventBus().Publish(&Event{
+			Type:      EventTaskStatus,
+			Subdomain: info.SubDomain,
+			TaskID:    info.ShortID,
+			Status:    info.LastStatus,
+		})
+		// tasks already stopped at startup are not notified
+		if known && info.stoppedUnexpectedly() {
+			app.Config.EventBus().Publish(&Event{
+				Type:      EventTaskStopped,
+				Subdomain: info.SubDomain,
+				TaskID:    info.ShortID,
+				Status:    info.LastStatus,
+				Detail:    map[string]string{"stopped_reason": info.StoppedReason},
+			})
+		}
+	}
+	for id := range app.taskStatuses {
+		if _, ok := seen[id]; !ok {
+			// forgotten by ECS
+			delete(app.taskStatuses, id)
+		}
+	}
+}
+
 func (app *Mirage) syncECSToMirage(ctx context.Context, wg *sync.WaitGroup) {
 	wg.Done()
 	slog.Debug("starting up syncECSToMirage()")
@@ -174,6 +364,11 @@ SYNC:
 			return
 		}
 
+		if r, ok := app.runner.(taskStateRefresher); ok {
+			if err := r.RefreshTaskStates(ctx); err != nil {
+				slog.Warn(f("failed to refresh task states: %s", err))
+			}
+		}
 		running, err := app.runner.List(ctx, statusRunning)
 		if err != nil {
 			slog.Warn(err.Error())
@@ -206,6 +401,8 @@ SYNC:
 			}
 		}
 
+		app.publishTaskStatuses(running, stopped)
+
 		for _, subdomain := range rp.Subdomains() {
 			if !available[subdomain] {
 				rp.RemoveSubdomain(subdomain)
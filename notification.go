@@ -0,0 +1,400 @@
+package mirageecs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+const (
+	WebhookFormatJSON  = "json"
+	WebhookFormatSlack = "slack"
+
+	DefaultExpiryWarning     = 30 * time.Minute
+	DefaultWebhookTimeout    = 10 * time.Second
+	DefaultWebhookMaxRetries = 3
+
+	// WebhookSignatureTolerance is a recommended tolerance of the signed timestamp for receivers.
+	WebhookSignatureTolerance = 5 * time.Minute
+
+	webhookQueueSize      = 100
+	webhookInitialBackoff = time.Second
+	webhookSignatureName  = "X-Mirage-Signature"
+)
+
+// DefaultNotificationEvents are event types notified when webhook.events is empty.
+var DefaultNotificationEvents = []string{
+	EventLaunched,
+	EventLaunchFailed,
+	EventTaskStopped,
+	EventPurged,
+	EventExpiring,
+}
+
+var allEventTypes = []string{
+	EventLaunchRequested,
+	EventLaunched,
+	EventLaunchFailed,
+	EventTerminateRequested,
+	EventTaskStatus,
+	EventTaskStopped,
+	EventRouteAdded,
+	EventRouteRemoved,
+	EventRoute53Changed,
+	EventPurged,
+	EventExpiring,
+	EventExpired,
+	EventSlept,
+	EventWoken,
+}
+
+// NotificationsCfg configures outbound notifications of lifecycle events.
+type NotificationsCfg struct {
+	// ExpiryWarning is a duration before the expiration to notify expiring event.
+	ExpiryWarning time.Duration `yaml:"expiry_warning"`
+	Webhooks      []*WebhookCfg `yaml:"webhooks"`
+}
+
+// WebhookCfg is an endpoint of webhook notifications.
+type WebhookCfg struct {
+	URL        string        `yaml:"url"`
+	Format     string        `yaml:"format"` // json (default) or slack
+	Secret     string        `yaml:"secret"` // for HMAC-SHA256 signature
+	Events     []string      `yaml:"events"`
+	Subdomains []string      `yaml:"subdomains"` // path.Match patterns
+	MaxRetries *int          `yaml:"max_retries"`
+	Timeout    time.Duration `yaml:"timeout"`
+}
+
+func (c *NotificationsCfg) validate() error {
+	if c.ExpiryWarning == 0 {
+		c.ExpiryWarning = DefaultExpiryWarning
+	}
+	if c.ExpiryWarning < 0 {
+		return fmt.Errorf("notifications.expiry_warning must be positive: %s", c.ExpiryWarning)
+	}
+	for i, w := range c.Webhooks {
+		if err := w.validate(); err != nil {
+			return fmt.Errorf("notifications.webhooks[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (w *WebhookCfg) validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %s", w.URL)
+	}
+	switch w.Format {
+	case "":
+		w.Format = WebhookFormatJSON
+	case WebhookFormatJSON, WebhookFormatSlack:
+	default:
+		return fmt.Errorf("unknown format %s", w.Format)
+	}
+	if len(w.Events) == 0 {
+		w.Events = DefaultNotificationEvents
+	}
+	for _, ev := range w.Events {
+		if !lo.Contains(allEventTypes, ev) {
+			return fmt.Errorf("unknown event type %s", ev)
+		}
+	}
+	for _, p := range w.Subdomains {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid subdomain pattern %s: %w", p, err)
+		}
+	}
+	if w.MaxRetries == nil {
+		w.MaxRetries = lo.ToPtr(DefaultWebhookMaxRetries)
+	} else if *w.MaxRetries < 0 {
+		return fmt.Errorf("max_retries must not be negative")
+	}
+	if w.Timeout == 0 {
+		w.Timeout = DefaultWebhookTimeout
+	}
+	return nil
+}
+
+// match reports whether the event should be delivered to the webhook.
+func (w *WebhookCfg) match(ev *Event) bool {
+	if !lo.Contains(w.Events, ev.Type) {
+		return false
+	}
+	if len(w.Subdomains) == 0 {
+		return true
+	}
+	for _, p := range w.Subdomains {
+		if ok, _ := path.Match(p, ev.Subdomain); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// payload returns the request body of the event.
+func (w *WebhookCfg) payload(ev *Event) ([]byte, error) {
+	switch w.Format {
+	case WebhookFormatSlack:
+		return json.Marshal(map[string]string{"text": ev.Text()})
+	default:
+		return json.Marshal(ev)
+	}
+}
+
+// Text returns a human readable message of the event.
+func (ev *Event) Text() string {
+	var s string
+	switch ev.Type {
+	case EventLaunched:
+		s = fmt.Sprintf("subdomain %s is launched", ev.Subdomain)
+	case EventLaunchFailed:
+		s = fmt.Sprintf("failed to launch subdomain %s: %s", ev.Subdomain, ev.Detail["error"])
+	case EventTaskStopped:
+		s = fmt.Sprintf("task %s of subdomain %s stopped unexpectedly: %s", ev.TaskID, ev.Subdomain, ev.Detail["stopped_reason"])
+	case EventPurged:
+		s = fmt.Sprintf("subdomain %s is purged", ev.Subdomain)
+	case EventExpiring:
+		s = fmt.Sprintf("subdomain %s will expire at %s", ev.Subdomain, ev.Detail["expire_at"])
+	case EventExpired:
+		s = fmt.Sprintf("subdomain %s is expired", ev.Subdomain)
+	default:
+		s = fmt.Sprintf("%s subdomain %s", ev.Type, ev.Subdomain)
+		if ev.Status != "" {
+			s += " " + ev.Status
+		}
+	}
+	if ev.Actor != "" {
+		s += fmt.Sprintf(" (by %s)", ev.Actor)
+	}
+	return "[mirage-ecs] " + s
+}
+
+func webhookMAC(secret string, ts int64, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", ts)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// signWebhookPayload returns a signature of the payload for the X-Mirage-Signature header.
+// The timestamp is signed with the payload to prevent replay attacks.
+func signWebhookPayload(secret string, body []byte, now time.Time) string {
+	ts := now.Unix()
+	return fmt.Sprintf("t=%d,v1=%s", ts, hex.EncodeToString(webhookMAC(secret, ts, body)))
+}
+
+// VerifyWebhookSignature verifies the X-Mirage-Signature header of the webhook request.
+// It returns an error when the signature does not match or the timestamp is not within the tolerance.
+func VerifyWebhookSignature(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var ts int64
+	var sigs [][]byte
+	for _, kv := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		switch k {
+		case "t":
+			ts, _ = strconv.ParseInt(v, 10, 64)
+		case "v1":
+			if sig, err := hex.DecodeString(v); err == nil {
+				sigs = append(sigs, sig)
+			}
+		}
+	}
+	if ts == 0 || len(sigs) == 0 {
+		return fmt.Errorf("invalid signature header %q", header)
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > tolerance || d < -tolerance {
+		return fmt.Errorf("timestamp %d is out of the tolerance %s", ts, tolerance)
+	}
+	expected := webhookMAC(secret, ts, body)
+	for _, sig := range sigs {
+		if hmac.Equal(sig, expected) {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature mismatch")
+}
+
+// Notifier delivers events to webhooks asynchronously.
+type Notifier struct {
+	cfg    *NotificationsCfg
+	client *http.Client
+	queues []chan *Event
+}
+
+func NewNotifier(cfg *NotificationsCfg) *Notifier {
+	n := &Notifier{
+		cfg:    cfg,
+		client: &http.Client{},
+		queues: make([]chan *Event, len(cfg.Webhooks)),
+	}
+	for i := range cfg.Webhooks {
+		n.queues[i] = make(chan *Event, webhookQueueSize)
+	}
+	return n
+}
+
+// Run delivers events from the bus until ctx is done.
+func (n *Notifier) Run(ctx context.Context, bus *EventBus) {
+	ch, unsubscribe := bus.Subscribe(0)
+	defer unsubscribe()
+
+	var wg sync.WaitGroup
+	for i, w := range n.cfg.Webhooks {
+		wg.Add(1)
+		go func(w *WebhookCfg, queue chan *Event) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case ev := <-queue:
+					n.deliver(ctx, w, ev)
+				}
+			}
+		}(w, n.queues[i])
+	}
+	defer wg.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-ch:
+			n.dispatch(ev)
+		}
+	}
+}
+
+// dispatch enqueues the event to the matched webhooks. It never blocks.
+func (n *Notifier) dispatch(ev *Event) {
+	for i, w := range n.cfg.Webhooks {
+		if !w.match(ev) {
+			continue
+		}
+		select {
+		case n.queues[i] <- ev:
+		default:
+			slog.Warn(f("webhook queue for %s is full. dropped event %d", w.URL, ev.ID))
+		}
+	}
+}
+
+// deliver posts the event to the webhook with retries.
+func (n *Notifier) deliver(ctx context.Context, w *WebhookCfg, ev *Event) {
+	body, err := w.payload(ev)
+	if err != nil {
+		slog.Warn(f("failed to build webhook payload: %s", err))
+		return
+	}
+	backoff := webhookInitialBackoff
+	for i := 0; ; i++ {
+		retryable, err := n.post(ctx, w, ev, body)
+		if err == nil {
+			slog.Debug(f("webhook %s delivered event %d", w.URL, ev.ID))
+			return
+		}
+		if !retryable || i >= *w.MaxRetries {
+			slog.Warn(f("webhook %s failed to deliver event %d: %s", w.URL, ev.ID, err))
+			return
+		}
+		slog.Info(f("webhook %s failed to deliver event %d: %s. retrying in %s", w.URL, ev.ID, err, backoff))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, w *WebhookCfg, ev *Event, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mirage-ecs/"+Version)
+	req.Header.Set("X-Mirage-Event", ev.Type)
+	req.Header.Set("X-Mirage-Delivery", strconv.FormatInt(ev.ID, 10))
+	if w.Secret != "" {
+		// signed for each attempt, the timestamp is fresh for retries
+		req.Header.Set(webhookSignatureName, signWebhookPayload(w.Secret, body, time.Now()))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("status %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("status %d", resp.StatusCode)
+	}
+}
+
+// expiryWarnings remembers subdomains notified as expiring.
+type expiryWarnings struct {
+	mu     sync.Mutex
+	warned map[string]time.Time // subdomain -> expire at
+}
+
+func newExpiryWarnings() *expiryWarnings {
+	return &expiryWarnings{warned: make(map[string]time.Time)}
+}
+
+// warnExpiring publishes expiring events for subdomains expiring within the expiry_warning.
+// A subdomain is warned again when its expiration is changed.
+func (api *WebApi) warnExpiring(infos []*Information, now time.Time) {
+	if api.cfg.Notifications == nil {
+		return
+	}
+	w := api.expiryWarnings
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	seen := make(map[string]struct{}, len(infos))
+	for _, info := range infos {
+		if info.ExpireAt == nil {
+			continue
+		}
+		seen[info.SubDomain] = struct{}{}
+		if info.Expired(now) || info.ExpireAt.Sub(now) > api.cfg.Notifications.ExpiryWarning {
+			continue
+		}
+		if t, ok := w.warned[info.SubDomain]; ok && t.Equal(*info.ExpireAt) {
+			continue
+		}
+		w.warned[info.SubDomain] = *info.ExpireAt
+		api.cfg.EventBus().Publish(&Event{
+			Type:      EventExpiring,
+			Subdomain: info.SubDomain,
+			Actor:     SystemIdentity.String(),
+			Detail:    map[string]string{"expire_at": info.ExpireAt.Format(time.RFC3339)},
+		})
+	}
+	for subdomain := range w.warned {
+		if _, ok := seen[subdomain]; !ok {
+			delete(w.warned, subdomain)
+		}
+	}
+}
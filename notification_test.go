@@ -0,0 +1,230 @@
+package mirageecs_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+type webhookReceiver struct {
+	mu       sync.Mutex
+	failures int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	b, _ := io.ReadAll(req.Body)
+	r.bodies = append(r.bodies, b)
+	r.headers = append(r.headers, req.Header.Clone())
+}
+
+func (r *webhookReceiver) received() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.bodies)
+}
+
+func TestNotificationsCfgValidate(t *testing.T) {
+	invalids := []*mirageecs.NotificationsCfg{
+		{Webhooks: []*mirageecs.WebhookCfg{{URL: "ftp://example.com"}}},
+		{Webhooks: []*mirageecs.WebhookCfg{{URL: "https://example.com", Format: "xml"}}},
+		{Webhooks: []*mirageecs.WebhookCfg{{URL: "https://example.com", Events: []string{"unknown"}}}},
+		{Webhooks: []*mirageecs.WebhookCfg{{URL: "https://example.com", Subdomains: []string{"["}}}},
+	}
+	for i, c := range invalids {
+		if err := c.Validate(); err == nil {
+			t.Errorf("invalids[%d] should be error", i)
+		}
+	}
+	c := &mirageecs.NotificationsCfg{Webhooks: []*mirageecs.WebhookCfg{{URL: "https://example.com"}}}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	w := c.Webhooks[0]
+	if w.Format != mirageecs.WebhookFormatJSON || *w.MaxRetries != mirageecs.DefaultWebhookMaxRetries || len(w.Events) != len(mirageecs.DefaultNotificationEvents) {
+		t.Errorf("unexpected defaults %#v", w)
+	}
+	if c.ExpiryWarning != mirageecs.DefaultExpiryWarning {
+		t.Errorf("unexpected expiry_warning %s", c.ExpiryWarning)
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	jsonReceiver := &webhookReceiver{failures: 1}
+	jsonServer := httptest.NewServer(jsonReceiver)
+	defer jsonServer.Close()
+	slackReceiver := &webhookReceiver{}
+	slackServer := httptest.NewServer(slackReceiver)
+	defer slackServer.Close()
+
+	cfg := &mirageecs.NotificationsCfg{
+		Webhooks: []*mirageecs.WebhookCfg{
+			{URL: jsonServer.URL, Secret: "secret", Subdomains: []string{"feature-*"}},
+			{URL: slackServer.URL, Format: mirageecs.WebhookFormatSlack, Events: []string{mirageecs.EventPurged}},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	bus := mirageecs.NewEventBus()
+	done := make(chan struct{})
+	go func() {
+		mirageecs.NewNotifier(cfg).Run(ctx, bus)
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond) // wait for subscribing
+
+	bus.Publish(&mirageecs.Event{Type: mirageecs.EventLaunched, Subdomain: "feature-a"})
+	bus.Publish(&mirageecs.Event{Type: mirageecs.EventLaunched, Subdomain: "main"})        // not matched subdomain
+	bus.Publish(&mirageecs.Event{Type: mirageecs.EventTaskStatus, Subdomain: "feature-a"}) // not matched event
+	bus.Publish(&mirageecs.Event{Type: mirageecs.EventPurged, Subdomain: "main"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for jsonReceiver.received() < 1 || slackReceiver.received() < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("webhooks are not delivered")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	<-done
+
+	if n := jsonReceiver.received(); n != 1 {
+		t.Errorf("json webhook received %d events", n)
+	}
+	var ev mirageecs.Event
+	if err := json.Unmarshal(jsonReceiver.bodies[0], &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != mirageecs.EventLaunched || ev.Subdomain != "feature-a" {
+		t.Errorf("unexpected event %#v", ev)
+	}
+	h := jsonReceiver.headers[0]
+	if err := mirageecs.VerifyWebhookSignature("secret", h.Get("X-Mirage-Signature"), jsonReceiver.bodies[0], mirageecs.WebhookSignatureTolerance, time.Now()); err != nil {
+		t.Errorf("unexpected signature %s: %s", h.Get("X-Mirage-Signature"), err)
+	}
+	if h.Get("X-Mirage-Event") != mirageecs.EventLaunched {
+		t.Errorf("unexpected event header %s", h.Get("X-Mirage-Event"))
+	}
+
+	if n := slackReceiver.received(); n != 1 {
+		t.Errorf("slack webhook received %d events", n)
+	}
+	var msg map[string]string
+	if err := json.Unmarshal(slackReceiver.bodies[0], &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg["text"] != "[mirage-ecs] subdomain main is purged" {
+		t.Errorf("unexpected slack message %s", msg["text"])
+	}
+	if slackReceiver.headers[0].Get("X-Mirage-Signature") != "" {
+		t.Error("signature should not be set without secret")
+	}
+}
+
+func TestExpiryWarning(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+		Domain:    "localtest.me",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Notifications = &mirageecs.NotificationsCfg{ExpiryWarning: 30 * time.Minute}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	ch, unsubscribe := cfg.EventBus().Subscribe(0)
+	defer unsubscribe()
+	for _, req := range []string{
+		`{"subdomain":"soon","taskdef":["dummy"],"branch":"main","ttl":"10m"}`,
+		`{"subdomain":"later","taskdef":["dummy"],"branch":"main","ttl":"2h"}`,
+	} {
+		res, err := ts.Client().Post(ts.URL+"/api/launch", "application/json", strings.NewReader(req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	// warned only once
+	for i := 0; i < 2; i++ {
+		if err := m.WebApi.TerminateExpired(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var expiring []string
+	for len(ch) > 0 {
+		if ev := <-ch; ev.Type == mirageecs.EventExpiring {
+			expiring = append(expiring, ev.Subdomain)
+		}
+	}
+	if len(expiring) != 1 || expiring[0] != "soon" {
+		t.Errorf("unexpected expiring events %v", expiring)
+	}
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"type":"launched"}`)
+	sig := mirageecs.SignWebhookPayload("secret", body, now)
+	if sig != "t=1700000000,v1="+hex.EncodeToString(hmacSHA256("secret", "1700000000."+string(body))) {
+		t.Errorf("unexpected signature %s", sig)
+	}
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		body   []byte
+		now    time.Time
+		valid  bool
+	}{
+		{"valid", "secret", sig, body, now, true},
+		{"within tolerance", "secret", sig, body, now.Add(mirageecs.WebhookSignatureTolerance), true},
+		{"replayed", "secret", sig, body, now.Add(mirageecs.WebhookSignatureTolerance + time.Second), false},
+		{"wrong secret", "other", sig, body, now, false},
+		{"tampered body", "secret", sig, []byte(`{"type":"purged"}`), now, false},
+		{"tampered timestamp", "secret", strings.Replace(sig, "t=1700000000", "t=1700000100", 1), body, now, false},
+		{"no timestamp", "secret", strings.Replace(sig, "t=1700000000,", "", 1), body, now, false},
+		{"old format", "secret", "sha256=" + hex.EncodeToString(hmacSHA256("secret", string(body))), body, now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := mirageecs.VerifyWebhookSignature(tt.secret, tt.header, tt.body, mirageecs.WebhookSignatureTolerance, tt.now)
+			if (err == nil) != tt.valid {
+				t.Errorf("unexpected result %v", err)
+			}
+		})
+	}
+}
+
+func hmacSHA256(secret, s string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(s))
+	return mac.Sum(nil)
+}
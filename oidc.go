@@ -0,0 +1,486 @@
+package mirageecs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	OIDCSessionCookieName = "mirage-ecs-session"
+	oidcStateCookieName   = "mirage-ecs-oidc"
+
+	OIDCLoginPath    = "/oidc/login"
+	OIDCCallbackPath = "/oidc/callback"
+	OIDCLogoutPath   = "/oidc/logout"
+
+	DefaultOIDCSessionDuration = 24 * time.Hour
+	oidcStateExpire            = 10 * time.Minute
+	oidcHTTPTimeout            = 10 * time.Second
+
+	cookieTypeSession   = "session"
+	cookieTypeOIDCState = "oidc_state"
+)
+
+var DefaultOIDCScopes = []string{"openid", "email"}
+
+// AuthMethodOIDC authenticates users by OpenID Connect authorization code flow with PKCE.
+// mirage-ecs works as a relying party, so it doesn't require an ALB in front.
+type AuthMethodOIDC struct {
+	Issuer          string          `yaml:"issuer"`
+	ClientID        string          `yaml:"client_id"`
+	ClientSecret    string          `yaml:"client_secret"` // optional for public clients
+	RedirectURL     string          `yaml:"redirect_url"`  // e.g. https://mirage.example.net/oidc/callback
+	Scopes          []string        `yaml:"scopes"`
+	Claim           string          `yaml:"claim"`
+	Matchers        []*ClaimMatcher `yaml:"matchers"`
+	SessionDuration time.Duration   `yaml:"session_duration"`
+
+	mu        sync.Mutex
+	provider  *oidcProvider
+	keys      map[string]interface{}
+	client    *http.Client
+	secretKey []byte
+}
+
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func (o *AuthMethodOIDC) validate(cookieSecret string) error {
+	switch {
+	case o.Issuer == "":
+		return fmt.Errorf("issuer is required")
+	case o.ClientID == "":
+		return fmt.Errorf("client_id is required")
+	case o.Claim == "":
+		return fmt.Errorf("claim is required")
+	case cookieSecret == "":
+		return fmt.Errorf("auth.cookie_secret is required to sign sessions")
+	}
+	u, err := url.Parse(o.RedirectURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid redirect_url %s", o.RedirectURL)
+	}
+	if u.Path != OIDCCallbackPath {
+		return fmt.Errorf("path of redirect_url must be %s", OIDCCallbackPath)
+	}
+	if len(o.Scopes) == 0 {
+		o.Scopes = DefaultOIDCScopes
+	}
+	if o.SessionDuration == 0 {
+		o.SessionDuration = DefaultOIDCSessionDuration
+	}
+	return nil
+}
+
+// init prepares the HTTP client and the signing key. It is called once by NewConfig
+// after validate, so that the handlers can read them without locking.
+func (o *AuthMethodOIDC) init(cookieSecret string) {
+	o.client = &http.Client{Timeout: oidcHTTPTimeout}
+	o.secretKey = []byte(cookieSecret)
+}
+
+func (o *AuthMethodOIDC) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discover fetches the OpenID provider metadata. The result is cached after the first success.
+func (o *AuthMethodOIDC) discover(ctx context.Context) (*oidcProvider, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.provider != nil {
+		return o.provider, nil
+	}
+	var p oidcProvider
+	u := strings.TrimSuffix(o.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := o.getJSON(ctx, u, &p); err != nil {
+		return nil, fmt.Errorf("failed to discover OpenID provider: %w", err)
+	}
+	if p.Issuer != o.Issuer {
+		return nil, fmt.Errorf("issuer mismatch: %s != %s", p.Issuer, o.Issuer)
+	}
+	o.provider = &p
+	return o.provider, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k *jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// key returns the public key to verify ID tokens. JWKS is fetched again for an unknown kid (key rotation).
+func (o *AuthMethodOIDC) key(ctx context.Context, kid string) (interface{}, error) {
+	o.mu.Lock()
+	if k, ok := o.keys[kid]; ok {
+		o.mu.Unlock()
+		return k, nil
+	}
+	o.mu.Unlock()
+
+	p, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []*jwk `json:"keys"`
+	}
+	if err := o.getJSON(ctx, p.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		pub, err := k.publicKey()
+		if err != nil {
+			slog.Warn(f("oidc: skip JWK %s: %s", k.Kid, err))
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	o.mu.Lock()
+	o.keys = keys
+	o.mu.Unlock()
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("key %s is not found in JWKS", kid)
+}
+
+// verifyIDToken verifies the signature and the claims of the ID token.
+func (o *AuthMethodOIDC) verifyIDToken(ctx context.Context, idToken, nonce string) (jwt.MapClaims, error) {
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}))
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return o.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if !claims.VerifyIssuer(o.Issuer, true) {
+		return nil, fmt.Errorf("invalid issuer of ID token: %v", claims["iss"])
+	}
+	if !claims.VerifyAudience(o.ClientID, true) {
+		return nil, fmt.Errorf("invalid audience of ID token: %v", claims["aud"])
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, fmt.Errorf("ID token has no exp")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("nonce mismatch")
+	}
+	return claims, nil
+}
+
+// matchClaims returns the claim value if it matches any matchers.
+func (o *AuthMethodOIDC) matchClaims(claims jwt.MapClaims) (string, bool) {
+	v, ok := claims[o.Claim].(string)
+	if !ok {
+		slog.Warn(f("oidc: claim[%s] is not found or not a string", o.Claim))
+		return "", false
+	}
+	for _, m := range o.Matchers {
+		if m.Match(v) {
+			return v, true
+		}
+	}
+	slog.Warn(f("oidc: claim[%s]=%s does not match any matchers", o.Claim, v))
+	return "", false
+}
+
+// exchange exchanges the authorization code for an ID token.
+func (o *AuthMethodOIDC) exchange(ctx context.Context, code, verifier string) (string, error) {
+	p, err := o.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.RedirectURL},
+		"client_id":     {o.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if o.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, b)
+	}
+	var tr struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if tr.IDToken == "" {
+		return "", fmt.Errorf("token response has no id_token")
+	}
+	return tr.IDToken, nil
+}
+
+func (o *AuthMethodOIDC) secureCookie() bool {
+	return strings.HasPrefix(o.RedirectURL, "https://")
+}
+
+// signCookie signs the claims with the cookie secret.
+func (o *AuthMethodOIDC) signCookie(typ string, claims jwt.MapClaims, expire time.Duration) (string, error) {
+	claims["typ"] = typ
+	claims["exp"] = time.Now().Add(expire).Unix()
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(o.secretKey)
+}
+
+// parseCookie validates the cookie signed by signCookie.
+func (o *AuthMethodOIDC) parseCookie(typ, value string) (jwt.MapClaims, error) {
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}))
+	claims := jwt.MapClaims{}
+	if _, err := parser.ParseWithClaims(value, claims, func(*jwt.Token) (interface{}, error) {
+		return o.secretKey, nil
+	}); err != nil {
+		return nil, err
+	}
+	if t, _ := claims["typ"].(string); t != typ {
+		return nil, fmt.Errorf("invalid cookie type %s", t)
+	}
+	return claims, nil
+}
+
+// Match validates the session cookie and returns the claim value of the logged in user.
+func (o *AuthMethodOIDC) Match(req *http.Request) string {
+	c, err := req.Cookie(OIDCSessionCookieName)
+	if err != nil {
+		return ""
+	}
+	claims, err := o.parseCookie(cookieTypeSession, c.Value)
+	if err != nil {
+		slog.Debug(f("oidc: invalid session cookie: %s", err))
+		return ""
+	}
+	name, _ := claims["sub"].(string)
+	return name
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// safeNext returns the path to redirect after login. Only local paths are allowed.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func (a *Auth) ByOIDC(req *http.Request, res http.ResponseWriter) (*Identity, error) {
+	if a == nil || a.OIDC == nil {
+		return nil, nil
+	}
+	if name := a.OIDC.Match(req); name != "" {
+		slog.Debug("oidc auth succeeded")
+		return &Identity{Method: "oidc", Name: name}, nil
+	}
+	slog.Debug("oidc auth failed")
+	return nil, nil
+}
+
+// OIDCLogin redirects to the authorization endpoint of the OpenID provider.
+func (cfg *Config) OIDCLogin(c echo.Context) error {
+	o := cfg.Auth.OIDC
+	p, err := o.discover(c.Request().Context())
+	if err != nil {
+		slog.Error(f("oidc: %s", err))
+		return echo.ErrInternalServerError
+	}
+	state, nonce, verifier := generateRandomHexID(32), generateRandomHexID(32), generateRandomHexID(64)
+	value, err := o.signCookie(cookieTypeOIDCState, jwt.MapClaims{
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"next":     safeNext(c.QueryParam("next")),
+	}, oidcStateExpire)
+	if err != nil {
+		return err
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    value,
+		Path:     OIDCCallbackPath,
+		MaxAge:   int(oidcStateExpire.Seconds()),
+		HttpOnly: true,
+		Secure:   o.secureCookie(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.ClientID},
+		"redirect_uri":          {o.RedirectURL},
+		"scope":                 {strings.Join(o.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return c.Redirect(http.StatusFound, p.AuthorizationEndpoint+sep+q.Encode())
+}
+
+// OIDCCallback completes the login and sets the session cookie.
+func (cfg *Config) OIDCCallback(c echo.Context) error {
+	o := cfg.Auth.OIDC
+	if e := c.QueryParam("error"); e != "" {
+		slog.Warn(f("oidc: authorization failed: %s %s", e, c.QueryParam("error_description")))
+		return echo.ErrUnauthorized
+	}
+	sc, err := c.Cookie(oidcStateCookieName)
+	if err != nil {
+		slog.Warn("oidc: state cookie is not found")
+		return echo.ErrBadRequest
+	}
+	st, err := o.parseCookie(cookieTypeOIDCState, sc.Value)
+	if err != nil {
+		slog.Warn(f("oidc: invalid state cookie: %s", err))
+		return echo.ErrBadRequest
+	}
+	if state, _ := st["state"].(string); state == "" || state != c.QueryParam("state") {
+		slog.Warn("oidc: state mismatch")
+		return echo.ErrBadRequest
+	}
+	verifier, _ := st["verifier"].(string)
+	nonce, _ := st["nonce"].(string)
+	next, _ := st["next"].(string)
+
+	ctx := c.Request().Context()
+	idToken, err := o.exchange(ctx, c.QueryParam("code"), verifier)
+	if err != nil {
+		slog.Error(f("oidc: %s", err))
+		return echo.ErrUnauthorized
+	}
+	claims, err := o.verifyIDToken(ctx, idToken, nonce)
+	if err != nil {
+		slog.Warn(f("oidc: %s", err))
+		return echo.ErrUnauthorized
+	}
+	name, ok := o.matchClaims(claims)
+	if !ok {
+		return echo.ErrForbidden
+	}
+	value, err := o.signCookie(cookieTypeSession, jwt.MapClaims{"sub": name}, o.SessionDuration)
+	if err != nil {
+		return err
+	}
+	slog.Info(f("oidc: %s logged in", name))
+	c.SetCookie(&http.Cookie{Name: oidcStateCookieName, Path: OIDCCallbackPath, MaxAge: -1})
+	c.SetCookie(&http.Cookie{
+		Name:     OIDCSessionCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(o.SessionDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   o.secureCookie(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.Redirect(http.StatusFound, safeNext(next))
+}
+
+// OIDCLogout clears the session cookie.
+func (cfg *Config) OIDCLogout(c echo.Context) error {
+	c.SetCookie(&http.Cookie{Name: OIDCSessionCookieName, Path: "/", MaxAge: -1})
+	return c.Redirect(http.StatusFound, "/")
+}
@@ -0,0 +1,216 @@
+package mirageecs_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// mockIdP is a minimal OpenID provider supporting the authorization code flow with PKCE.
+type mockIdP struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	email string
+
+	mu    sync.Mutex
+	codes map[string]url.Values // code -> authorization request
+}
+
+func newMockIdP(t *testing.T, clientID string) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{key: key, codes: map[string]url.Values{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		enc := base64.RawURLEncoding
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"n":   enc.EncodeToString(key.N.Bytes()),
+				"e":   enc.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("client_id") != clientID || q.Get("code_challenge_method") != "S256" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		code := fmt.Sprintf("code-%d", time.Now().UnixNano())
+		idp.mu.Lock()
+		idp.codes[code] = q
+		idp.mu.Unlock()
+		u, _ := url.Parse(q.Get("redirect_uri"))
+		u.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+		http.Redirect(w, r, u.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		idp.mu.Lock()
+		q, ok := idp.codes[r.Form.Get("code")]
+		delete(idp.codes, r.Form.Get("code"))
+		idp.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != q.Get("code_challenge") ||
+			r.Form.Get("redirect_uri") != q.Get("redirect_uri") {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":   idp.URL,
+			"aud":   clientID,
+			"sub":   "1234",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": q.Get("nonce"),
+			"email": idp.email,
+		})
+		token.Header["kid"] = "k1"
+		s, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": s, "token_type": "Bearer"})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := newMockIdP(t, "mirage")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirageURL := "http://" + l.Addr().String()
+	cfg, err := newTestConfig(t, fmt.Sprintf(`auth:
+  cookie_secret: oidc-test-secret
+  oidc:
+    issuer: %s
+    client_id: mirage
+    redirect_url: %s/oidc/callback
+    claim: email
+    matchers:
+      - suffix: "@example.com"
+`, idp.URL, mirageURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(context.Background(), cfg)
+	ts := httptest.NewUnstartedServer(m.WebApi)
+	ts.Listener.Close()
+	ts.Listener = l
+	ts.Start()
+	defer ts.Close()
+
+	newClient := func() *http.Client {
+		jar, _ := cookiejar.New(nil)
+		return &http.Client{Jar: jar}
+	}
+
+	t.Run("unauthenticated htmx request", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, mirageURL+"/list", nil)
+		req.Header.Set("HX-Request", "true")
+		res, err := newClient().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected 401, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("login succeeds", func(t *testing.T) {
+		idp.email = "alice@example.com"
+		client := newClient()
+		res, err := client.Get(mirageURL + "/list")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", res.StatusCode)
+		}
+		if res.Request.URL.Path != "/list" {
+			t.Errorf("should be redirected back to /list, got %s", res.Request.URL)
+		}
+		// session cookie is reused
+		client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+		res, err = client.Get(mirageURL + "/history")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("expected 200 with the session, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("claim does not match", func(t *testing.T) {
+		idp.email = "mallory@example.net"
+		res, err := newClient().Get(mirageURL + "/list")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusForbidden {
+			t.Errorf("expected 403, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("callback without state cookie", func(t *testing.T) {
+		res, err := newClient().Get(mirageURL + "/oidc/callback?code=x&state=y")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d", res.StatusCode)
+		}
+	})
+}
+
+func TestOIDCConfig(t *testing.T) {
+	for _, yaml := range []string{
+		// cookie_secret is missing
+		"auth:\n  oidc:\n    issuer: https://idp.example.com\n    client_id: x\n    redirect_url: https://mirage.example.net/oidc/callback\n    claim: email\n",
+		// invalid callback path
+		"auth:\n  cookie_secret: s\n  oidc:\n    issuer: https://idp.example.com\n    client_id: x\n    redirect_url: https://mirage.example.net/callback\n    claim: email\n",
+	} {
+		if _, err := newTestConfig(t, yaml); err == nil {
+			t.Errorf("config should be invalid: %s", yaml)
+		}
+	}
+}
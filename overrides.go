@@ -0,0 +1,193 @@
+package mirageecs
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/samber/lo"
+)
+
+var errInvalidOverrides = errors.New("invalid task overrides")
+
+// TaskOverridesCfg is an allowlist of overrides by launch requests.
+type TaskOverridesCfg struct {
+	// MaxCPU is the upper bound of task CPU units. Zero disallows overriding CPU.
+	MaxCPU int `yaml:"max_cpu"`
+	// MaxMemory is the upper bound of task memory (MiB). Zero disallows overriding memory.
+	MaxMemory  int                      `yaml:"max_memory"`
+	Containers []*ContainerOverridesCfg `yaml:"containers"`
+}
+
+// ContainerOverridesCfg is what can be overridden for the container.
+type ContainerOverridesCfg struct {
+	Name        string `yaml:"name"`
+	Command     bool   `yaml:"command"`
+	Environment bool   `yaml:"environment"`
+}
+
+func (c *TaskOverridesCfg) validate() error {
+	if c.MaxCPU < 0 || c.MaxMemory < 0 {
+		return fmt.Errorf("max_cpu and max_memory must not be negative")
+	}
+	names := map[string]struct{}{}
+	for i, cc := range c.Containers {
+		if cc.Name == "" {
+			return fmt.Errorf("containers[%d].name is required", i)
+		}
+		if _, ok := names[cc.Name]; ok {
+			return fmt.Errorf("containers[%d].name %s is duplicated", i, cc.Name)
+		}
+		names[cc.Name] = struct{}{}
+	}
+	return nil
+}
+
+func (c *TaskOverridesCfg) container(name string) *ContainerOverridesCfg {
+	cc, _ := lo.Find(c.Containers, func(cc *ContainerOverridesCfg) bool { return cc.Name == name })
+	return cc
+}
+
+// TaskOverrides are overrides of tasks requested by launch requests.
+type TaskOverrides struct {
+	CPU        int                            `json:"cpu,omitempty"`
+	Memory     int                            `json:"memory,omitempty"`
+	Containers map[string]*ContainerOverrides `json:"containers,omitempty"`
+}
+
+// ContainerOverrides are overrides of the container.
+type ContainerOverrides struct {
+	Command     []string          `json:"command,omitempty"`
+	Environment map[string]string `json:"environment,omitempty"`
+}
+
+func (o *TaskOverrides) isEmpty() bool {
+	return o == nil || (o.CPU == 0 && o.Memory == 0 && len(o.Containers) == 0)
+}
+
+// validate checks the overrides are allowed by the config.
+func (o *TaskOverrides) validate(cfg *Config) error {
+	if o.isEmpty() {
+		return nil
+	}
+	allowed := cfg.TaskOverrides
+	if allowed == nil {
+		return fmt.Errorf("task overrides are not allowed")
+	}
+	switch {
+	case o.CPU < 0:
+		return fmt.Errorf("cpu must be positive: %d", o.CPU)
+	case o.CPU > 0 && o.CPU > allowed.MaxCPU:
+		return fmt.Errorf("cpu must be less than or equal to %d: %d", allowed.MaxCPU, o.CPU)
+	case o.Memory < 0:
+		return fmt.Errorf("memory must be positive: %d", o.Memory)
+	case o.Memory > 0 && o.Memory > allowed.MaxMemory:
+		return fmt.Errorf("memory must be less than or equal to %d: %d", allowed.MaxMemory, o.Memory)
+	}
+
+	// environments set by mirage-ecs cannot be overridden
+	reserved := []string{EnvSubdomain, EnvSubdomainRaw}
+	for _, p := range cfg.Parameter {
+		reserved = append(reserved, p.Env)
+	}
+	for name, co := range o.Containers {
+		if co == nil {
+			continue
+		}
+		cc := allowed.container(name)
+		if cc == nil {
+			return fmt.Errorf("overriding container %s is not allowed", name)
+		}
+		if len(co.Command) > 0 && !cc.Command {
+			return fmt.Errorf("overriding command of container %s is not allowed", name)
+		}
+		if len(co.Environment) > 0 && !cc.Environment {
+			return fmt.Errorf("overriding environment of container %s is not allowed", name)
+		}
+		for k := range co.Environment {
+			if k == "" || lo.Contains(reserved, k) {
+				return fmt.Errorf("environment %q of container %s is reserved", k, name)
+			}
+		}
+	}
+	return nil
+}
+
+// checkContainers checks the overridden containers are in the containers of the task definitions.
+func (o *TaskOverrides) checkContainers(names []string) error {
+	for name, co := range o.Containers {
+		if co != nil && !lo.Contains(names, name) {
+			return fmt.Errorf("%w: container %s is not defined in the task definitions", errInvalidOverrides, name)
+		}
+	}
+	return nil
+}
+
+// taskOverridesOf returns the overrides of the launch request from the overrides of the task.
+// Environments set by mirage-ecs are excluded.
+func taskOverridesOf(task *types.Task, params Parameters) *TaskOverrides {
+	if task.Overrides == nil {
+		return nil
+	}
+	reserved := []string{EnvSubdomain, EnvSubdomainRaw}
+	for _, p := range params {
+		reserved = append(reserved, p.Env)
+	}
+	o := &TaskOverrides{Containers: map[string]*ContainerOverrides{}}
+	o.CPU, _ = strconv.Atoi(aws.ToString(task.Overrides.Cpu))
+	o.Memory, _ = strconv.Atoi(aws.ToString(task.Overrides.Memory))
+	for _, c := range task.Overrides.ContainerOverrides {
+		co := &ContainerOverrides{Command: c.Command}
+		for _, kv := range c.Environment {
+			if name := aws.ToString(kv.Name); !lo.Contains(reserved, name) {
+				if co.Environment == nil {
+					co.Environment = map[string]string{}
+				}
+				co.Environment[name] = aws.ToString(kv.Value)
+			}
+		}
+		if len(co.Command) > 0 || len(co.Environment) > 0 {
+			o.Containers[aws.ToString(c.Name)] = co
+		}
+	}
+	if len(o.Containers) == 0 {
+		o.Containers = nil
+	}
+	if o.isEmpty() {
+		return nil
+	}
+	return o
+}
+
+// apply sets the overrides to the task override.
+// ov.ContainerOverrides must have overrides of all containers in the task definition.
+func (o *TaskOverrides) apply(ov *types.TaskOverride) {
+	if o.isEmpty() {
+		return
+	}
+	if o.CPU > 0 {
+		ov.Cpu = aws.String(strconv.Itoa(o.CPU))
+	}
+	if o.Memory > 0 {
+		ov.Memory = aws.String(strconv.Itoa(o.Memory))
+	}
+	for i, c := range ov.ContainerOverrides {
+		co := o.Containers[aws.ToString(c.Name)]
+		if co == nil {
+			continue
+		}
+		if len(co.Command) > 0 {
+			ov.ContainerOverrides[i].Command = co.Command
+		}
+		env := append([]types.KeyValuePair{}, c.Environment...)
+		keys := lo.Keys(co.Environment)
+		sort.Strings(keys)
+		for _, k := range keys {
+			env = append(env, types.KeyValuePair{Name: aws.String(k), Value: aws.String(co.Environment[k])})
+		}
+		ov.ContainerOverrides[i].Environment = env
+	}
+}
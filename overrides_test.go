@@ -0,0 +1,164 @@
+package mirageecs_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+const taskOverridesConfig = `task_overrides:
+  max_cpu: 1024
+  max_memory: 2048
+  containers:
+    - name: app
+      command: true
+      environment: true
+    - name: worker
+      environment: true
+`
+
+func TestTaskOverridesConfig(t *testing.T) {
+	if _, err := newTestConfig(t, taskOverridesConfig); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTestConfig(t, "task_overrides:\n  containers:\n    - name: app\n    - name: app\n"); err == nil {
+		t.Error("duplicated containers should be error")
+	}
+	if _, err := newTestConfig(t, "task_overrides:\n  max_cpu: -1\n"); err == nil {
+		t.Error("negative max_cpu should be error")
+	}
+}
+
+func TestLaunchWithOverrides(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		overrides string
+		status    int
+	}{
+		{"not configured", "{}", `{"cpu":256}`, http.StatusBadRequest},
+		{"empty", "{}", `{}`, http.StatusOK},
+		{"cpu and memory", taskOverridesConfig, `{"cpu":1024,"memory":2048}`, http.StatusOK},
+		{"too much cpu", taskOverridesConfig, `{"cpu":2048}`, http.StatusBadRequest},
+		{"too much memory", taskOverridesConfig, `{"memory":4096}`, http.StatusBadRequest},
+		{"command", taskOverridesConfig, `{"containers":{"app":{"command":["sleep","3600"]}}}`, http.StatusOK},
+		{"command not allowed", taskOverridesConfig, `{"containers":{"worker":{"command":["sleep","3600"]}}}`, http.StatusBadRequest},
+		{"unknown container", taskOverridesConfig, `{"containers":{"db":{"environment":{"FOO":"bar"}}}}`, http.StatusBadRequest},
+		{"reserved env", taskOverridesConfig, `{"containers":{"app":{"environment":{"GIT_BRANCH":"main"}}}}`, http.StatusBadRequest},
+		{"env", taskOverridesConfig, `{"containers":{"app":{"environment":{"FOO":"bar"}}}}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := newTestConfig(t, tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := mirageecs.New(context.Background(), cfg)
+			ts := httptest.NewServer(m.WebApi)
+			defer ts.Close()
+
+			res, err := ts.Client().Post(ts.URL+"/api/launch", "application/json",
+				strings.NewReader(`{"subdomain":"mytask","taskdef":["dummy"],"branch":"main","overrides":`+tt.overrides+`}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.status {
+				t.Fatalf("unexpected status %d, expected %d", res.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestLaunchWithOverridesEnv(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := newTestConfig(t, taskOverridesConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	res, err := ts.Client().Post(ts.URL+"/api/launch", "application/json",
+		strings.NewReader(`{"subdomain":"mytask","taskdef":["dummy"],"branch":"main","overrides":{"containers":{"app":{"environment":{"FOO":"bar"}}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", res.StatusCode)
+	}
+	infos, _ := m.Runner().List(ctx, "RUNNING")
+	if len(infos) != 1 || infos[0].Env["FOO"] != "bar" || infos[0].Env["GIT_BRANCH"] != "main" {
+		t.Errorf("unexpected env %#v", infos)
+	}
+}
+
+func TestTaskOverridesApply(t *testing.T) {
+	env := []types.KeyValuePair{{Name: aws.String("SUBDOMAIN"), Value: aws.String("bXl0YXNr")}}
+	ov := &types.TaskOverride{
+		ContainerOverrides: []types.ContainerOverride{
+			{Name: aws.String("app"), Environment: env},
+			{Name: aws.String("sidecar"), Environment: env},
+		},
+	}
+	o := &mirageecs.TaskOverrides{
+		CPU:    512,
+		Memory: 1024,
+		Containers: map[string]*mirageecs.ContainerOverrides{
+			"app": {
+				Command:     []string{"sleep", "3600"},
+				Environment: map[string]string{"B": "2", "A": "1"},
+			},
+		},
+	}
+	o.Apply(ov)
+	if aws.ToString(ov.Cpu) != "512" || aws.ToString(ov.Memory) != "1024" {
+		t.Errorf("unexpected cpu/memory %s/%s", aws.ToString(ov.Cpu), aws.ToString(ov.Memory))
+	}
+	app, sidecar := ov.ContainerOverrides[0], ov.ContainerOverrides[1]
+	if strings.Join(app.Command, " ") != "sleep 3600" {
+		t.Errorf("unexpected command %v", app.Command)
+	}
+	names := make([]string, 0, len(app.Environment))
+	for _, e := range app.Environment {
+		names = append(names, aws.ToString(e.Name))
+	}
+	if strings.Join(names, ",") != "SUBDOMAIN,A,B" {
+		t.Errorf("unexpected environment %v", names)
+	}
+	if len(sidecar.Command) != 0 || len(sidecar.Environment) != 1 {
+		t.Errorf("sidecar must not be overridden %#v", sidecar)
+	}
+
+	// the overrides are restored from the task to wake it up
+	restored := mirageecs.TaskOverridesOf(&types.Task{Overrides: ov}, nil)
+	if diff := cmp.Diff(o, restored); diff != "" {
+		t.Errorf("unexpected restored overrides %s", diff)
+	}
+	if o := mirageecs.TaskOverridesOf(&types.Task{Overrides: &types.TaskOverride{ContainerOverrides: []types.ContainerOverride{{Name: aws.String("app"), Environment: env}}}}, nil); o != nil {
+		t.Errorf("environments set by mirage-ecs are not overrides %#v", o)
+	}
+}
+
+func TestTaskOverridesCheckContainers(t *testing.T) {
+	o := &mirageecs.TaskOverrides{
+		Containers: map[string]*mirageecs.ContainerOverrides{
+			"app": {Command: []string{"sleep", "3600"}},
+		},
+	}
+	if err := o.CheckContainers([]string{"app", "sidecar"}); err != nil {
+		t.Error(err)
+	}
+	if err := o.CheckContainers([]string{"web", "sidecar"}); err == nil {
+		t.Error("a container not in the task definitions must be invalid")
+	}
+}
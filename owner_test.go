@@ -0,0 +1,106 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestSubdomainOwner(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := newTestConfig(t, `auth:
+  token:
+    header: x-mirage-token
+    token: mytoken
+  basic:
+    username: alice
+    password: secret
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	launch := func(body string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/launch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("x-mirage-token", "mytoken")
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	owners := func() map[string]string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/list", nil)
+		req.Header.Set("x-mirage-token", "mytoken")
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var r mirageecs.APIListResponse
+		if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		o := map[string]string{}
+		for _, info := range r.Result {
+			o[info.SubDomain] = info.Owner
+		}
+		return o
+	}
+
+	if code := launch(`{"subdomain":"mine","taskdef":["dummy"],"branch":"main"}`); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	// relaunching own subdomain is allowed
+	if code := launch(`{"subdomain":"mine","taskdef":["dummy"],"branch":"develop"}`); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if _, err := m.Runner().Launch(ctx, "others", mirageecs.TaskParameter{"branch": "main"}, mirageecs.LaunchOption{Owner: "basic:alice"}, "dummy"); err != nil {
+		t.Fatal(err)
+	}
+	if o := owners(); o["mine"] != "token:x-mirage-token" || o["others"] != "basic:alice" {
+		t.Errorf("unexpected owners %v", o)
+	}
+
+	if code := launch(`{"subdomain":"others","taskdef":["dummy"],"branch":"main"}`); code != http.StatusConflict {
+		t.Errorf("overwriting others without force should be 409: %d", code)
+	}
+	if o := owners(); o["others"] != "basic:alice" {
+		t.Errorf("refused launch must not change the subdomain %v", o)
+	}
+	// all identities are admin without roles
+	if code := launch(`{"subdomain":"others","taskdef":["dummy"],"branch":"main","force":true}`); code != http.StatusOK {
+		t.Errorf("overwriting others with force should be allowed: %d", code)
+	}
+	if o := owners(); o["others"] != "token:x-mirage-token" {
+		t.Errorf("owner should be replaced %v", o)
+	}
+
+	if _, err := m.Runner().Launch(ctx, "alices", mirageecs.TaskParameter{"branch": "main"}, mirageecs.LaunchOption{Owner: "basic:alice"}, "dummy"); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/list?mine=true", nil)
+	req.SetBasicAuth("alice", "secret")
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, _ := io.ReadAll(res.Body)
+	if !strings.Contains(string(b), "alices") || strings.Contains(string(b), ">mine<") {
+		t.Errorf("mine only list should include only alice's subdomains: %s", b)
+	}
+}
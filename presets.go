@@ -0,0 +1,367 @@
+package mirageecs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/lo"
+)
+
+const (
+	PresetSourceConfig = "config"
+	PresetSourceStore  = "store"
+)
+
+var (
+	presetNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+
+	errInvalidPreset            = errors.New("invalid preset")
+	errPresetNotFound           = errors.New("preset is not found")
+	errPresetReadOnly           = errors.New("preset defined in config is read only")
+	errPresetStoreNotConfigured = errors.New("preset_store is not configured")
+)
+
+// Preset is a named template of launch requests.
+type Preset struct {
+	Name        string            `yaml:"name" json:"name"`
+	Description string            `yaml:"description" json:"description,omitempty"`
+	Taskdefs    []string          `yaml:"taskdefs" json:"taskdefs"`
+	Parameters  map[string]string `yaml:"parameters" json:"parameters,omitempty"`
+	TTL         string            `yaml:"ttl" json:"ttl,omitempty"`
+	Tags        map[string]string `yaml:"tags" json:"tags,omitempty"`
+	Target      string            `yaml:"target" json:"target,omitempty"`
+	Source      string            `yaml:"-" json:"source"` // config or store
+}
+
+func (p *Preset) validate(cfg *Config) error {
+	if !presetNameRegexp.MatchString(p.Name) {
+		return fmt.Errorf("invalid preset name %q", p.Name)
+	}
+	if len(p.Taskdefs) == 0 {
+		return fmt.Errorf("preset %s: taskdefs are required", p.Name)
+	}
+	if p.TTL != "" {
+		if d, err := time.ParseDuration(p.TTL); err != nil || d <= 0 {
+			return fmt.Errorf("preset %s: invalid ttl %s", p.Name, p.TTL)
+		}
+	}
+	if !cfg.ECS.HasTarget(p.Target) {
+		return fmt.Errorf("preset %s: unknown target %s", p.Name, p.Target)
+	}
+	for k := range p.Tags {
+		if k == "" || cfg.isReservedTag(k) {
+			return fmt.Errorf("preset %s: tag %q is reserved", p.Name, k)
+		}
+	}
+	return nil
+}
+
+// isReservedTag reports whether the tag is set by mirage-ecs or AWS.
+func (c *Config) isReservedTag(key string) bool {
+	reserved := []string{TagManagedBy, TagSubdomain, TagExpireAt, TagOwner}
+	for _, param := range c.Parameter {
+		reserved = append(reserved, param.Name)
+	}
+	return lo.Contains(reserved, key) || strings.HasPrefix(key, "aws:")
+}
+
+// apply fills the unset fields of the launch request by the preset.
+func (p *Preset) apply(r *APILaunchRequest) {
+	if len(r.Taskdef) == 0 {
+		r.Taskdef = p.Taskdefs
+	}
+	for k, v := range p.Parameters {
+		if k == "branch" {
+			if r.Branch == "" {
+				r.Branch = v
+			}
+			continue
+		}
+		if r.Parameters == nil {
+			r.Parameters = make(map[string]string, len(p.Parameters))
+		}
+		if r.Parameters[k] == "" {
+			r.Parameters[k] = v
+		}
+	}
+	if r.TTL == "" && r.ExpireAt == "" {
+		r.TTL = p.TTL
+	}
+	if r.Target == "" {
+		r.Target = p.Target
+	}
+}
+
+// presetStore persists presets created at runtime.
+type presetStore interface {
+	load(ctx context.Context) ([]*Preset, error)
+	save(ctx context.Context, presets []*Preset) error
+}
+
+func newPresetStore(awscfg *aws.Config, u string) (presetStore, error) {
+	if !strings.HasPrefix(u, "s3://") {
+		return &filePresetStore{path: u}, nil
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	return &s3PresetStore{
+		svc:    s3.NewFromConfig(*awscfg),
+		bucket: parsed.Host,
+		key:    strings.TrimPrefix(parsed.Path, "/"),
+	}, nil
+}
+
+type filePresetStore struct {
+	path string
+}
+
+func (s *filePresetStore) load(_ context.Context) ([]*Preset, error) {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var presets []*Preset
+	return presets, json.Unmarshal(b, &presets)
+}
+
+func (s *filePresetStore) save(_ context.Context, presets []*Preset) error {
+	b, err := json.MarshalIndent(presets, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write atomically
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+type s3PresetStore struct {
+	svc    *s3.Client
+	bucket string
+	key    string
+}
+
+func (s *s3PresetStore) load(ctx context.Context) ([]*Preset, error) {
+	out, err := s.svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+	})
+	if err != nil {
+		var nsk *s3types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+	var presets []*Preset
+	return presets, json.NewDecoder(out.Body).Decode(&presets)
+}
+
+func (s *s3PresetStore) save(ctx context.Context, presets []*Preset) error {
+	b, err := json.MarshalIndent(presets, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = s.svc.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// PresetRegistry holds presets in the config and the store.
+// Presets in the store are loaded at each access, so changes by other processes are visible.
+type PresetRegistry struct {
+	mu     sync.Mutex
+	cfg    *Config
+	config []*Preset
+	store  presetStore
+}
+
+func (c *Config) newPresetRegistry() (*PresetRegistry, error) {
+	r := &PresetRegistry{cfg: c}
+	for _, p := range c.Presets {
+		if err := p.validate(c); err != nil {
+			return nil, err
+		}
+		if _, found := lo.Find(r.config, func(q *Preset) bool { return q.Name == p.Name }); found {
+			return nil, fmt.Errorf("preset %s is duplicated", p.Name)
+		}
+		p.Source = PresetSourceConfig
+		r.config = append(r.config, p)
+	}
+	if c.PresetStore != "" {
+		s, err := newPresetStore(c.awscfg, c.PresetStore)
+		if err != nil {
+			return nil, fmt.Errorf("invalid preset_store %s: %w", c.PresetStore, err)
+		}
+		r.store = s
+	}
+	return r, nil
+}
+
+// PresetRegistry returns the registry of presets.
+func (c *Config) PresetRegistry() *PresetRegistry {
+	if c.presets == nil {
+		// Config is not created by NewConfig
+		c.presets = &PresetRegistry{cfg: c}
+	}
+	return c.presets
+}
+
+func (r *PresetRegistry) loadStore(ctx context.Context) ([]*Preset, error) {
+	if r.store == nil {
+		return nil, nil
+	}
+	presets, err := r.store.load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load presets: %w", err)
+	}
+	for _, p := range presets {
+		p.Source = PresetSourceStore
+	}
+	return presets, nil
+}
+
+// List returns all presets sorted by name.
+func (r *PresetRegistry) List(ctx context.Context) ([]*Preset, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, err := r.loadStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	presets := append(append([]*Preset{}, r.config...), stored...)
+	sort.SliceStable(presets, func(i, j int) bool {
+		return presets[i].Name < presets[j].Name
+	})
+	return presets, nil
+}
+
+// Get returns the preset by name.
+func (r *PresetRegistry) Get(ctx context.Context, name string) (*Preset, error) {
+	presets, err := r.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if p, ok := lo.Find(presets, func(p *Preset) bool { return p.Name == name }); ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("%w: %s", errPresetNotFound, name)
+}
+
+// Put creates or updates the preset in the store.
+func (r *PresetRegistry) Put(ctx context.Context, p *Preset) error {
+	if r.store == nil {
+		return errPresetStoreNotConfigured
+	}
+	if err := p.validate(r.cfg); err != nil {
+		return fmt.Errorf("%w: %s", errInvalidPreset, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if lo.ContainsBy(r.config, func(q *Preset) bool { return q.Name == p.Name }) {
+		return fmt.Errorf("%w: %s", errPresetReadOnly, p.Name)
+	}
+	stored, err := r.loadStore(ctx)
+	if err != nil {
+		return err
+	}
+	p.Source = PresetSourceStore
+	stored = lo.Reject(stored, func(q *Preset, _ int) bool { return q.Name == p.Name })
+	return r.store.save(ctx, append(stored, p))
+}
+
+// Delete deletes the preset from the store.
+func (r *PresetRegistry) Delete(ctx context.Context, name string) error {
+	if r.store == nil {
+		return errPresetStoreNotConfigured
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if lo.ContainsBy(r.config, func(q *Preset) bool { return q.Name == name }) {
+		return fmt.Errorf("%w: %s", errPresetReadOnly, name)
+	}
+	stored, err := r.loadStore(ctx)
+	if err != nil {
+		return err
+	}
+	rest := lo.Reject(stored, func(q *Preset, _ int) bool { return q.Name == name })
+	if len(rest) == len(stored) {
+		return fmt.Errorf("%w: %s", errPresetNotFound, name)
+	}
+	return r.store.save(ctx, rest)
+}
+
+// presetErrorStatus returns the HTTP status code for the error of PresetRegistry.
+func presetErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errPresetNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errPresetReadOnly):
+		return http.StatusForbidden
+	case errors.Is(err, errInvalidPreset), errors.Is(err, errPresetStoreNotConfigured):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (api *WebApi) ApiPresets(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), APICallTimeout)
+	defer cancel()
+	presets, err := api.cfg.PresetRegistry().List(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, APICommonResponse{Result: err.Error()})
+	}
+	return c.JSON(http.StatusOK, APIPresetsResponse{Result: lo.Map(presets, func(p *Preset, _ int) *Preset {
+		return api.cfg.Redactor().Preset(p)
+	})})
+}
+
+func (api *WebApi) ApiPutPreset(c echo.Context) error {
+	var p Preset
+	if err := c.Bind(&p); err != nil {
+		return c.JSON(http.StatusBadRequest, APICommonResponse{Result: err.Error()})
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), APICallTimeout)
+	defer cancel()
+	if err := api.cfg.PresetRegistry().Put(ctx, &p); err != nil {
+		return c.JSON(presetErrorStatus(err), APICommonResponse{Result: err.Error()})
+	}
+	return c.JSON(http.StatusOK, APICommonResponse{Result: "ok"})
+}
+
+func (api *WebApi) ApiDeletePreset(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), APICallTimeout)
+	defer cancel()
+	if err := api.cfg.PresetRegistry().Delete(ctx, c.Param("name")); err != nil {
+		return c.JSON(presetErrorStatus(err), APICommonResponse{Result: err.Error()})
+	}
+	return c.JSON(http.StatusOK, APICommonResponse{Result: "ok"})
+}
@@ -0,0 +1,133 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestPresets(t *testing.T) {
+	store := filepath.Join(t.TempDir(), "presets.json")
+	cfg, err := newTestConfig(t, `presets:
+  - name: fullstack
+    description: frontend and backend
+    taskdefs: [frontend, backend]
+    parameters:
+      branch: develop
+    ttl: 8h
+    tags:
+      Team: web
+preset_store: `+store+`
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(context.Background(), cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	do := func(method, path, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var r mirageecs.APICommonResponse
+		json.NewDecoder(res.Body).Decode(&r)
+		return res.StatusCode, r.Result
+	}
+	list := func() []*mirageecs.Preset {
+		t.Helper()
+		res, err := ts.Client().Get(ts.URL + "/api/presets")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var r mirageecs.APIPresetsResponse
+		if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		return r.Result
+	}
+
+	if code, _ := do(http.MethodPost, "/api/presets", `{"name":"api","taskdefs":["api"],"ttl":"1h"}`); code != http.StatusOK {
+		t.Fatalf("put preset failed %d", code)
+	}
+	for _, tt := range []struct {
+		body string
+		code int
+	}{
+		{`{"name":"fullstack","taskdefs":["api"]}`, http.StatusForbidden},
+		{`{"name":"no-taskdefs"}`, http.StatusBadRequest},
+		{`{"name":"reserved","taskdefs":["api"],"tags":{"Subdomain":"x"}}`, http.StatusBadRequest},
+		{`{"name":"owner","taskdefs":["api"],"tags":{"Owner":"basic:alice"}}`, http.StatusBadRequest},
+		{`{"name":"unknown-target","taskdefs":["api"],"target":"eu"}`, http.StatusBadRequest},
+	} {
+		if code, _ := do(http.MethodPost, "/api/presets", tt.body); code != tt.code {
+			t.Errorf("%s: expected %d, got %d", tt.body, tt.code, code)
+		}
+	}
+	presets := list()
+	if len(presets) != 2 || presets[0].Name != "api" || presets[0].Source != "store" || presets[1].Name != "fullstack" || presets[1].Source != "config" {
+		t.Fatalf("unexpected presets %#v", presets)
+	}
+
+	// parameters in the request override the preset
+	if code, result := do(http.MethodPost, "/api/launch", `{"subdomain":"web","preset":"fullstack","branch":"main"}`); code != http.StatusOK {
+		t.Fatalf("launch failed %d %s", code, result)
+	}
+	if code, _ := do(http.MethodPost, "/api/launch", `{"subdomain":"web2","preset":"unknown"}`); code != http.StatusBadRequest {
+		t.Errorf("unknown preset should be 400, got %d", code)
+	}
+	runner := m.Runner().(*mirageecs.LocalTaskRunner)
+	info := runner.Informations[0]
+	if info.TaskDef != "frontend" || info.GitBranch != "main" {
+		t.Errorf("preset is not applied %#v", info)
+	}
+	tags := map[string]string{}
+	for _, tag := range info.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	if tags["Team"] != "web" || tags["branch"] != "main" || tags["ExpireAt"] == "" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+
+	if code, _ := do(http.MethodDelete, "/api/presets/fullstack", ""); code != http.StatusForbidden {
+		t.Errorf("config preset should not be deleted, got %d", code)
+	}
+	if code, _ := do(http.MethodDelete, "/api/presets/api", ""); code != http.StatusOK {
+		t.Errorf("delete preset failed %d", code)
+	}
+	if code, _ := do(http.MethodDelete, "/api/presets/api", ""); code != http.StatusNotFound {
+		t.Errorf("deleted preset should be 404, got %d", code)
+	}
+	if presets := list(); len(presets) != 1 {
+		t.Errorf("unexpected presets %#v", presets)
+	}
+}
+
+func TestPresetsWithoutStore(t *testing.T) {
+	cfg, err := newTestConfig(t, "presets:\n  - name: x\n    taskdefs: [x]\n  - name: x\n    taskdefs: [y]\n")
+	if err == nil {
+		t.Fatal("duplicated presets should be error")
+	}
+	cfg, err = newTestConfig(t, "presets:\n  - name: x\n    taskdefs: [x]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.PresetRegistry().Put(context.Background(), &mirageecs.Preset{Name: "y", Taskdefs: []string{"y"}})
+	if err == nil {
+		t.Error("put without preset_store should be error")
+	}
+}
@@ -0,0 +1,166 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+const (
+	PurgeTriggerAPI      = "api"
+	PurgeTriggerSchedule = "schedule"
+
+	DefaultPurgeInterval = time.Hour
+)
+
+// PurgeCfg configures the scheduled purge.
+type PurgeCfg struct {
+	Interval    time.Duration `yaml:"interval"`
+	Duration    time.Duration `yaml:"duration"`
+	Excludes    []string      `yaml:"excludes"`
+	ExcludeTags []string      `yaml:"exclude_tags"`
+	DryRun      bool          `yaml:"dry_run"`
+
+	option *purgeOption
+}
+
+func (c *PurgeCfg) validate() error {
+	if c.Interval == 0 {
+		c.Interval = DefaultPurgeInterval
+	}
+	if c.Interval < time.Minute {
+		return fmt.Errorf("purge.interval %s is too short (at least 1m)", c.Interval)
+	}
+	opt, err := newPurgeOption(c.Duration, c.Excludes, c.ExcludeTags, c.DryRun)
+	if err != nil {
+		return err
+	}
+	c.option = opt
+	return nil
+}
+
+type purgeOption struct {
+	duration    time.Duration
+	excludes    map[string]struct{}
+	excludeTags map[string]string
+	dryRun      bool
+}
+
+func newPurgeOption(duration time.Duration, excludes []string, excludeTags []string, dryRun bool) (*purgeOption, error) {
+	if duration < PurgeMinimumDuration {
+		return nil, fmt.Errorf("invalid duration %s (at least %s)", duration, PurgeMinimumDuration)
+	}
+	opt := &purgeOption{
+		duration:    duration,
+		excludes:    make(map[string]struct{}, len(excludes)),
+		excludeTags: make(map[string]string, len(excludeTags)),
+		dryRun:      dryRun,
+	}
+	for _, exclude := range excludes {
+		opt.excludes[exclude] = struct{}{}
+	}
+	for _, excludeTag := range excludeTags {
+		p := strings.SplitN(excludeTag, ":", 2)
+		if len(p) != 2 {
+			return nil, fmt.Errorf("invalid exclude_tags format %s", excludeTag)
+		}
+		k, v := p[0], p[1]
+		opt.excludeTags[k] = v
+	}
+	return opt, nil
+}
+
+// PurgeReport is a result of purge.
+type PurgeReport struct {
+	Trigger     string            `json:"trigger"`
+	Actor       string            `json:"actor"`
+	DryRun      bool              `json:"dry_run"`
+	Duration    int64             `json:"duration"`
+	StartedAt   time.Time         `json:"started_at"`
+	CompletedAt *time.Time        `json:"completed_at"`
+	Candidates  []string          `json:"candidates"`
+	Skipped     []string          `json:"skipped"` // accessed in the duration
+	Purged      []string          `json:"purged"`  // would be purged in dry run
+	Errors      map[string]string `json:"errors"`
+}
+
+func newPurgeReport(trigger string, actor *Identity, opt *purgeOption, candidates []string) *PurgeReport {
+	return &PurgeReport{
+		Trigger:    trigger,
+		Actor:      actor.String(),
+		DryRun:     opt.dryRun,
+		Duration:   int64(opt.duration.Seconds()),
+		StartedAt:  time.Now(),
+		Candidates: candidates,
+		Skipped:    []string{},
+		Purged:     []string{},
+		Errors:     map[string]string{},
+	}
+}
+
+// purgeStatus holds the latest PurgeReport.
+type purgeStatus struct {
+	mu     sync.Mutex
+	report *PurgeReport
+}
+
+// update calls fn with the report under the lock.
+func (s *purgeStatus) update(fn func(r *PurgeReport)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.report)
+}
+
+func (s *purgeStatus) set(r *PurgeReport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.report = r
+}
+
+// get returns a copy of the latest report.
+func (s *purgeStatus) get() *PurgeReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.report == nil {
+		return nil
+	}
+	r := *s.report
+	r.Candidates = append([]string{}, s.report.Candidates...)
+	r.Skipped = append([]string{}, s.report.Skipped...)
+	r.Purged = append([]string{}, s.report.Purged...)
+	r.Errors = lo.Assign(s.report.Errors)
+	return &r
+}
+
+// purgeCandidates returns subdomains that should be purged.
+func (api *WebApi) purgeCandidates(ctx context.Context, opt *purgeOption) ([]string, error) {
+	infos, err := api.runner.List(ctx, statusRunning)
+	if err != nil {
+		return nil, fmt.Errorf("list tasks failed: %w", err)
+	}
+	tm := make(map[string]struct{}, len(infos))
+	for _, info := range infos {
+		if info.ShouldBePurged(opt.duration, opt.excludes, opt.excludeTags) {
+			tm[info.SubDomain] = struct{}{}
+		}
+	}
+	return lo.Keys(tm), nil
+}
+
+// runScheduledPurge purges subdomains by the purge config.
+func (api *WebApi) runScheduledPurge(ctx context.Context) error {
+	opt := api.cfg.Purge.option
+	slog.Info(f("scheduled purge: duration=%s, excludes=%v, exclude_tags=%v, dry_run=%t",
+		opt.duration, api.cfg.Purge.Excludes, api.cfg.Purge.ExcludeTags, opt.dryRun))
+	candidates, err := api.purgeCandidates(ctx, opt)
+	if err != nil {
+		return err
+	}
+	api.purgeSubdomains(ctx, candidates, opt, PurgeTriggerSchedule, SystemIdentity)
+	return nil
+}
@@ -0,0 +1,97 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestPurgeConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		purge string
+		isErr bool
+	}{
+		{"valid", "purge:\n  interval: 30m\n  duration: 24h\n  exclude_tags: [\"DontPurge:true\"]\n", false},
+		{"default interval", "purge:\n  duration: 24h\n", false},
+		{"too short duration", "purge:\n  duration: 1m\n", true},
+		{"no duration", "purge:\n  interval: 1h\n", true},
+		{"invalid exclude_tags", "purge:\n  duration: 24h\n  exclude_tags: [\"DontPurge\"]\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := newTestConfig(t, tt.purge)
+			if tt.isErr {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Purge.Interval == 0 {
+				t.Error("interval must be filled")
+			}
+		})
+	}
+}
+
+func TestScheduledPurgeDryRun(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := newTestConfig(t, `purge:
+  duration: 1h
+  excludes: ["keep"]
+  dry_run: true
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	runner := m.Runner().(*mirageecs.LocalTaskRunner)
+	for _, subdomain := range []string{"old", "keep", "new"} {
+		if _, err := runner.Launch(ctx, subdomain, mirageecs.TaskParameter{"branch": "main"}, mirageecs.LaunchOption{}, "dummy"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runner.UpdateInformations(func(info *mirageecs.Information) {
+		if info.SubDomain != "new" {
+			info.Created = time.Now().Add(-2 * time.Hour)
+		}
+	})
+	if err := m.WebApi.RunScheduledPurge(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+	res, err := ts.Client().Get(ts.URL + "/api/purge/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status code should be 200: %d", res.StatusCode)
+	}
+	var r mirageecs.APIPurgeStatusResponse
+	json.NewDecoder(res.Body).Decode(&r)
+	if r.Running || r.Result == nil {
+		t.Fatalf("unexpected status %#v", r)
+	}
+	if !r.Result.DryRun || r.Result.Trigger != "schedule" || r.Result.Actor != "system:mirage-ecs" {
+		t.Errorf("unexpected report %#v", r.Result)
+	}
+	if strings.Join(r.Result.Candidates, ",") != "old" || strings.Join(r.Result.Purged, ",") != "old" {
+		t.Errorf("only old should be purged %#v", r.Result)
+	}
+	infos, _ := runner.List(ctx, "RUNNING")
+	if len(infos) != 3 {
+		t.Errorf("dry run must not terminate tasks %d", len(infos))
+	}
+}
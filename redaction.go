@@ -0,0 +1,195 @@
+package mirageecs
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/samber/lo"
+)
+
+// DefaultRedactionEnv is patterns of sensitive environment variable names used when the redaction section is omitted.
+var DefaultRedactionEnv = []string{"*_TOKEN", "*_SECRET", "*_PASSWORD"}
+
+// RedactionCfg is a policy to mask sensitive values in API responses, the web UI and logs.
+// Values passed to ECS are never changed.
+type RedactionCfg struct {
+	// Env is patterns of sensitive environment variable names (case insensitive).
+	Env []string `yaml:"env"`
+	// Tags is patterns of sensitive tag keys (case insensitive).
+	Tags []string `yaml:"tags"`
+}
+
+func (c *RedactionCfg) validate() error {
+	for _, p := range append(append([]string{}, c.Env...), c.Tags...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// Redactor masks sensitive values by the redaction policy and parameters with sensitive: true.
+type Redactor struct {
+	env    []string
+	tags   []string
+	params Parameters
+}
+
+func newRedactor(cfg *RedactionCfg, params Parameters) *Redactor {
+	r := &Redactor{params: params}
+	if cfg == nil {
+		r.env = DefaultRedactionEnv
+		return r
+	}
+	r.env = cfg.Env
+	r.tags = cfg.Tags
+	return r
+}
+
+// Redactor returns the redactor of the config.
+func (c *Config) Redactor() *Redactor {
+	if c.redactor == nil {
+		// Config is not created by NewConfig
+		c.redactor = newRedactor(c.Redaction, c.Parameter)
+	}
+	return c.redactor
+}
+
+func matchAny(patterns []string, name string) bool {
+	name = strings.ToUpper(name)
+	return lo.ContainsBy(patterns, func(p string) bool {
+		ok, _ := path.Match(strings.ToUpper(p), name)
+		return ok
+	})
+}
+
+func (p *Parameter) isSensitive() bool {
+	return p.Sensitive || p.IsSecret()
+}
+
+// IsSensitiveEnv reports whether the environment variable should be masked.
+func (r *Redactor) IsSensitiveEnv(name string) bool {
+	if matchAny(r.env, name) {
+		return true
+	}
+	return lo.ContainsBy(r.params, func(p *Parameter) bool {
+		return p.isSensitive() && strings.EqualFold(p.Env, name)
+	})
+}
+
+// IsSensitiveTag reports whether the tag should be masked.
+func (r *Redactor) IsSensitiveTag(key string) bool {
+	if matchAny(r.tags, key) {
+		return true
+	}
+	return r.IsSensitiveParameter(key)
+}
+
+// IsSensitiveParameter reports whether the parameter should be masked.
+func (r *Redactor) IsSensitiveParameter(name string) bool {
+	return lo.ContainsBy(r.params, func(p *Parameter) bool {
+		return p.isSensitive() && p.Name == name
+	})
+}
+
+// Env returns a copy of env with sensitive values masked.
+func (r *Redactor) Env(env map[string]string) map[string]string {
+	if env == nil {
+		return nil
+	}
+	masked := make(map[string]string, len(env))
+	for k, v := range env {
+		masked[k] = lo.Ternary(r.IsSensitiveEnv(k), RedactedValue, v)
+	}
+	return masked
+}
+
+// Tags returns a copy of tags with sensitive values masked.
+func (r *Redactor) Tags(tags []types.Tag) []types.Tag {
+	return lo.Map(tags, func(t types.Tag, _ int) types.Tag {
+		if r.IsSensitiveTag(aws.ToString(t.Key)) {
+			t.Value = aws.String(RedactedValue)
+		}
+		return t
+	})
+}
+
+// Parameters returns a copy of parameters with sensitive values masked.
+func (r *Redactor) Parameters(params map[string]string) map[string]string {
+	if params == nil {
+		return nil
+	}
+	masked := make(map[string]string, len(params))
+	for k, v := range params {
+		masked[k] = lo.Ternary(r.IsSensitiveParameter(k), RedactedValue, v)
+	}
+	return masked
+}
+
+// Information returns a copy of the information with sensitive values masked.
+func (r *Redactor) Information(info *Information) *Information {
+	masked := *info
+	masked.Env = r.Env(info.Env)
+	masked.Tags = r.Tags(info.Tags)
+	if info.GitBranch != "" && r.IsSensitiveEnv(DefaultParameter.Env) {
+		masked.GitBranch = RedactedValue
+	}
+	return &masked
+}
+
+// Informations returns copies of the informations with sensitive values masked.
+func (r *Redactor) Informations(infos []*Information) []*Information {
+	return lo.Map(infos, func(info *Information, _ int) *Information { return r.Information(info) })
+}
+
+// SleepingSubdomain returns a copy of the sleeping subdomain with sensitive values masked.
+func (r *Redactor) SleepingSubdomain(sl *SleepingSubdomain) *SleepingSubdomain {
+	masked := &SleepingSubdomain{
+		Subdomain: sl.Subdomain,
+		Parameter: r.Parameters(sl.Parameter),
+		Taskdefs:  sl.Taskdefs,
+		Owner:     sl.Owner,
+		SleptAt:   sl.SleptAt,
+	}
+	return masked
+}
+
+// Preset returns a copy of the preset with sensitive values masked.
+func (r *Redactor) Preset(p *Preset) *Preset {
+	masked := *p
+	masked.Parameters = r.Parameters(p.Parameters)
+	masked.Tags = lo.MapEntries(p.Tags, func(k, v string) (string, string) {
+		return k, lo.Ternary(r.IsSensitiveTag(k), RedactedValue, v)
+	})
+	return &masked
+}
+
+// TaskOverride returns a copy of the task override with sensitive environments masked for logging.
+func (r *Redactor) TaskOverride(ov *types.TaskOverride) *types.TaskOverride {
+	if ov == nil {
+		return nil
+	}
+	masked := *ov
+	masked.ContainerOverrides = lo.Map(ov.ContainerOverrides, func(c types.ContainerOverride, _ int) types.ContainerOverride {
+		c.Environment = lo.Map(c.Environment, func(kv types.KeyValuePair, _ int) types.KeyValuePair {
+			if r.IsSensitiveEnv(aws.ToString(kv.Name)) {
+				kv.Value = aws.String(RedactedValue)
+			}
+			return kv
+		})
+		return c
+	})
+	return &masked
+}
+
+// RunTaskInput returns a copy of the input with sensitive values masked for logging.
+func (r *Redactor) RunTaskInput(in *ecs.RunTaskInput) *ecs.RunTaskInput {
+	masked := *in
+	masked.Overrides = r.TaskOverride(in.Overrides)
+	masked.Tags = r.Tags(in.Tags)
+	return &masked
+}
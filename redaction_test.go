@@ -0,0 +1,117 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestRedactionConfig(t *testing.T) {
+	cfg, err := newTestConfig(t, "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := cfg.Redactor()
+	for _, name := range []string{"GITHUB_TOKEN", "api_secret", "DB_PASSWORD"} {
+		if !r.IsSensitiveEnv(name) {
+			t.Errorf("%s should be sensitive by default", name)
+		}
+	}
+	if r.IsSensitiveEnv("GIT_BRANCH") {
+		t.Error("GIT_BRANCH should not be sensitive")
+	}
+	if _, err := newTestConfig(t, "redaction:\n  env: [\"[\"]\n"); err == nil {
+		t.Error("invalid pattern should be error")
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := newTestConfig(t, `parameters:
+  - name: branch
+    env: GIT_BRANCH
+    required: true
+  - name: api_key
+    env: API_KEY
+    sensitive: true
+redaction:
+  env: ["*_TOKEN"]
+  tags: ["Internal*"]
+task_overrides:
+  containers:
+    - name: app
+      environment: true
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	res, err := ts.Client().Post(ts.URL+"/api/launch", "application/json",
+		strings.NewReader(`{"subdomain":"mytask","taskdef":["dummy"],"branch":"main","parameters":{"api_key":"xyzzy"},"overrides":{"containers":{"app":{"environment":{"GITHUB_TOKEN":"ghp_xxx","DB_PASSWORD":"visible"}}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", res.StatusCode)
+	}
+
+	// actual values are unchanged
+	infos, _ := m.Runner().List(ctx, "RUNNING")
+	if len(infos) != 1 || infos[0].Env["API_KEY"] != "xyzzy" || infos[0].Env["GITHUB_TOKEN"] != "ghp_xxx" {
+		t.Fatalf("actual values must not be changed %#v", infos)
+	}
+
+	res, err = ts.Client().Get(ts.URL + "/api/list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var r mirageecs.APIListResponse
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	info := r.Result[0]
+	if info.Env["API_KEY"] != mirageecs.RedactedValue || info.Env["GITHUB_TOKEN"] != mirageecs.RedactedValue {
+		t.Errorf("sensitive env must be masked %#v", info.Env)
+	}
+	if info.Env["DB_PASSWORD"] != "visible" || info.GitBranch != "main" {
+		t.Errorf("env not matched by the policy must not be masked %#v", info.Env)
+	}
+	for _, tag := range info.Tags {
+		if aws.ToString(tag.Key) == "api_key" && aws.ToString(tag.Value) != mirageecs.RedactedValue {
+			t.Errorf("tag of the sensitive parameter must be masked %s", aws.ToString(tag.Value))
+		}
+	}
+
+	res, err = ts.Client().Get(ts.URL + "/api/history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var h mirageecs.APIHistoryResponse
+	if err := json.NewDecoder(res.Body).Decode(&h); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Result) != 1 || h.Result[0].Parameters["api_key"] != mirageecs.RedactedValue || h.Result[0].Parameters["branch"] != "main" {
+		t.Errorf("unexpected history %#v", h.Result)
+	}
+
+	tags := cfg.Redactor().Tags([]types.Tag{
+		{Key: aws.String("InternalID"), Value: aws.String("123")},
+		{Key: aws.String("Team"), Value: aws.String("web")},
+	})
+	if aws.ToString(tags[0].Value) != mirageecs.RedactedValue || aws.ToString(tags[1].Value) != "web" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+}
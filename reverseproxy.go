@@ -12,6 +12,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	//	"github.com/acidlemon/go-dumper"
@@ -62,8 +63,14 @@ func (r *ReverseProxy) ServeHTTPWithPort(w http.ResponseWriter, req *http.Reques
 	subdomain := strings.ToLower(strings.Split(req.Host, ".")[0])
 
 	if handler := r.FindHandler(subdomain, port); handler != nil {
+		if req.TLS != nil && req.Header.Get("X-Forwarded-Proto") == "" {
+			req.Header.Set("X-Forwarded-Proto", "https")
+		}
 		slog.Debug(f("proxy handler found for subdomain %s", subdomain))
 		handler.ServeHTTP(w, req)
+	} else if r.isStarting(subdomain, port) {
+		slog.Debug(f("proxy handler for subdomain %s is not ready", subdomain))
+		serveStartingPage(w, subdomain)
 	} else {
 		slog.Debug(f("proxy handler not found for subdomain %s", subdomain))
 		http.NotFound(w, req)
@@ -94,21 +101,14 @@ func (r *ReverseProxy) Subdomains() []string {
 }
 
 func (r *ReverseProxy) FindHandler(subdomain string, port int) http.Handler {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+	// dead handlers are removed while finding
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	slog.Debug(f("FindHandler for %s:%d", subdomain, port))
 
-	proxyHandlers, ok := r.domainMap[subdomain]
-	if !ok {
-		for _, name := range r.domains {
-			if m, _ := path.Match(name, subdomain); m {
-				proxyHandlers = r.domainMap[name]
-				break
-			}
-		}
-		if proxyHandlers == nil {
-			return nil
-		}
+	proxyHandlers := r.findHandlers(subdomain)
+	if proxyHandlers == nil {
+		return nil
 	}
 
 	handler, ok := proxyHandlers.Handler(port)
@@ -118,16 +118,65 @@ func (r *ReverseProxy) FindHandler(subdomain string, port int) http.Handler {
 	return handler
 }
 
+// findHandlers finds proxyHandlers by the subdomain. r.mu must be locked.
+func (r *ReverseProxy) findHandlers(subdomain string) proxyHandlers {
+	if ph, ok := r.domainMap[subdomain]; ok {
+		return ph
+	}
+	for _, name := range r.domains {
+		if m, _ := path.Match(name, subdomain); m {
+			return r.domainMap[name]
+		}
+	}
+	return nil
+}
+
+// isStarting reports whether the subdomain has handlers for the port that have not passed health checks.
+func (r *ReverseProxy) isStarting(subdomain string, port int) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, h := range r.findHandlers(subdomain)[port] {
+		if !h.ready.Load() {
+			return true
+		}
+	}
+	return false
+}
+
+// HealthStatus returns the health status of the backend of the subdomain at ipaddress.
+func (r *ReverseProxy) HealthStatus(subdomain string, ipaddress string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	status := HealthStatusNoRoute
+	for _, handlers := range r.domainMap[subdomain] {
+		for addr, h := range handlers {
+			if host, _, _ := net.SplitHostPort(addr); host != ipaddress {
+				continue
+			}
+			if !h.ready.Load() {
+				return HealthStatusStarting
+			}
+			status = HealthStatusHealthy
+		}
+	}
+	return status
+}
+
 type proxyHandler struct {
-	handler http.Handler
-	timer   *time.Timer
+	handler  http.Handler
+	timer    *time.Timer
+	ready    atomic.Bool
+	removed  atomic.Bool
+	checking atomic.Bool
 }
 
-func newProxyHandler(h http.Handler) *proxyHandler {
-	return &proxyHandler{
+func newProxyHandler(h http.Handler, ready bool) *proxyHandler {
+	ph := &proxyHandler{
 		handler: h,
 		timer:   time.NewTimer(proxyHandlerLifetime),
 	}
+	ph.ready.Store(ready)
+	return ph
 }
 
 func (h *proxyHandler) alive() bool {
@@ -151,12 +200,16 @@ func (ph proxyHandlers) Handler(port int) (http.Handler, bool) {
 		return nil, false
 	}
 	for ipaddress, handler := range ph[port] {
-		if handler.alive() {
-			// return first (randomized by Go's map)
-			return handler.handler, true
-		} else {
+		if !handler.alive() {
+			// handlers expired before passing the health check are also removed
 			slog.Info(f("proxy handler to %s is dead", ipaddress))
+			handler.removed.Store(true)
 			delete(ph[port], ipaddress)
+			continue
+		}
+		if handler.ready.Load() {
+			// return first (randomized by Go's map)
+			return handler.handler, true
 		}
 	}
 	return nil, false
@@ -174,17 +227,29 @@ func (ph proxyHandlers) exists(port int, addr string) bool {
 		return true
 	} else {
 		slog.Info(f("proxy handler to %s is dead", addr))
+		h.removed.Store(true)
 		delete(ph[port], addr)
 		return false
 	}
 }
 
-func (ph proxyHandlers) add(port int, ipaddress string, h http.Handler) {
+func (ph proxyHandlers) add(port int, ipaddress string, h http.Handler, ready bool) *proxyHandler {
 	if ph[port] == nil {
 		ph[port] = make(map[string]*proxyHandler)
 	}
 	slog.Info(f("new proxy handler to %s", ipaddress))
-	ph[port][ipaddress] = newProxyHandler(h)
+	handler := newProxyHandler(h, ready)
+	ph[port][ipaddress] = handler
+	return handler
+}
+
+// remove marks all handlers as removed.
+func (ph proxyHandlers) remove() {
+	for _, handlers := range ph {
+		for _, h := range handlers {
+			h.removed.Store(true)
+		}
+	}
 }
 
 func (r *ReverseProxy) AddSubdomain(subdomain string, ipaddress string, targetPort int) {
@@ -209,21 +274,25 @@ func (r *ReverseProxy) AddSubdomain(subdomain string, ipaddress string, targetPo
 
 	// create reverse proxy
 	proxy := false
-	for _, v := range r.cfg.Listen.HTTP {
+	for _, v := range r.cfg.Listen.PortMaps() {
 		if (v.TargetPort != targetPort) && !r.cfg.localMode {
 			continue
 			// local mode allows any port
 		}
-		if ph.exists(v.ListenPort, addr) {
-			proxy = true
-			continue
-		}
 		destUrlString := "http://" + addr
 		destUrl, err := url.Parse(destUrlString)
 		if err != nil {
 			slog.Error(f("invalid destination url: %s %s", destUrlString, err))
 			continue
 		}
+		if ph.exists(v.ListenPort, addr) {
+			if v.HealthCheckPath != "" {
+				// restart the health check given up
+				ph[v.ListenPort][addr].startHealthCheck(subdomain, destUrl, v.HealthCheckPath)
+			}
+			proxy = true
+			continue
+		}
 		handler := rproxy.NewSingleHostReverseProxy(destUrl)
 		tp := &Transport{
 			Transport: http.DefaultTransport,
@@ -235,12 +304,23 @@ func (r *ReverseProxy) AddSubdomain(subdomain string, ipaddress string, targetPo
 			tp.AuthCookieValidateFunc = r.cfg.Auth.ValidateAuthCookie
 		}
 		handler.Transport = tp
-		ph.add(v.ListenPort, addr, handler)
+		h := ph.add(v.ListenPort, addr, handler, v.HealthCheckPath == "")
+		if v.HealthCheckPath != "" {
+			h.startHealthCheck(subdomain, destUrl, v.HealthCheckPath)
+		}
 		proxy = true
 		slog.Info(f("add subdomain: %s:%d -> %s", subdomain, v.ListenPort, addr))
+		r.cfg.EventBus().Publish(&Event{
+			Type:      EventRouteAdded,
+			Subdomain: subdomain,
+			Detail: map[string]string{
+				"listen": strconv.Itoa(v.ListenPort),
+				"target": addr,
+			},
+		})
 	}
 	if !proxy {
-		slog.Warn(f("proxy of subdomain %s(target port %d) is not created. define target port in listen.http[]", subdomain, targetPort))
+		slog.Warn(f("proxy of subdomain %s(target port %d) is not created. define target port in listen.http[] or listen.https[]", subdomain, targetPort))
 		return
 	}
 
@@ -257,6 +337,10 @@ func (r *ReverseProxy) RemoveSubdomain(subdomain string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	slog.Info(f("removing subdomain: %s", subdomain))
+	if ph, ok := r.domainMap[subdomain]; ok {
+		ph.remove()
+		r.cfg.EventBus().Publish(&Event{Type: EventRouteRemoved, Subdomain: subdomain})
+	}
 	delete(r.domainMap, subdomain)
 	delete(r.accessCounters, subdomain)
 	for i, name := range r.domains {
@@ -2,7 +2,14 @@ package mirageecs_test
 
 import (
 	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 
@@ -94,3 +101,159 @@ func TestReverseProxy(t *testing.T) {
 		}
 	}
 }
+
+func TestReverseProxyHTTPS(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		Domain: "example.net",
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	cfg.Listen.HTTP = []mirageecs.PortMap{
+		{ListenPort: 80, TargetPort: 80},
+	}
+	cfg.Listen.HTTPS = []mirageecs.PortMap{
+		{ListenPort: 443, TargetPort: 80},
+		{ListenPort: 8443, TargetPort: 8080},
+	}
+	rp := mirageecs.NewReverseProxy(cfg)
+	rp.AddSubdomain("aaa", "192.168.1.1", 80)
+	for _, port := range []int{80, 443} {
+		if h := rp.FindHandler("aaa", port); h == nil {
+			t.Errorf("handler not found for aaa:%d", port)
+		}
+	}
+	if h := rp.FindHandler("aaa", 8443); h != nil {
+		t.Errorf("handler for aaa:8443 must not exist")
+	}
+}
+
+func TestReverseProxyHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		Domain: "example.net",
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	var healthy atomic.Bool
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" && !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	cfg.Listen.HTTP = []mirageecs.PortMap{
+		{ListenPort: 80, TargetPort: port, HealthCheckPath: "/healthz"},
+	}
+	rp := mirageecs.NewReverseProxy(cfg)
+	rp.AddSubdomain("aaa", "127.0.0.1", port)
+
+	if s := rp.HealthStatus("aaa", "127.0.0.1"); s != "starting" {
+		t.Errorf("unexpected health status %s", s)
+	}
+	if h := rp.FindHandler("aaa", 80); h != nil {
+		t.Error("handler must not be found before health check passed")
+	}
+	w := httptest.NewRecorder()
+	rp.ServeHTTPWithPort(w, httptest.NewRequest(http.MethodGet, "http://aaa.example.net/", nil), 80)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("starting page should be 503: %d", w.Code)
+	}
+
+	healthy.Store(true)
+	for i := 0; i < 50 && rp.FindHandler("aaa", 80) == nil; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if s := rp.HealthStatus("aaa", "127.0.0.1"); s != "healthy" {
+		t.Errorf("unexpected health status %s", s)
+	}
+	w = httptest.NewRecorder()
+	rp.ServeHTTPWithPort(w, httptest.NewRequest(http.MethodGet, "http://aaa.example.net/", nil), 80)
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	if s := rp.HealthStatus("bbb", "127.0.0.1"); s != "no_route" {
+		t.Errorf("unexpected health status %s", s)
+	}
+}
+
+func TestReverseProxyHealthCheckRestart(t *testing.T) {
+	defer mirageecs.SetHealthCheckMaxDuration(100 * time.Millisecond)()
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		Domain: "example.net",
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	var healthy atomic.Bool
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	cfg.Listen.HTTP = []mirageecs.PortMap{
+		{ListenPort: 80, TargetPort: port, HealthCheckPath: "/healthz"},
+	}
+	rp := mirageecs.NewReverseProxy(cfg)
+	rp.AddSubdomain("aaa", "127.0.0.1", port)
+	// the health check gives up
+	time.Sleep(500 * time.Millisecond)
+	healthy.Store(true)
+	time.Sleep(500 * time.Millisecond)
+	if s := rp.HealthStatus("aaa", "127.0.0.1"); s != "starting" {
+		t.Fatalf("unexpected health status %s", s)
+	}
+
+	// the next sync restarts the health check
+	rp.AddSubdomain("aaa", "127.0.0.1", port)
+	for i := 0; i < 50 && rp.FindHandler("aaa", 80) == nil; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if s := rp.HealthStatus("aaa", "127.0.0.1"); s != "healthy" {
+		t.Errorf("unexpected health status %s", s)
+	}
+}
+
+func TestReverseProxyPruneUnready(t *testing.T) {
+	defer mirageecs.SetProxyHandlerLifetime(100 * time.Millisecond)()
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		Domain: "example.net",
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	cfg.Listen.HTTP = []mirageecs.PortMap{
+		{ListenPort: 80, TargetPort: port, HealthCheckPath: "/healthz"},
+	}
+	rp := mirageecs.NewReverseProxy(cfg)
+	rp.AddSubdomain("aaa", "127.0.0.1", port)
+	time.Sleep(200 * time.Millisecond)
+	if h := rp.FindHandler("aaa", 80); h != nil {
+		t.Error("handler must not be found before health check passed")
+	}
+	if s := rp.HealthStatus("aaa", "127.0.0.1"); s != "no_route" {
+		t.Errorf("expired handler should be removed even if it is not ready: %s", s)
+	}
+}
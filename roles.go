@@ -0,0 +1,216 @@
+package mirageecs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/samber/lo"
+)
+
+const (
+	// RoleViewer can view tasks, logs and history.
+	RoleViewer = "viewer"
+	// RoleLauncher can launch tasks, and terminate and extend subdomains launched by itself.
+	RoleLauncher = "launcher"
+	// RoleAdmin can do everything.
+	RoleAdmin = "admin"
+
+	ownerHistoryLimit = 100
+)
+
+var (
+	roleLevels = map[string]int{RoleViewer: 1, RoleLauncher: 2, RoleAdmin: 3}
+
+	errNotOwner = errors.New("subdomain is owned by another identity")
+)
+
+// RolesCfg maps identities to roles.
+type RolesCfg struct {
+	// Default is the role of identities not matched by any rule. Default is viewer.
+	Default string      `yaml:"default"`
+	Rules   []*RoleRule `yaml:"rules"`
+}
+
+// RoleRule assigns the role to identities matched by the method and the matchers.
+type RoleRule struct {
+	Role string `yaml:"role"`
+	// Method is an auth method (token, basic, amzn_oidc or oidc). Empty matches any methods.
+	Method string `yaml:"method"`
+	// Matchers match names of identities (the claim value for amzn_oidc and oidc). Empty matches any names.
+	Matchers []*ClaimMatcher `yaml:"matchers"`
+}
+
+func validRole(role string) bool {
+	_, ok := roleLevels[role]
+	return ok
+}
+
+func (c *RolesCfg) validate() error {
+	if c.Default == "" {
+		c.Default = RoleViewer
+	}
+	if !validRole(c.Default) {
+		return fmt.Errorf("invalid default role %s", c.Default)
+	}
+	for i, r := range c.Rules {
+		if !validRole(r.Role) {
+			return fmt.Errorf("rules[%d] has invalid role %s", i, r.Role)
+		}
+		switch r.Method {
+		case "", "token", "basic", "amzn_oidc", "oidc":
+		default:
+			return fmt.Errorf("rules[%d] has invalid method %s", i, r.Method)
+		}
+	}
+	return nil
+}
+
+func (r *RoleRule) match(id *Identity) bool {
+	if r.Method != "" && r.Method != id.Method {
+		return false
+	}
+	if len(r.Matchers) == 0 {
+		return true
+	}
+	return lo.SomeBy(r.Matchers, func(m *ClaimMatcher) bool { return m.Match(id.Name) })
+}
+
+// roleOf returns the role of the identity by the first matched rule.
+func (c *RolesCfg) roleOf(id *Identity) string {
+	if c == nil {
+		// roles are not configured. all identities are admin
+		return RoleAdmin
+	}
+	if r, ok := lo.Find(c.Rules, func(r *RoleRule) bool { return r.match(id) }); ok {
+		return r.Role
+	}
+	return c.Default
+}
+
+// HasRole reports whether the identity has the role or a higher one.
+func (i *Identity) HasRole(role string) bool {
+	if i == nil {
+		return false
+	}
+	return roleLevels[i.Role] >= roleLevels[role]
+}
+
+// require returns a middleware that allows only identities having the role and the scope.
+func (api *WebApi) require(role, scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			id := identityOf(c)
+			if !id.HasRole(role) {
+				slog.Warn(f("%s (role=%s) is not allowed to %s %s", id, id.Role, c.Request().Method, c.Path()))
+				return echo.ErrForbidden
+			}
+			if !id.HasScope(scope) {
+				slog.Warn(f("%s (scopes=%v) is not allowed to %s %s", id, id.Scopes, c.Request().Method, c.Path()))
+				return echo.ErrForbidden
+			}
+			return next(c)
+		}
+	}
+}
+
+// subdomainOwner returns the identity who launched the running or sleeping subdomain by the Owner tag.
+// For tasks launched without the Owner tag, the owner is found from the history. Empty means unknown.
+func (api *WebApi) subdomainOwner(ctx context.Context, subdomain string) (string, error) {
+	infos, err := api.runner.List(ctx, statusRunning)
+	if err != nil {
+		return "", err
+	}
+	infos = lo.Filter(infos, func(info *Information, _ int) bool { return info.SubDomain == subdomain })
+	if len(infos) == 0 {
+		if sl, ok := lo.Find(api.sleeping.list(), func(sl *SleepingSubdomain) bool { return sl.Subdomain == subdomain }); ok {
+			return sl.Owner, nil
+		}
+		return "", nil
+	}
+	if info, ok := lo.Find(infos, func(info *Information) bool { return info.Owner != "" }); ok {
+		return info.Owner, nil
+	}
+
+	records, err := api.cfg.HistoryStore().List(ctx, subdomain, ownerHistoryLimit)
+	if err != nil {
+		return "", err
+	}
+	for _, r := range records {
+		if r.Result != HistoryResultSucceeded {
+			continue
+		}
+		switch r.Action {
+		case HistoryActionLaunch:
+			return r.Actor, nil
+		case HistoryActionTerminate, HistoryActionPurge, HistoryActionExpire:
+			return "", nil
+		}
+	}
+	return "", nil
+}
+
+// authorizeOverwrite checks the identity can launch tasks on the subdomain.
+// Replacing a subdomain owned by another identity requires force and the admin role.
+func (api *WebApi) authorizeOverwrite(ctx context.Context, id *Identity, subdomain string, force bool) (int, error) {
+	owner, err := api.subdomainOwner(ctx, subdomain)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to find the owner of %s: %w", subdomain, err)
+	}
+	if owner == "" || owner == id.String() {
+		return http.StatusOK, nil
+	}
+	if !force {
+		return http.StatusConflict, fmt.Errorf("%w: %s is owned by %s. specify force=true to overwrite", errNotOwner, subdomain, owner)
+	}
+	if !id.HasRole(RoleAdmin) {
+		slog.Warn(f("%s is not allowed to overwrite %s owned by %s", id, subdomain, owner))
+		return http.StatusForbidden, fmt.Errorf("%w: %s", errNotOwner, subdomain)
+	}
+	slog.Info(f("%s overwrites %s owned by %s", id, subdomain, owner))
+	return http.StatusOK, nil
+}
+
+// authorizeSubdomain checks the identity can modify the subdomain.
+// Admins can modify any subdomains, and others can modify subdomains launched by themselves.
+func (api *WebApi) authorizeSubdomain(ctx context.Context, id *Identity, subdomain string) (int, error) {
+	if id.HasRole(RoleAdmin) {
+		return http.StatusOK, nil
+	}
+	owner, err := api.subdomainOwner(ctx, subdomain)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to find the owner of %s: %w", subdomain, err)
+	}
+	if owner != "" && owner != id.String() {
+		slog.Warn(f("%s is not allowed to modify %s owned by %s", id, subdomain, owner))
+		return http.StatusForbidden, fmt.Errorf("%w: %s", errNotOwner, subdomain)
+	}
+	return http.StatusOK, nil
+}
+
+// hasOtherTasks reports whether the subdomain has running tasks other than the task.
+func (api *WebApi) hasOtherTasks(ctx context.Context, subdomain, id string) (bool, error) {
+	infos, err := api.runner.List(ctx, statusRunning)
+	if err != nil {
+		return false, err
+	}
+	return lo.ContainsBy(infos, func(info *Information) bool {
+		return info.SubDomain == subdomain && info.ID != id && info.ShortID != id
+	}), nil
+}
+
+// authorizeTask checks the identity can modify the task described from ECS.
+// The Owner tag of the task is preferred to the owner of the subdomain, which may not be cached yet.
+func (api *WebApi) authorizeTask(ctx context.Context, id *Identity, info *Information) (int, error) {
+	if info.Owner == "" {
+		return api.authorizeSubdomain(ctx, id, info.SubDomain)
+	}
+	if id.HasRole(RoleAdmin) || info.Owner == id.String() {
+		return http.StatusOK, nil
+	}
+	slog.Warn(f("%s is not allowed to modify task %s of %s owned by %s", id, info.ShortID, info.SubDomain, info.Owner))
+	return http.StatusForbidden, fmt.Errorf("%w: %s", errNotOwner, info.SubDomain)
+}
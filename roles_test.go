@@ -0,0 +1,127 @@
+package mirageecs_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+const rolesConfig = `auth:
+  token:
+    header: x-mirage-token
+    token: launcher-token
+  basic:
+    username: alice
+    password: secret
+  roles:
+    default: viewer
+    rules:
+      - role: admin
+        method: basic
+        matchers:
+          - exact: alice
+      - role: launcher
+        method: token
+`
+
+func TestRolesConfig(t *testing.T) {
+	cfg, err := newTestConfig(t, rolesConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles := cfg.Auth.Roles
+	tests := []struct {
+		id   *mirageecs.Identity
+		role string
+	}{
+		{&mirageecs.Identity{Method: "basic", Name: "alice"}, mirageecs.RoleAdmin},
+		{&mirageecs.Identity{Method: "basic", Name: "bob"}, mirageecs.RoleViewer},
+		{&mirageecs.Identity{Method: "token", Name: "x-mirage-token"}, mirageecs.RoleLauncher},
+		{&mirageecs.Identity{Method: "amzn_oidc", Name: "alice"}, mirageecs.RoleViewer},
+	}
+	for _, tt := range tests {
+		if role := roles.RoleOf(tt.id); role != tt.role {
+			t.Errorf("role of %s should be %s, got %s", tt.id, tt.role, role)
+		}
+	}
+	var nilRoles *mirageecs.RolesCfg
+	if role := nilRoles.RoleOf(&mirageecs.Identity{Method: "basic", Name: "bob"}); role != mirageecs.RoleAdmin {
+		t.Errorf("all identities should be admin without roles, got %s", role)
+	}
+	if _, err := newTestConfig(t, "auth:\n  roles:\n    rules:\n      - role: root\n"); err == nil {
+		t.Error("invalid role should be error")
+	}
+}
+
+func TestRolesAuthorization(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := newTestConfig(t, rolesConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	post := func(path, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("x-mirage-token", "launcher-token")
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if code := post("/api/launch", `{"subdomain":"mine","taskdef":["dummy"],"branch":"main"}`); code != http.StatusOK {
+		t.Errorf("launcher can launch: %d", code)
+	}
+	if code := post("/api/purge", `{"duration":"86400"}`); code != http.StatusForbidden {
+		t.Errorf("launcher cannot purge: %d", code)
+	}
+	if code := post("/api/presets", `{"name":"foo","taskdefs":["dummy"]}`); code != http.StatusForbidden {
+		t.Errorf("launcher cannot put presets: %d", code)
+	}
+
+	// a subdomain launched by another identity
+	others, err := m.Runner().Launch(ctx, "others", mirageecs.TaskParameter{"branch": "main"}, mirageecs.LaunchOption{Owner: "basic:alice"}, "dummy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := post("/api/launch", `{"subdomain":"others","taskdef":["dummy"],"branch":"main","force":true}`); code != http.StatusForbidden {
+		t.Errorf("launcher cannot overwrite others even with force: %d", code)
+	}
+	if code := post("/api/extend", `{"subdomain":"others","ttl":"1h"}`); code != http.StatusForbidden {
+		t.Errorf("launcher cannot extend others: %d", code)
+	}
+	if code := post("/api/terminate", `{"subdomain":"others"}`); code != http.StatusForbidden {
+		t.Errorf("launcher cannot terminate others: %d", code)
+	}
+	if code := post("/api/terminate", `{"id":"`+others[0].ID+`"}`); code != http.StatusForbidden {
+		t.Errorf("launcher cannot terminate tasks of others: %d", code)
+	}
+	if code := post("/api/terminate", `{"id":"arn:aws:ecs:ap-northeast-1:123456789012:task/mirage/unknown"}`); code != http.StatusNotFound {
+		t.Errorf("tasks not managed by Mirage cannot be terminated: %d", code)
+	}
+	if code := post("/api/terminate", `{"subdomain":"mine"}`); code != http.StatusOK {
+		t.Errorf("launcher can terminate own subdomain: %d", code)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/list", nil)
+	req.SetBasicAuth("alice", "secret")
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("admin can view the list: %d", res.StatusCode)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
 	ttlcache "github.com/ReneKroon/ttlcache/v2"
@@ -18,6 +19,7 @@ type Route53 struct {
 	hostedZoneID *string
 	zoneName     string
 	cache        *ttlcache.Cache
+	events       *EventBus
 }
 
 type route53Change struct {
@@ -39,11 +41,16 @@ func (c *route53Change) action() string {
 }
 
 func NewRoute53(ctx context.Context, cfg *Config) *Route53 {
+	return newRoute53(ctx, cfg, cfg.Link.HostedZoneID)
+}
+
+func newRoute53(ctx context.Context, cfg *Config, hostedZoneID string) *Route53 {
 	svc := route53.NewFromConfig(*cfg.awscfg)
 	r := &Route53{
-		svc: svc,
+		svc:    svc,
+		events: cfg.EventBus(),
 	}
-	if id := cfg.Link.HostedZoneID; id != "" {
+	if id := hostedZoneID; id != "" {
 		out, err := svc.GetHostedZone(ctx, &route53.GetHostedZoneInput{
 			Id: aws.String(id),
 		})
@@ -170,5 +177,49 @@ DELETES:
 		return err
 	}
 	slog.Info(f("route53 ChangeResourceRecordSets complete with %d changes", len(changes)))
+	for _, c := range changes {
+		values := make([]string, 0, len(c.ResourceRecordSet.ResourceRecords))
+		for _, rr := range c.ResourceRecordSet.ResourceRecords {
+			values = append(values, aws.ToString(rr.Value))
+		}
+		r.events.Publish(&Event{
+			Type: EventRoute53Changed,
+			Detail: map[string]string{
+				"action": string(c.Action),
+				"name":   aws.ToString(c.ResourceRecordSet.Name),
+				"values": strings.Join(values, ","),
+			},
+		})
+	}
 	return nil
 }
+
+// ChangeTXTRecord changes a TXT record immediately and waits until the change is propagated.
+// name must be a FQDN in the hosted zone. This is used for ACME DNS-01 challenges.
+func (r *Route53) ChangeTXTRecord(ctx context.Context, action types.ChangeAction, name, value string) error {
+	if r.hostedZoneID == nil {
+		return fmt.Errorf("hosted zone is not configured")
+	}
+	out, err := r.svc.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+		ChangeBatch: &types.ChangeBatch{
+			Changes: []types.Change{
+				{
+					Action: action,
+					ResourceRecordSet: &types.ResourceRecordSet{
+						Name:            aws.String(name),
+						ResourceRecords: []types.ResourceRecord{{Value: aws.String(`"` + value + `"`)}},
+						TTL:             aws.Int64(60),
+						Type:            types.RRTypeTxt,
+					},
+				},
+			},
+		},
+		HostedZoneId: r.hostedZoneID,
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info(f("route53 change: %s TXT %s, waiting for INSYNC", action, name))
+	waiter := route53.NewResourceRecordSetsChangedWaiter(r.svc)
+	return waiter.Wait(ctx, &route53.GetChangeInput{Id: out.ChangeInfo.Id}, 5*time.Minute)
+}
@@ -0,0 +1,351 @@
+package mirageecs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/samber/lo"
+)
+
+const (
+	DefaultScaleToZeroInterval = 5 * time.Minute
+
+	// wakingTimeout is a duration to wait for a woken task being routed.
+	// After the timeout, the next request launches the task again.
+	wakingTimeout = 10 * time.Minute
+)
+
+// ScaleToZeroCfg configures stopping idle tasks and waking them up on request.
+type ScaleToZeroCfg struct {
+	IdleDuration time.Duration `yaml:"idle_duration"`
+	Interval     time.Duration `yaml:"interval"`
+	WakeTimeout  time.Duration `yaml:"wake_timeout"`
+	Excludes     []string      `yaml:"excludes"`
+	ExcludeTags  []string      `yaml:"exclude_tags"`
+
+	option *purgeOption
+}
+
+func (c *ScaleToZeroCfg) validate() error {
+	if c.Interval == 0 {
+		c.Interval = DefaultScaleToZeroInterval
+	}
+	if c.Interval < time.Minute {
+		return fmt.Errorf("scale_to_zero.interval %s is too short (at least 1m)", c.Interval)
+	}
+	opt, err := newPurgeOption(c.IdleDuration, c.Excludes, c.ExcludeTags, false)
+	if err != nil {
+		return fmt.Errorf("invalid idle_duration: %w", err)
+	}
+	c.option = opt
+	return nil
+}
+
+// SleepingSubdomain is a launch spec of the subdomain stopped by scale to zero.
+type SleepingSubdomain struct {
+	Subdomain string        `json:"subdomain"`
+	Parameter TaskParameter `json:"parameter"`
+	Taskdefs  []string      `json:"taskdefs"`
+	Owner     string        `json:"owner,omitempty"`
+	Option    LaunchOption  `json:"-"`
+	SleptAt   time.Time     `json:"slept_at"`
+
+	wakingAt time.Time
+}
+
+func (s *SleepingSubdomain) waking(now time.Time) bool {
+	return !s.wakingAt.IsZero() && now.Sub(s.wakingAt) < wakingTimeout
+}
+
+// sleepingRecord is a persisted form of SleepingSubdomain including the launch option.
+type sleepingRecord struct {
+	*SleepingSubdomain
+	Option LaunchOption `json:"option"`
+}
+
+func marshalSleeping(sl *SleepingSubdomain) ([]byte, error) {
+	return json.Marshal(sleepingRecord{SleepingSubdomain: sl, Option: sl.Option})
+}
+
+func unmarshalSleeping(b []byte) (*SleepingSubdomain, error) {
+	var r sleepingRecord
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	if r.SleepingSubdomain == nil || r.Subdomain == "" {
+		return nil, fmt.Errorf("subdomain is empty")
+	}
+	r.SleepingSubdomain.Option = r.Option
+	return r.SleepingSubdomain, nil
+}
+
+// SleepingStore persists launch specs of sleeping subdomains over restarts.
+// The bolt and dynamodb history stores implement it.
+type SleepingStore interface {
+	PutSleeping(ctx context.Context, sl *SleepingSubdomain) error
+	DeleteSleeping(ctx context.Context, subdomain string) error
+	ListSleeping(ctx context.Context) ([]*SleepingSubdomain, error)
+}
+
+func (c *Config) sleepingStore() SleepingStore {
+	if s, ok := c.HistoryStore().(SleepingStore); ok {
+		return s
+	}
+	return nil
+}
+
+type sleepers struct {
+	mu    sync.Mutex
+	m     map[string]*SleepingSubdomain
+	store SleepingStore // nil means in memory only
+}
+
+func newSleepers(store SleepingStore) *sleepers {
+	return &sleepers{m: make(map[string]*SleepingSubdomain), store: store}
+}
+
+// restore loads the sleeping subdomains from the store.
+func (s *sleepers) restore(ctx context.Context) error {
+	if s.store == nil {
+		return nil
+	}
+	sls, err := s.store.ListSleeping(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sl := range sls {
+		s.m[sl.Subdomain] = sl
+	}
+	return nil
+}
+
+func (s *sleepers) put(ctx context.Context, sl *SleepingSubdomain) {
+	s.mu.Lock()
+	s.m[sl.Subdomain] = sl
+	s.mu.Unlock()
+	if s.store != nil {
+		if err := s.store.PutSleeping(ctx, sl); err != nil {
+			slog.Warn(f("failed to store sleeping subdomain %s: %s", sl.Subdomain, err))
+		}
+	}
+}
+
+func (s *sleepers) delete(ctx context.Context, subdomain string) {
+	s.mu.Lock()
+	_, ok := s.m[subdomain]
+	delete(s.m, subdomain)
+	s.mu.Unlock()
+	if ok && s.store != nil {
+		if err := s.store.DeleteSleeping(ctx, subdomain); err != nil {
+			slog.Warn(f("failed to delete sleeping subdomain %s: %s", subdomain, err))
+		}
+	}
+}
+
+func (s *sleepers) list() []*SleepingSubdomain {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return lo.Values(s.m)
+}
+
+// find finds a sleeping subdomain that matches to the name.
+func (s *sleepers) find(name string) (*SleepingSubdomain, bool) {
+	if sl, ok := s.m[name]; ok {
+		return sl, true
+	}
+	for subdomain, sl := range s.m {
+		if m, _ := path.Match(subdomain, name); m {
+			return sl, true
+		}
+	}
+	return nil, false
+}
+
+// startWaking marks the subdomain as waking. It returns the sleeping subdomain and
+// whether the caller should launch it.
+func (s *sleepers) startWaking(name string, now time.Time) (*SleepingSubdomain, bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sl, ok := s.find(name)
+	if !ok {
+		return nil, false, false
+	}
+	if sl.waking(now) {
+		return sl, true, false
+	}
+	sl.wakingAt = now
+	return sl, true, true
+}
+
+func (s *sleepers) resetWaking(subdomain string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sl, ok := s.m[subdomain]; ok {
+		sl.wakingAt = time.Time{}
+	}
+}
+
+// sleepingSubdomainOf builds a launch spec from the running tasks of the subdomain.
+func (api *WebApi) sleepingSubdomainOf(subdomain string, infos []*Information) *SleepingSubdomain {
+	sl := &SleepingSubdomain{
+		Subdomain: subdomain,
+		Parameter: TaskParameter{},
+		SleptAt:   time.Now(),
+	}
+	for _, info := range infos {
+		sl.Taskdefs = append(sl.Taskdefs, info.TaskDef)
+		sl.Option.Target = info.Target
+		if info.overrides != nil {
+			sl.Option.Overrides = info.overrides
+		}
+		for _, t := range info.Tags {
+			// additional tags by the launch request (e.g. preset tags)
+			k := aws.ToString(t.Key)
+			if api.cfg.isReservedTag(k) {
+				continue
+			}
+			if sl.Option.Tags == nil {
+				sl.Option.Tags = map[string]string{}
+			}
+			sl.Option.Tags[k] = aws.ToString(t.Value)
+		}
+		if info.ExpireAt != nil {
+			sl.Option.ExpireAt = *info.ExpireAt
+		}
+		if info.Owner != "" {
+			sl.Owner = info.Owner
+			sl.Option.Owner = info.Owner
+		}
+		for _, p := range api.cfg.Parameter {
+			if v := tagValue(info.Tags, p.Name); v != "" {
+				sl.Parameter[p.Name] = v
+			}
+		}
+	}
+	sort.Strings(sl.Taskdefs)
+	return sl
+}
+
+// runScaleToZero stops idle subdomains and remembers their launch specs.
+func (api *WebApi) runScaleToZero(ctx context.Context) error {
+	cfg := api.cfg.ScaleToZero
+	now := time.Now()
+
+	infos, err := api.runner.List(ctx, statusRunning)
+	if err != nil {
+		return err
+	}
+	running := lo.GroupBy(infos, func(info *Information) string {
+		return info.SubDomain
+	})
+
+	for _, sl := range api.sleeping.list() {
+		switch {
+		case running[sl.Subdomain] != nil:
+			// woken up or launched by other ways
+			api.sleeping.delete(ctx, sl.Subdomain)
+		case !sl.Option.ExpireAt.IsZero() && !sl.Option.ExpireAt.After(now):
+			slog.Info(f("sleeping subdomain %s is expired", sl.Subdomain))
+			api.sleeping.delete(ctx, sl.Subdomain)
+			api.cfg.deleteSecrets(ctx, sl.Subdomain)
+		}
+	}
+
+	for subdomain, infos := range running {
+		if !lo.EveryBy(infos, func(info *Information) bool {
+			return info.ShouldBePurged(cfg.option.duration, cfg.option.excludes, cfg.option.excludeTags)
+		}) {
+			continue
+		}
+		sum, err := api.runner.GetAccessCount(ctx, subdomain, cfg.option.duration)
+		if err != nil {
+			slog.Warn(f("access count failed: %s %s", subdomain, err))
+			continue
+		}
+		if sum > 0 {
+			continue
+		}
+		slog.Info(f("subdomain %s is idle for %s. stopping", subdomain, cfg.IdleDuration))
+		sl := api.sleepingSubdomainOf(subdomain, infos)
+		h := newHistoryRecord(HistoryActionSleep, subdomain, SystemIdentity)
+		h.Parameters = api.cfg.Redactor().Parameters(sl.Parameter)
+		h.Taskdefs = sl.Taskdefs
+		err = api.runner.TerminateBySubdomain(ctx, subdomain)
+		api.putHistory(h, err)
+		if err != nil {
+			slog.Warn(f("terminate failed %s %s", subdomain, err))
+			continue
+		}
+		api.sleeping.put(ctx, sl)
+		api.cfg.EventBus().Publish(&Event{Type: EventSlept, Subdomain: subdomain, Actor: h.Actor})
+	}
+	return nil
+}
+
+// wakeUp launches the sleeping subdomain that matches to name.
+// It returns false when the subdomain is not sleeping.
+func (api *WebApi) wakeUp(name string) bool {
+	if api.cfg.ScaleToZero == nil {
+		return false
+	}
+	sl, found, launch := api.sleeping.startWaking(name, time.Now())
+	if !found {
+		return false
+	}
+	if launch {
+		// don't cancel by the client
+		go api.wake(sl)
+	}
+	return true
+}
+
+func (api *WebApi) wake(sl *SleepingSubdomain) {
+	slog.Info(f("waking up subdomain %s", sl.Subdomain))
+	ctx, cancel := context.WithTimeout(context.Background(), APICallTimeout)
+	defer cancel()
+	h := newHistoryRecord(HistoryActionWake, sl.Subdomain, SystemIdentity)
+	h.Parameters = api.cfg.Redactor().Parameters(sl.Parameter)
+	h.Taskdefs = sl.Taskdefs
+	api.cfg.EventBus().Publish(&Event{Type: EventWoken, Subdomain: sl.Subdomain, Actor: h.Actor})
+	results, err := api.runner.Launch(ctx, sl.Subdomain, sl.Parameter, sl.Option, sl.Taskdefs...)
+	h.TaskID = strings.Join(launchedTaskIDs(results), ",")
+	api.putHistory(h, err)
+	if err != nil {
+		slog.Error(f("failed to wake up subdomain %s: %s", sl.Subdomain, err))
+		api.sleeping.resetWaking(sl.Subdomain)
+	}
+}
+
+var wakingPage = template.Must(template.New("waking").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>Waking up {{ . }}</title>
+</head>
+<body>
+<h1>Waking up {{ . }}</h1>
+<p>This environment was stopped because it was idle. It is starting now. This page will be reloaded automatically.</p>
+</body>
+</html>
+`))
+
+func serveWakingPage(w http.ResponseWriter, subdomain string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Retry-After", "5")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	wakingPage.Execute(w, subdomain)
+}
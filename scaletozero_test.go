@@ -0,0 +1,203 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/samber/lo"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestScaleToZero(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conf := filepath.Join(t.TempDir(), "config.yml")
+	data := `---
+scale_to_zero:
+  idle_duration: 10m
+`
+	if err := os.WriteFile(conf, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{Path: conf, LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	go m.SyncECSToMirage(ctx)
+
+	runner := m.Runner().(*mirageecs.LocalTaskRunner)
+	for _, subdomain := range []string{"idle", "busy"} {
+		if _, err := runner.Launch(ctx, subdomain, mirageecs.TaskParameter{"branch": subdomain}, mirageecs.LaunchOption{}, "dummy"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runner.UpdateInformations(func(info *mirageecs.Information) {
+		if info.SubDomain == "idle" {
+			info.Created = time.Now().Add(-time.Hour)
+		}
+	})
+	if err := m.WebApi.RunScaleToZero(ctx); err != nil {
+		t.Fatal(err)
+	}
+	infos, _ := runner.List(ctx, "RUNNING")
+	if len(infos) != 1 || infos[0].SubDomain != "busy" {
+		t.Fatalf("only busy should be running %#v", infos)
+	}
+
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+	res, err := ts.Client().Get(ts.URL + "/api/sleeping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r mirageecs.APISleepingResponse
+	json.NewDecoder(res.Body).Decode(&r)
+	res.Body.Close()
+	if len(r.Result) != 1 || r.Result[0].Subdomain != "idle" || r.Result[0].Parameter["branch"] != "idle" {
+		t.Fatalf("unexpected sleeping subdomains %#v", r.Result)
+	}
+
+	request := func() *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "http://idle.localtest.me/", nil)
+		w := httptest.NewRecorder()
+		m.ServeHTTPWithPort(w, req, 80)
+		return w.Result()
+	}
+
+	t.Run("waking page", func(t *testing.T) {
+		res := request()
+		if res.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("status code should be 503: %d", res.StatusCode)
+		}
+		if res.Header.Get("Retry-After") == "" {
+			t.Error("Retry-After header is required")
+		}
+	})
+
+	t.Run("hold the request until woken up", func(t *testing.T) {
+		cfg.ScaleToZero.WakeTimeout = 5 * time.Second
+		res := request()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("status code should be 200: %d", res.StatusCode)
+		}
+		body, _ := io.ReadAll(res.Body)
+		if !strings.Contains(string(body), "subdomain: idle") {
+			t.Errorf("unexpected body %s", body)
+		}
+	})
+
+	if err := m.WebApi.RunScaleToZero(ctx); err != nil {
+		t.Fatal(err)
+	}
+	infos, _ = runner.List(ctx, "RUNNING")
+	if len(infos) != 2 {
+		t.Errorf("idle should be running again %#v", infos)
+	}
+}
+
+func TestScaleToZeroRestore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conf := filepath.Join(t.TempDir(), "config.yml")
+	data := `---
+scale_to_zero:
+  idle_duration: 10m
+history:
+  store: bolt
+  path: ` + filepath.Join(t.TempDir(), "history.db") + `
+ecs:
+  targets:
+    - name: us
+`
+	if err := os.WriteFile(conf, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	newMirage := func() *mirageecs.Mirage {
+		t.Helper()
+		cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{Path: conf, LocalMode: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { cfg.HistoryStore().Close() })
+		return mirageecs.New(ctx, cfg)
+	}
+	sleeping := func(m *mirageecs.Mirage) []*mirageecs.SleepingSubdomain {
+		t.Helper()
+		ts := httptest.NewServer(m.WebApi)
+		defer ts.Close()
+		res, err := ts.Client().Get(ts.URL + "/api/sleeping")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var r mirageecs.APISleepingResponse
+		json.NewDecoder(res.Body).Decode(&r)
+		return r.Result
+	}
+
+	m := newMirage()
+	runner := m.Runner().(*mirageecs.LocalTaskRunner)
+	if _, err := runner.Launch(ctx, "idle", mirageecs.TaskParameter{"branch": "idle"}, mirageecs.LaunchOption{
+		Owner:     "basic:alice",
+		Target:    "us",
+		Tags:      map[string]string{"Team": "web"},
+		Overrides: &mirageecs.TaskOverrides{Containers: map[string]*mirageecs.ContainerOverrides{"app": {Environment: map[string]string{"DEBUG": "1"}}}},
+	}, "dummy"); err != nil {
+		t.Fatal(err)
+	}
+	runner.UpdateInformations(func(info *mirageecs.Information) {
+		info.Created = time.Now().Add(-time.Hour)
+	})
+	if err := m.WebApi.RunScaleToZero(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m.Config.HistoryStore().Close()
+
+	// restarted
+	m = newMirage()
+	if sls := sleeping(m); len(sls) != 1 || sls[0].Subdomain != "idle" || sls[0].Owner != "basic:alice" {
+		t.Fatalf("sleeping subdomains should be restored %#v", sls)
+	}
+	runner = m.Runner().(*mirageecs.LocalTaskRunner)
+	w := httptest.NewRecorder()
+	m.ServeHTTPWithPort(w, httptest.NewRequest(http.MethodGet, "http://idle.localtest.me/", nil), 80)
+	var infos []*mirageecs.Information
+	for i := 0; i < 50 && len(infos) == 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+		infos, _ = runner.List(ctx, "RUNNING")
+	}
+	if len(infos) != 1 || infos[0].Target != "us" || infos[0].Owner != "basic:alice" {
+		t.Fatalf("idle should be woken up in the same target %#v", infos)
+	}
+	if infos[0].Env["DEBUG"] != "1" {
+		t.Errorf("overrides should be restored %#v", infos[0].Env)
+	}
+	if v := infos[0].Tags; len(v) == 0 || !lo.ContainsBy(v, func(t types.Tag) bool { return aws.ToString(t.Key) == "Team" && aws.ToString(t.Value) == "web" }) {
+		t.Errorf("tags should be restored %#v", v)
+	}
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+	res, err := ts.Client().Post(ts.URL+"/api/terminate", "application/json", strings.NewReader(`{"id":"`+infos[0].ID+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", res.StatusCode)
+	}
+	if sls := sleeping(m); len(sls) != 0 {
+		t.Errorf("terminating the task must clear the sleeping subdomain %#v", sls)
+	}
+}
@@ -0,0 +1,331 @@
+package mirageecs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/samber/lo"
+)
+
+const (
+	ParameterTypeSecret = "secret"
+
+	// RedactedValue is shown instead of values of secrets.
+	RedactedValue = "********"
+
+	DefaultSecretsSSMPrefix            = "/mirage-ecs"
+	DefaultSecretsTaskDefinitionSuffix = "-mirage-secrets"
+
+	// tagSecretsDigest is a tag of the registered task definition to find the same one.
+	tagSecretsDigest = "MirageSecretsDigest"
+)
+
+var ssmNameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// SecretsCfg is a config of parameters of the secret type.
+type SecretsCfg struct {
+	// SSMPrefix is a prefix of SSM parameter names to store secrets.
+	SSMPrefix string `yaml:"ssm_prefix"`
+	// KMSKeyID is a KMS key to encrypt SSM parameters. Empty means the AWS managed key.
+	KMSKeyID string `yaml:"kms_key_id"`
+	// TaskDefinitionSuffix is a suffix of the family of task definitions registered to inject secrets.
+	TaskDefinitionSuffix string `yaml:"task_definition_suffix"`
+}
+
+func (c *SecretsCfg) validate() error {
+	if c.SSMPrefix == "" {
+		c.SSMPrefix = DefaultSecretsSSMPrefix
+	}
+	if !strings.HasPrefix(c.SSMPrefix, "/") {
+		return fmt.Errorf("ssm_prefix must start with /: %s", c.SSMPrefix)
+	}
+	c.SSMPrefix = strings.TrimSuffix(c.SSMPrefix, "/")
+	if c.TaskDefinitionSuffix == "" {
+		c.TaskDefinitionSuffix = DefaultSecretsTaskDefinitionSuffix
+	}
+	return nil
+}
+
+// familyOf returns the family of the task definition, without the suffix added to inject secrets.
+func (c *SecretsCfg) familyOf(taskdef string) string {
+	family, _, _ := strings.Cut(taskdef, ":")
+	if c == nil {
+		// Config is not created by NewConfig
+		return strings.TrimSuffix(family, DefaultSecretsTaskDefinitionSuffix)
+	}
+	return strings.TrimSuffix(family, c.TaskDefinitionSuffix)
+}
+
+// IsSecret reports whether the parameter is the secret type.
+func (p *Parameter) IsSecret() bool {
+	return p.Type == ParameterTypeSecret
+}
+
+// secretReference returns valueFrom of the container secret if the value is a reference.
+// A reference is an ARN of SSM Parameter Store or Secrets Manager, or "ssm:" + name of a SSM parameter.
+func secretReference(v string) (string, bool) {
+	switch {
+	case strings.HasPrefix(v, "arn:aws:ssm:"), strings.HasPrefix(v, "arn:aws:secretsmanager:"):
+		return v, true
+	case strings.HasPrefix(v, "ssm:"):
+		return strings.TrimPrefix(v, "ssm:"), true
+	}
+	return "", false
+}
+
+// secretStore stores values of secret parameters.
+type secretStore interface {
+	put(ctx context.Context, name, value string) error
+	delete(ctx context.Context, name string) error
+}
+
+type ssmSecretStore struct {
+	svc      *ssm.Client
+	kmsKeyID string
+}
+
+func (s *ssmSecretStore) put(ctx context.Context, name, value string) error {
+	in := &ssm.PutParameterInput{
+		Name:      aws.String(name),
+		Value:     aws.String(value),
+		Type:      ssmtypes.ParameterTypeSecureString,
+		Overwrite: aws.Bool(true),
+	}
+	if s.kmsKeyID != "" {
+		in.KeyId = aws.String(s.kmsKeyID)
+	}
+	_, err := s.svc.PutParameter(ctx, in)
+	return err
+}
+
+func (s *ssmSecretStore) delete(ctx context.Context, name string) error {
+	_, err := s.svc.DeleteParameter(ctx, &ssm.DeleteParameterInput{Name: aws.String(name)})
+	var nf *ssmtypes.ParameterNotFound
+	if errors.As(err, &nf) {
+		return nil
+	}
+	return err
+}
+
+// localSecretStore is a stand-in of SSM Parameter Store for the local mode.
+type localSecretStore struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func newLocalSecretStore() *localSecretStore {
+	return &localSecretStore{values: map[string]string{}}
+}
+
+func (s *localSecretStore) put(_ context.Context, name, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[name] = value
+	return nil
+}
+
+func (s *localSecretStore) delete(_ context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, name)
+	return nil
+}
+
+func (s *localSecretStore) get(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[name]
+	return v, ok
+}
+
+func (c *Config) newSecretStore() secretStore {
+	if c.localMode {
+		return newLocalSecretStore()
+	}
+	return &ssmSecretStore{
+		svc:      ssm.NewFromConfig(*c.awscfg),
+		kmsKeyID: c.Secrets.KMSKeyID,
+	}
+}
+
+func (c *Config) secretStore() secretStore {
+	if c.secrets == nil {
+		// Config is not created by NewConfig
+		c.secrets = newLocalSecretStore()
+	}
+	return c.secrets
+}
+
+// secretParameterName returns a name of the SSM parameter to store the secret of the subdomain.
+func (c *Config) secretParameterName(subdomain, name string) string {
+	prefix := DefaultSecretsSSMPrefix
+	if c.Secrets != nil {
+		prefix = c.Secrets.SSMPrefix
+	}
+	return fmt.Sprintf("%s/%s/%s", prefix, ssmNameInvalidChars.ReplaceAllString(subdomain, "_"), name)
+}
+
+// storeSecrets stores values of secret parameters and replaces them with the references.
+// References specified by the request are kept as is.
+func (c *Config) storeSecrets(ctx context.Context, subdomain string, p TaskParameter) error {
+	for _, v := range c.Parameter {
+		if !v.IsSecret() || p[v.Name] == "" {
+			continue
+		}
+		if ref, ok := secretReference(p[v.Name]); ok {
+			p[v.Name] = ref
+			continue
+		}
+		name := c.secretParameterName(subdomain, v.Name)
+		if err := c.secretStore().put(ctx, name, p[v.Name]); err != nil {
+			return fmt.Errorf("failed to store secret parameter %s: %w", v.Name, err)
+		}
+		slog.Info(f("stored secret parameter %s to %s", v.Name, name))
+		p[v.Name] = name
+	}
+	return nil
+}
+
+// deleteSecrets deletes the secret parameters of the subdomain stored by storeSecrets.
+// Failures are logged and don't fail the caller, because the subdomain is terminated already.
+func (c *Config) deleteSecrets(ctx context.Context, subdomain string) {
+	for _, v := range c.Parameter {
+		if !v.IsSecret() {
+			continue
+		}
+		name := c.secretParameterName(subdomain, v.Name)
+		if err := c.secretStore().delete(ctx, name); err != nil {
+			slog.Warn(f("failed to delete secret parameter %s: %s", name, err))
+		}
+	}
+}
+
+// ToECSSecrets returns container secrets of the secret parameters.
+// Values of the secret parameters must be references stored by storeSecrets.
+func (p TaskParameter) ToECSSecrets(configParams Parameters) []types.Secret {
+	var secrets []types.Secret
+	for _, v := range configParams {
+		if !v.IsSecret() || p[v.Name] == "" {
+			continue
+		}
+		secrets = append(secrets, types.Secret{
+			Name:      aws.String(strings.ToUpper(v.Env)),
+			ValueFrom: aws.String(p[v.Name]),
+		})
+	}
+	return secrets
+}
+
+// redactSecretEnv sets RedactedValue to env of the secret parameters set to the task.
+func redactSecretEnv(env map[string]string, tags []types.Tag, configParams Parameters) {
+	for _, v := range configParams {
+		if !v.IsSecret() {
+			continue
+		}
+		if lo.ContainsBy(tags, func(t types.Tag) bool { return aws.ToString(t.Key) == v.Name }) {
+			env[strings.ToUpper(v.Env)] = RedactedValue
+		}
+	}
+}
+
+// registerTaskDefinitionWithSecrets registers a task definition that injects the secrets into all containers.
+// The family is the original family with the suffix, so the original task definition is never changed.
+func (e *ECS) registerTaskDefinitionWithSecrets(ctx context.Context, t *ecsTarget, td *types.TaskDefinition, secrets []types.Secret) (string, error) {
+	if td.ExecutionRoleArn == nil {
+		return "", fmt.Errorf("task definition %s must have an execution role to inject secrets", aws.ToString(td.Family))
+	}
+	names := lo.Map(secrets, func(s types.Secret, _ int) string { return aws.ToString(s.Name) })
+	containers := make([]types.ContainerDefinition, 0, len(td.ContainerDefinitions))
+	for _, c := range td.ContainerDefinitions {
+		c.Secrets = append(lo.Reject(c.Secrets, func(s types.Secret, _ int) bool {
+			return lo.Contains(names, aws.ToString(s.Name))
+		}), secrets...)
+		// secrets take precedence over environments of the same name
+		c.Environment = lo.Reject(c.Environment, func(kv types.KeyValuePair, _ int) bool {
+			return lo.Contains(names, aws.ToString(kv.Name))
+		})
+		containers = append(containers, c)
+	}
+	// the task definition may be registered already (e.g. relaunching or waking up the subdomain)
+	digest := secretsDigest(td, secrets)
+	if arn, ok := t.secretTaskDefs.Load(digest); ok {
+		return arn.(string), nil
+	}
+	suffix := e.cfg.Secrets.TaskDefinitionSuffix
+	family := strings.TrimSuffix(aws.ToString(td.Family), suffix) + suffix
+	if latest, err := t.svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(family),
+		Include:        []types.TaskDefinitionField{types.TaskDefinitionFieldTags},
+	}); err == nil && latest.TaskDefinition.Status == types.TaskDefinitionStatusActive &&
+		getTagsFromTaskDefinition(latest.Tags, tagSecretsDigest) == digest {
+		arn := aws.ToString(latest.TaskDefinition.TaskDefinitionArn)
+		slog.Info(f("reuse task definition %s with secrets %v", arn, names))
+		t.secretTaskDefs.Store(digest, arn)
+		return arn, nil
+	}
+	out, err := t.svc.RegisterTaskDefinition(ctx, &ecs.RegisterTaskDefinitionInput{
+		Family:                  aws.String(family),
+		ContainerDefinitions:    containers,
+		Cpu:                     td.Cpu,
+		Memory:                  td.Memory,
+		EphemeralStorage:        td.EphemeralStorage,
+		ExecutionRoleArn:        td.ExecutionRoleArn,
+		TaskRoleArn:             td.TaskRoleArn,
+		NetworkMode:             td.NetworkMode,
+		IpcMode:                 td.IpcMode,
+		PidMode:                 td.PidMode,
+		PlacementConstraints:    td.PlacementConstraints,
+		ProxyConfiguration:      td.ProxyConfiguration,
+		RequiresCompatibilities: td.RequiresCompatibilities,
+		RuntimePlatform:         td.RuntimePlatform,
+		InferenceAccelerators:   td.InferenceAccelerators,
+		Volumes:                 td.Volumes,
+		Tags: []types.Tag{
+			{Key: aws.String(TagManagedBy), Value: aws.String(TagValueMirage)},
+			{Key: aws.String(tagSecretsDigest), Value: aws.String(digest)},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to register task definition with secrets: %w", err)
+	}
+	arn := aws.ToString(out.TaskDefinition.TaskDefinitionArn)
+	slog.Info(f("registered task definition %s with secrets %v", arn, names))
+	t.secretTaskDefs.Store(digest, arn)
+	return arn, nil
+}
+
+// secretsDigest returns a digest of the original task definition and the secrets injected.
+func secretsDigest(td *types.TaskDefinition, secrets []types.Secret) string {
+	h := sha256.New()
+	fmt.Fprintln(h, aws.ToString(td.TaskDefinitionArn))
+	kvs := lo.Map(secrets, func(s types.Secret, _ int) string {
+		return aws.ToString(s.Name) + "=" + aws.ToString(s.ValueFrom)
+	})
+	sort.Strings(kvs)
+	for _, kv := range kvs {
+		fmt.Fprintln(h, kv)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func getTagsFromTaskDefinition(tags []types.Tag, name string) string {
+	for _, t := range tags {
+		if aws.ToString(t.Key) == name {
+			return aws.ToString(t.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,140 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+const secretsConfig = `parameters:
+  - name: branch
+    env: GIT_BRANCH
+    required: true
+  - name: db_password
+    env: DB_PASSWORD
+    type: secret
+    rule: "^[a-z]+$"
+secrets:
+  ssm_prefix: /mirage/
+`
+
+func TestSecretsConfig(t *testing.T) {
+	cfg, err := newTestConfig(t, secretsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Secrets.SSMPrefix != "/mirage" || cfg.Secrets.TaskDefinitionSuffix != mirageecs.DefaultSecretsTaskDefinitionSuffix {
+		t.Errorf("unexpected secrets config %#v", cfg.Secrets)
+	}
+	if _, err := newTestConfig(t, "parameters:\n  - name: foo\n    env: FOO\n    type: unknown\n"); err == nil {
+		t.Error("unknown type should be error")
+	}
+	if _, err := newTestConfig(t, "secrets:\n  ssm_prefix: mirage\n"); err == nil {
+		t.Error("ssm_prefix without leading slash should be error")
+	}
+}
+
+func TestLaunchWithSecret(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := newTestConfig(t, secretsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	launch := func(subdomain, password string) int {
+		t.Helper()
+		res, err := ts.Client().Post(ts.URL+"/api/launch", "application/json",
+			strings.NewReader(`{"subdomain":"`+subdomain+`","taskdef":["dummy"],"branch":"main","parameters":{"db_password":"`+password+`"}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if code := launch("mytask", "hunter"); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if code := launch("reftask", "ssm:/shared/db_password"); code != http.StatusOK {
+		t.Fatalf("reference must not be checked by the rule: %d", code)
+	}
+	if code := launch("badtask", "Hunter2"); code != http.StatusBadRequest {
+		t.Fatalf("rule error should be 400: %d", code)
+	}
+
+	if v, ok := cfg.LocalSecretValue("/mirage/mytask/db_password"); !ok || v != "hunter" {
+		t.Errorf("secret is not stored: %s", v)
+	}
+	if _, ok := cfg.LocalSecretValue("/shared/db_password"); ok {
+		t.Error("reference must not be stored")
+	}
+
+	infos, _ := m.Runner().List(ctx, "RUNNING")
+	refs := map[string]string{}
+	for _, info := range infos {
+		if info.Env["DB_PASSWORD"] != mirageecs.RedactedValue {
+			t.Errorf("secret env must be redacted %#v", info.Env)
+		}
+		for _, tag := range info.Tags {
+			if aws.ToString(tag.Key) == "db_password" {
+				refs[info.SubDomain] = aws.ToString(tag.Value)
+			}
+		}
+	}
+	if refs["mytask"] != "/mirage/mytask/db_password" || refs["reftask"] != "/shared/db_password" {
+		t.Errorf("tags must have references %#v", refs)
+	}
+
+	for _, path := range []string{"/api/list", "/api/history"} {
+		res, err := ts.Client().Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if !json.Valid(b) || strings.Contains(string(b), "hunter") {
+			t.Errorf("%s must not include the secret: %s", path, b)
+		}
+	}
+
+	// secrets are deleted with the subdomain
+	res, err := ts.Client().Post(ts.URL+"/api/terminate", "application/json", strings.NewReader(`{"subdomain":"mytask"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", res.StatusCode)
+	}
+	if _, ok := cfg.LocalSecretValue("/mirage/mytask/db_password"); ok {
+		t.Error("secret must be deleted on terminate")
+	}
+}
+
+func TestSecretsDigest(t *testing.T) {
+	td := &types.TaskDefinition{TaskDefinitionArn: aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task-definition/app:3")}
+	secret := func(name, from string) types.Secret {
+		return types.Secret{Name: aws.String(name), ValueFrom: aws.String(from)}
+	}
+	d := mirageecs.SecretsDigest(td, []types.Secret{secret("A", "/mirage/x/a"), secret("B", "/mirage/x/b")})
+	if d != mirageecs.SecretsDigest(td, []types.Secret{secret("B", "/mirage/x/b"), secret("A", "/mirage/x/a")}) {
+		t.Error("digest must not depend on the order of secrets")
+	}
+	if d == mirageecs.SecretsDigest(td, []types.Secret{secret("A", "/mirage/y/a"), secret("B", "/mirage/x/b")}) {
+		t.Error("digest must depend on the secrets")
+	}
+	td2 := &types.TaskDefinition{TaskDefinitionArn: aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task-definition/app:4")}
+	if d == mirageecs.SecretsDigest(td2, []types.Secret{secret("A", "/mirage/x/a"), secret("B", "/mirage/x/b")}) {
+		t.Error("digest must depend on the task definition")
+	}
+}
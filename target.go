@@ -0,0 +1,135 @@
+package mirageecs
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cwlogs "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/fujiwara/tracer"
+	"github.com/samber/lo"
+)
+
+// DefaultECSTarget is the name of the target defined by the ecs section itself.
+const DefaultECSTarget = "default"
+
+// ECSTargetCfg is a named ECS target to launch tasks.
+// Unset fields are inherited from the ecs section.
+type ECSTargetCfg struct {
+	Name   string `yaml:"name"`
+	ECSCfg `yaml:",inline"`
+}
+
+// setupTargets fills the targets with the default values of the ecs section.
+func (c *ECSCfg) setupTargets(localMode bool) error {
+	names := map[string]struct{}{DefaultECSTarget: {}}
+	for i, t := range c.Targets {
+		if t.Name == "" {
+			return fmt.Errorf("ecs.targets[%d].name is required", i)
+		}
+		if _, ok := names[t.Name]; ok {
+			return fmt.Errorf("ecs.targets[%d].name %s is duplicated", i, t.Name)
+		}
+		names[t.Name] = struct{}{}
+		if len(t.Targets) > 0 {
+			return fmt.Errorf("ecs.targets[%d] cannot have targets", i)
+		}
+
+		if t.Region == "" {
+			t.Region = c.Region
+		}
+		if t.Cluster == "" {
+			t.Cluster = c.Cluster
+		}
+		if t.LaunchType == nil && t.CapacityProviderStrategy == nil {
+			t.LaunchType = c.LaunchType
+			t.CapacityProviderStrategy = c.CapacityProviderStrategy
+		}
+		if t.NetworkConfiguration == nil {
+			t.NetworkConfiguration = c.NetworkConfiguration
+		}
+		if t.DefaultTaskDefinition == "" {
+			t.DefaultTaskDefinition = c.DefaultTaskDefinition
+		}
+		if t.EnableExecuteCommand == nil {
+			t.EnableExecuteCommand = c.EnableExecuteCommand
+		}
+		t.capacityProviderStrategy = t.CapacityProviderStrategy.toSDK()
+		t.networkConfiguration = t.NetworkConfiguration.toSDK()
+		if localMode {
+			continue
+		}
+		if err := t.validate(); err != nil {
+			return fmt.Errorf("ecs.targets[%d] %s is invalid: %w", i, t.Name, err)
+		}
+		slog.Info(f("built ECS target %s: %s", t.Name, t.ECSCfg))
+	}
+	return nil
+}
+
+// TargetNames returns names of the ECS targets. The first one is the default.
+func (c *ECSCfg) TargetNames() []string {
+	return append([]string{DefaultECSTarget}, lo.Map(c.Targets, func(t *ECSTargetCfg, _ int) string {
+		return t.Name
+	})...)
+}
+
+// HasTarget reports whether the target exists. Empty name means the default target.
+func (c *ECSCfg) HasTarget(name string) bool {
+	return name == "" || lo.Contains(c.TargetNames(), name)
+}
+
+// ecsTarget is clients of the ECS target.
+type ecsTarget struct {
+	name    string
+	cfg     *ECSCfg
+	svc     *ecs.Client
+	logsSvc logsClient
+	tracer  *tracer.Tracer
+	states  *taskStateCache
+
+	secretTaskDefs sync.Map // digest of secrets -> ARN of the task definition registered with them
+}
+
+func newECSTarget(name string, cfg *ECSCfg, awscfg aws.Config) (*ecsTarget, error) {
+	if cfg.Region != "" {
+		awscfg = awscfg.Copy()
+		awscfg.Region = cfg.Region
+	}
+	tr, err := tracer.NewWithConfig(awscfg)
+	if err != nil {
+		return nil, err
+	}
+	return &ecsTarget{
+		name:    name,
+		cfg:     cfg,
+		svc:     ecs.NewFromConfig(awscfg),
+		logsSvc: cwlogs.NewFromConfig(awscfg),
+		tracer:  tr,
+	}, nil
+}
+
+// owns reports whether the task ARN (arn:aws:ecs:{region}:{account}:task/{cluster}/{id}) belongs to the target.
+func (t *ecsTarget) owns(taskArn string) bool {
+	p := strings.SplitN(taskArn, ":", 6)
+	if len(p) != 6 {
+		return false
+	}
+	if t.cfg.Region != "" && p[3] != t.cfg.Region {
+		return false
+	}
+	ps := strings.Split(p[5], "/")
+	if len(ps) != 3 {
+		// old ARN format without cluster name
+		return true
+	}
+	cluster := t.cfg.Cluster
+	if i := strings.LastIndex(cluster, "/"); i >= 0 {
+		// cluster ARN
+		cluster = cluster[i+1:]
+	}
+	return ps[1] == cluster
+}
@@ -0,0 +1,177 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestECSTargets(t *testing.T) {
+	cfg, err := newTestConfig(t, `ecs:
+  region: ap-northeast-1
+  cluster: mirage
+  launch_type: FARGATE
+  network_configuration:
+    awsvpc_configuration:
+      subnets: [subnet-aaaa]
+  targets:
+    - name: us
+      region: us-east-1
+      network_configuration:
+        awsvpc_configuration:
+          subnets: [subnet-bbbb]
+    - name: spot
+      capacity_provider_strategy:
+        - capacity_provider: FARGATE_SPOT
+          weight: 1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := cfg.ECS.TargetNames(); strings.Join(names, ",") != "default,us,spot" {
+		t.Errorf("unexpected target names %v", names)
+	}
+	us, spot := cfg.ECS.Targets[0], cfg.ECS.Targets[1]
+	if us.Region != "us-east-1" || us.Cluster != "mirage" || *us.LaunchType != "FARGATE" ||
+		us.NetworkConfiguration.AwsVpcConfiguration.Subnets[0] != "subnet-bbbb" {
+		t.Errorf("unexpected target us %s", us.ECSCfg)
+	}
+	if spot.Region != "ap-northeast-1" || spot.LaunchType != nil || len(spot.CapacityProviderStrategy) != 1 ||
+		spot.NetworkConfiguration.AwsVpcConfiguration.Subnets[0] != "subnet-aaaa" {
+		t.Errorf("unexpected target spot %s", spot.ECSCfg)
+	}
+	if !cfg.ECS.HasTarget("") || !cfg.ECS.HasTarget("us") || cfg.ECS.HasTarget("eu") {
+		t.Error("unexpected HasTarget")
+	}
+
+	for _, invalid := range []string{
+		"ecs:\n  targets:\n    - region: us-east-1\n",
+		"ecs:\n  targets:\n    - name: us\n    - name: us\n",
+		"ecs:\n  targets:\n    - name: default\n",
+	} {
+		if _, err := newTestConfig(t, invalid); err == nil {
+			t.Errorf("should be error: %s", invalid)
+		}
+	}
+}
+
+func TestECSTargetOwns(t *testing.T) {
+	cfg := &mirageecs.ECSCfg{Region: "us-east-1", Cluster: "mirage"}
+	tests := []struct {
+		arn    string
+		expect bool
+	}{
+		{"arn:aws:ecs:us-east-1:123456789012:task/mirage/d007a00bf9a0411ebbcf95291aced40f", true},
+		{"arn:aws:ecs:us-east-1:123456789012:task/other/d007a00bf9a0411ebbcf95291aced40f", false},
+		{"arn:aws:ecs:ap-northeast-1:123456789012:task/mirage/d007a00bf9a0411ebbcf95291aced40f", false},
+		{"d007a00bf9a0411ebbcf95291aced40f", false},
+	}
+	for _, tt := range tests {
+		if got := mirageecs.ECSTargetOwns(cfg, tt.arn); got != tt.expect {
+			t.Errorf("%s: expected %t, got %t", tt.arn, tt.expect, got)
+		}
+	}
+	cfg.Cluster = "arn:aws:ecs:us-east-1:123456789012:cluster/mirage"
+	if !mirageecs.ECSTargetOwns(cfg, tests[0].arn) {
+		t.Error("cluster ARN should be matched")
+	}
+}
+
+func TestLaunchWithTarget(t *testing.T) {
+	cfg, err := newTestConfig(t, "ecs:\n  targets:\n    - name: us\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(context.Background(), cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	res, err := ts.Client().Post(ts.URL+"/api/launch", "application/json",
+		strings.NewReader(`{"subdomain":"mytask","taskdef":["dummy"],"branch":"main","target":"eu"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown target should be 400, got %d", res.StatusCode)
+	}
+
+	res, err = ts.Client().Post(ts.URL+"/api/launch", "application/json",
+		strings.NewReader(`{"subdomain":"mytask","taskdef":["dummy"],"branch":"main","target":"us"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", res.StatusCode)
+	}
+
+	res, err = ts.Client().Get(ts.URL + "/api/list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var r mirageecs.APIListResponse
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Result) != 1 || r.Result[0].Target != "us" {
+		t.Errorf("unexpected list %#v", r.Result)
+	}
+}
+
+func TestECSDescribe(t *testing.T) {
+	ctx := context.Background()
+	cfg := &mirageecs.TaskCacheCfg{}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	ft := &fakeTaskFetcher{tasks: map[string]*mirageecs.Information{}}
+	ft.add("t1", "pr-1", "RUNNING", "RUNNING")
+	ft.add("u1", "", "RUNNING", "RUNNING")
+	e := mirageecs.NewECSWithTaskFetchers(cfg, ft)
+
+	info, err := e.Describe(ctx, fakeTaskArnPrefix+"t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info == nil || info.SubDomain != "pr-1" {
+		t.Errorf("unexpected task %#v", info)
+	}
+	if ft.lists != 0 {
+		t.Errorf("the task should be described without listing, listed %d times", ft.lists)
+	}
+	for _, id := range []string{"u1", "unknown"} {
+		if info, err := e.Describe(ctx, fakeTaskArnPrefix+id); err != nil || info != nil {
+			t.Errorf("%s should not be found: %#v %v", id, info, err)
+		}
+	}
+}
+
+func TestECSListPartialFailure(t *testing.T) {
+	ctx := context.Background()
+	cfg := &mirageecs.TaskCacheCfg{}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	healthy := &fakeTaskFetcher{tasks: map[string]*mirageecs.Information{}}
+	healthy.add("t1", "pr-1", "RUNNING", "RUNNING")
+	failed := &fakeTaskFetcher{tasks: map[string]*mirageecs.Information{}, err: errors.New("access denied")}
+
+	infos, err := mirageecs.NewECSWithTaskFetchers(cfg, healthy, failed).List(ctx, "RUNNING")
+	if err != nil {
+		t.Fatalf("a failed target should not fail the list: %s", err)
+	}
+	if len(infos) != 1 || infos[0].SubDomain != "pr-1" {
+		t.Errorf("tasks of the healthy target should be listed %#v", infos)
+	}
+	if _, err := mirageecs.NewECSWithTaskFetchers(cfg, failed, failed).List(ctx, "RUNNING"); err == nil {
+		t.Error("the list should fail when all the targets failed")
+	}
+}
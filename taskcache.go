@@ -0,0 +1,437 @@
+package mirageecs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/samber/lo"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	DefaultTaskCacheTTL                 = 30 * time.Second
+	DefaultTaskCacheFullRefreshInterval = 5 * time.Minute
+
+	// describeTasksMaxTasks is the maximum number of tasks in a DescribeTasks call.
+	describeTasksMaxTasks = 100
+
+	taskStateChangeSource     = "aws.ecs"
+	taskStateChangeDetailType = "ECS Task State Change"
+)
+
+// taskStateEventsRetryInterval is an interval to retry receiving task state change events after an error.
+var taskStateEventsRetryInterval = 10 * time.Second
+
+// TaskCacheCfg configures the cache of ECS task states shared by the sync loop, the web API and the other readers.
+type TaskCacheCfg struct {
+	// TTL is the age to refresh the cache. The sync loop checks it every 10 seconds, and readers are served the previous states while refreshing.
+	TTL time.Duration `yaml:"ttl"`
+	// FullRefreshInterval is an interval to describe all the tasks again,
+	// to catch changes the incremental refresh cannot detect (e.g. tags set by other Mirage instances).
+	FullRefreshInterval time.Duration `yaml:"full_refresh_interval"`
+	// SQSQueueURL is the queue receiving ECS Task State Change events from EventBridge.
+	// When set, the incremental refresh is driven by the events instead of ListTasks.
+	SQSQueueURL string `yaml:"sqs_queue_url"`
+}
+
+func (c *TaskCacheCfg) validate() error {
+	if c.TTL == 0 {
+		c.TTL = DefaultTaskCacheTTL
+	}
+	if c.FullRefreshInterval == 0 {
+		c.FullRefreshInterval = DefaultTaskCacheFullRefreshInterval
+	}
+	if c.TTL < time.Second {
+		return fmt.Errorf("ttl %s is too short (at least 1s)", c.TTL)
+	}
+	if c.FullRefreshInterval < c.TTL {
+		return fmt.Errorf("full_refresh_interval %s must not be shorter than ttl %s", c.FullRefreshInterval, c.TTL)
+	}
+	if c.SQSQueueURL != "" {
+		u, err := url.Parse(c.SQSQueueURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" || len(u.Path) <= 1 {
+			return fmt.Errorf("sqs_queue_url %s is not a queue URL", c.SQSQueueURL)
+		}
+	}
+	return nil
+}
+
+// taskStateRefresher is a TaskRunner caching task states. The sync loop refreshes the cache.
+type taskStateRefresher interface {
+	RefreshTaskStates(ctx context.Context) error
+}
+
+// taskStateEventReceiver is a TaskRunner receiving task state change events to refresh the cache.
+type taskStateEventReceiver interface {
+	ReceiveTaskStateEvents(ctx context.Context)
+}
+
+// taskFetcher gets tasks of an ECS target.
+type taskFetcher interface {
+	// ListTaskArns returns ARNs of the tasks of the desired status.
+	ListTaskArns(ctx context.Context, desiredStatus string) ([]string, error)
+	// DescribeTasks returns informations of the tasks managed by Mirage. Other tasks and missing tasks are omitted.
+	DescribeTasks(ctx context.Context, arns []string) ([]*Information, error)
+}
+
+// taskStateCache holds states of the running and stopped tasks of an ECS target.
+// A full refresh lists and describes all the tasks. An incremental refresh describes only the tasks
+// which are new, in transition, or marked as stale by mutations and task state change events.
+type taskStateCache struct {
+	cfg     *TaskCacheCfg
+	fetcher taskFetcher
+	now     func() time.Time
+	sf      singleflight.Group
+
+	mu              sync.Mutex
+	tasks           map[string]*Information // by task ARN
+	ignored         map[string]struct{}     // tasks not managed by Mirage or already gone
+	stale           map[string]struct{}     // tasks to describe at the next refresh
+	events          bool                    // task state change events are received
+	dirty           bool                    // ListTasks is required at the next refresh
+	refreshedAt     time.Time
+	fullRefreshedAt time.Time
+}
+
+func newTaskStateCache(cfg *TaskCacheCfg, fetcher taskFetcher) *taskStateCache {
+	if cfg == nil {
+		// Config is not created by NewConfig
+		cfg = &TaskCacheCfg{TTL: DefaultTaskCacheTTL, FullRefreshInterval: DefaultTaskCacheFullRefreshInterval}
+	}
+	return &taskStateCache{
+		cfg:     cfg,
+		fetcher: fetcher,
+		now:     time.Now,
+		tasks:   map[string]*Information{},
+		ignored: map[string]struct{}{},
+		stale:   map[string]struct{}{},
+	}
+}
+
+// list returns copies of the cached informations of the desired status.
+// The cache is refreshed before reading when it is empty or has stale tasks.
+// When it is only older than the TTL, the previous states are served while refreshing in background.
+// When the refresh fails, the previous states are served.
+func (c *taskStateCache) list(ctx context.Context, desiredStatus string) ([]*Information, error) {
+	c.mu.Lock()
+	refreshedAt := c.refreshedAt
+	stale := c.dirty || len(c.stale) > 0
+	expired := c.now().Sub(refreshedAt) >= c.cfg.TTL
+	c.mu.Unlock()
+	switch {
+	case refreshedAt.IsZero() || stale:
+		if err := c.waitRefresh(ctx); err != nil {
+			if refreshedAt.IsZero() {
+				return nil, err
+			}
+			slog.Warn(f("failed to refresh task states, serving states at %s: %s", refreshedAt.Format(time.RFC3339), err))
+		}
+	case expired:
+		ch := c.startRefresh()
+		go func() {
+			if res := <-ch; res.Err != nil {
+				slog.Warn(f("failed to refresh task states, serving states at %s: %s", refreshedAt.Format(time.RFC3339), res.Err))
+			}
+		}()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	infos := []*Information{}
+	for _, info := range c.tasks {
+		if info.DesiredStatus != desiredStatus {
+			continue
+		}
+		cp := *info
+		cp.updateExpiration(now)
+		infos = append(infos, &cp)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ID < infos[j].ID
+	})
+	return infos, nil
+}
+
+// refresh refreshes the cache when it is empty, has stale tasks or is older than the TTL.
+func (c *taskStateCache) refresh(ctx context.Context) error {
+	c.mu.Lock()
+	need := c.refreshedAt.IsZero() || c.dirty || len(c.stale) > 0 || c.now().Sub(c.refreshedAt) >= c.cfg.TTL
+	c.mu.Unlock()
+	if !need {
+		return nil
+	}
+	return c.waitRefresh(ctx)
+}
+
+// startRefresh starts refreshing the cache, or joins the refresh in progress.
+// The refresh runs on a background context, so that a canceled reader does not fail it for the others.
+func (c *taskStateCache) startRefresh() <-chan singleflight.Result {
+	return c.sf.DoChan("refresh", func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), APICallTimeout)
+		defer cancel()
+		return nil, c.refreshStates(ctx)
+	})
+}
+
+// waitRefresh refreshes the cache and waits until it is done or ctx is done.
+func (c *taskStateCache) waitRefresh(ctx context.Context) error {
+	select {
+	case res := <-c.startRefresh():
+		return res.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// refreshStates calls ECS APIs without holding mu, so that readers and mutations are not blocked.
+// It is called only by startRefresh not to run concurrently.
+func (c *taskStateCache) refreshStates(ctx context.Context) (err error) {
+	c.mu.Lock()
+	now := c.now()
+	full := c.fullRefreshedAt.IsZero() || now.Sub(c.fullRefreshedAt) >= c.cfg.FullRefreshInterval
+	needList := full || c.dirty || !c.events
+	stale, dirty := c.stale, c.dirty
+	c.stale, c.dirty = map[string]struct{}{}, false
+	c.mu.Unlock()
+	defer func() {
+		if err == nil {
+			return
+		}
+		// retry them at the next refresh
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for arn := range stale {
+			c.stale[arn] = struct{}{}
+		}
+		c.dirty = c.dirty || dirty
+	}()
+
+	var listed map[string]string
+	if needList {
+		if listed, err = c.listTaskArns(ctx); err != nil {
+			return err
+		}
+	}
+	if full {
+		return c.fullRefresh(ctx, now, listed)
+	}
+
+	describe := lo.Keys(stale)
+	c.mu.Lock()
+	if needList {
+		for arn := range c.tasks {
+			if _, ok := listed[arn]; !ok {
+				delete(c.tasks, arn)
+			}
+		}
+		for arn := range c.ignored {
+			if _, ok := listed[arn]; !ok {
+				delete(c.ignored, arn)
+			}
+		}
+		for arn, status := range listed {
+			if _, ok := c.ignored[arn]; ok {
+				continue
+			}
+			// stable tasks are described again only by the full refresh
+			if info, ok := c.tasks[arn]; !ok || info.DesiredStatus != status || info.LastStatus != status {
+				describe = append(describe, arn)
+			}
+		}
+	}
+	c.mu.Unlock()
+	describe = lo.Uniq(describe)
+	sort.Strings(describe)
+	var infos []*Information
+	if len(describe) > 0 {
+		if infos, err = c.fetcher.DescribeTasks(ctx, describe); err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.apply(describe, infos)
+	slog.Debug(f("refreshed task states incrementally. %d tasks described", len(describe)))
+	c.refreshedAt = now
+	return nil
+}
+
+func (c *taskStateCache) fullRefresh(ctx context.Context, now time.Time, listed map[string]string) error {
+	arns := lo.Keys(listed)
+	sort.Strings(arns)
+	var infos []*Information
+	if len(arns) > 0 {
+		var err error
+		if infos, err = c.fetcher.DescribeTasks(ctx, arns); err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tasks = map[string]*Information{}
+	c.ignored = map[string]struct{}{}
+	c.apply(arns, infos)
+	slog.Debug(f("refreshed task states fully. %d tasks described", len(arns)))
+	c.refreshedAt = now
+	c.fullRefreshedAt = now
+	return nil
+}
+
+// apply stores the described informations. The tasks not described are ignored until they are listed again.
+func (c *taskStateCache) apply(arns []string, infos []*Information) {
+	described := make(map[string]struct{}, len(infos))
+	for _, info := range infos {
+		c.tasks[info.ID] = info
+		delete(c.ignored, info.ID)
+		described[info.ID] = struct{}{}
+	}
+	for _, arn := range arns {
+		if _, ok := described[arn]; !ok {
+			delete(c.tasks, arn)
+			c.ignored[arn] = struct{}{}
+		}
+	}
+}
+
+// listTaskArns returns the desired statuses of the tasks by ARN.
+func (c *taskStateCache) listTaskArns(ctx context.Context) (map[string]string, error) {
+	listed := map[string]string{}
+	for _, status := range []string{statusRunning, statusStopped} {
+		arns, err := c.fetcher.ListTaskArns(ctx, status)
+		if err != nil {
+			return nil, err
+		}
+		for _, arn := range arns {
+			listed[arn] = status
+		}
+	}
+	return listed, nil
+}
+
+// markStale marks the task to be described at the next refresh.
+func (c *taskStateCache) markStale(taskArn string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stale[taskArn] = struct{}{}
+}
+
+// markDirty makes the next refresh list the tasks, because some events may be lost.
+func (c *taskStateCache) markDirty() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dirty = true
+}
+
+// setEvents switches whether the incremental refresh relies on task state change events instead of ListTasks.
+func (c *taskStateCache) setEvents(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = enabled
+	c.dirty = true
+}
+
+// taskStateChange is an ECS Task State Change event delivered by EventBridge.
+type taskStateChange struct {
+	Source     string `json:"source"`
+	DetailType string `json:"detail-type"`
+	Detail     struct {
+		TaskArn       string `json:"taskArn"`
+		ClusterArn    string `json:"clusterArn"`
+		LastStatus    string `json:"lastStatus"`
+		DesiredStatus string `json:"desiredStatus"`
+	} `json:"detail"`
+}
+
+// parseTaskStateChange parses a message body. It returns nil for other events.
+func parseTaskStateChange(body string) (*taskStateChange, error) {
+	var ev taskStateChange
+	if err := json.Unmarshal([]byte(body), &ev); err != nil {
+		return nil, fmt.Errorf("invalid event: %w", err)
+	}
+	if ev.Source != taskStateChangeSource || ev.DetailType != taskStateChangeDetailType {
+		return nil, nil
+	}
+	if ev.Detail.TaskArn == "" {
+		return nil, fmt.Errorf("detail.taskArn is missing")
+	}
+	return &ev, nil
+}
+
+// ReceiveTaskStateEvents receives task state change events from the SQS queue and marks the tasks as stale,
+// until the ctx is canceled.
+func (e *ECS) ReceiveTaskStateEvents(ctx context.Context) {
+	queueURL := aws.String(e.cfg.TaskCache.SQSQueueURL)
+	for _, t := range e.targets {
+		t.states.setEvents(true)
+	}
+	defer func() {
+		for _, t := range e.targets {
+			t.states.setEvents(false)
+		}
+	}()
+
+	for {
+		out, err := e.sqsSvc.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            queueURL,
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     20,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Warn(f("failed to receive task state change events: %s", err))
+			for _, t := range e.targets {
+				t.states.markDirty()
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(taskStateEventsRetryInterval):
+			}
+			continue
+		}
+
+		var entries []sqstypes.DeleteMessageBatchRequestEntry
+		for i, msg := range out.Messages {
+			entries = append(entries, sqstypes.DeleteMessageBatchRequestEntry{
+				Id:            aws.String(strconv.Itoa(i)),
+				ReceiptHandle: msg.ReceiptHandle,
+			})
+			ev, err := parseTaskStateChange(aws.ToString(msg.Body))
+			if err != nil {
+				slog.Warn(f("ignored message %s: %s", aws.ToString(msg.MessageId), err))
+				continue
+			}
+			if ev == nil {
+				continue
+			}
+			t, ok := lo.Find(e.targets, func(t *ecsTarget) bool { return t.owns(ev.Detail.TaskArn) })
+			if !ok {
+				continue
+			}
+			slog.Debug(f("task state changed %s last:%s desired:%s", ev.Detail.TaskArn, ev.Detail.LastStatus, ev.Detail.DesiredStatus))
+			t.states.markStale(ev.Detail.TaskArn)
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		if _, err := e.sqsSvc.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{
+			QueueUrl: queueURL,
+			Entries:  entries,
+		}); err != nil {
+			slog.Warn(f("failed to delete task state change events: %s", err))
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package mirageecs_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+const fakeTaskArnPrefix = "arn:aws:ecs:ap-northeast-1:123456789012:task/mirage/"
+
+// fakeTaskFetcher records the calls to ECS. Tasks whose subdomain is empty are not managed by Mirage.
+type fakeTaskFetcher struct {
+	tasks     map[string]*mirageecs.Information
+	lists     int
+	described []string
+	err       error
+}
+
+func (ft *fakeTaskFetcher) add(id, subdomain, lastStatus, desiredStatus string) {
+	ft.tasks[fakeTaskArnPrefix+id] = &mirageecs.Information{
+		ID:            fakeTaskArnPrefix + id,
+		ShortID:       id,
+		SubDomain:     subdomain,
+		LastStatus:    lastStatus,
+		DesiredStatus: desiredStatus,
+	}
+}
+
+func (ft *fakeTaskFetcher) ListTaskArns(_ context.Context, desiredStatus string) ([]string, error) {
+	if ft.err != nil {
+		return nil, ft.err
+	}
+	ft.lists++
+	var arns []string
+	for arn, info := range ft.tasks {
+		if info.DesiredStatus == desiredStatus {
+			arns = append(arns, arn)
+		}
+	}
+	return arns, nil
+}
+
+func (ft *fakeTaskFetcher) DescribeTasks(_ context.Context, arns []string) ([]*mirageecs.Information, error) {
+	if ft.err != nil {
+		return nil, ft.err
+	}
+	var infos []*mirageecs.Information
+	for _, arn := range arns {
+		ft.described = append(ft.described, strings.TrimPrefix(arn, fakeTaskArnPrefix))
+		if info, ok := ft.tasks[arn]; ok && info.SubDomain != "" {
+			cp := *info
+			infos = append(infos, &cp)
+		}
+	}
+	return infos, nil
+}
+
+// take returns the described task IDs and resets them.
+func (ft *fakeTaskFetcher) take() string {
+	sort.Strings(ft.described)
+	s := strings.Join(ft.described, ",")
+	ft.described = nil
+	return s
+}
+
+func TestTaskStateCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	cfg := &mirageecs.TaskCacheCfg{}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	ft := &fakeTaskFetcher{tasks: map[string]*mirageecs.Information{}}
+	ft.add("t1", "pr-1", "RUNNING", "RUNNING")
+	ft.add("t2", "pr-2", "PENDING", "RUNNING")
+	ft.add("t3", "pr-3", "STOPPED", "STOPPED")
+	ft.add("u1", "", "RUNNING", "RUNNING")
+	c := mirageecs.NewTaskStateCache(cfg, ft, func() time.Time { return now })
+
+	subdomains := func(status string) string {
+		t.Helper()
+		infos, err := c.List(ctx, status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s []string
+		for _, info := range infos {
+			s = append(s, info.SubDomain)
+		}
+		return strings.Join(s, ",")
+	}
+	expect := func(step, described string, lists int) {
+		t.Helper()
+		if got := ft.take(); got != described {
+			t.Errorf("%s: described %q, want %q", step, got, described)
+		}
+		if ft.lists != lists {
+			t.Errorf("%s: listed %d times, want %d", step, ft.lists, lists)
+		}
+	}
+
+	if s := subdomains("RUNNING"); s != "pr-1,pr-2" {
+		t.Errorf("unexpected running tasks %s", s)
+	}
+	expect("first read", "t1,t2,t3,u1", 2)
+
+	infos, _ := c.List(ctx, "RUNNING")
+	infos[0].Health = mirageecs.HealthStatusHealthy
+	if infos, _ := c.List(ctx, "RUNNING"); infos[0].Health != "" {
+		t.Error("readers should get copies of the cache")
+	}
+	expect("read within ttl", "", 2)
+	if err := c.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expect("refresh within ttl", "", 2)
+
+	now = now.Add(cfg.TTL)
+	if err := c.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expect("tasks in transition", "t2", 4)
+
+	ft.tasks[fakeTaskArnPrefix+"t1"].LastStatus = "STOPPING"
+	ft.tasks[fakeTaskArnPrefix+"t1"].DesiredStatus = "STOPPED"
+	ft.tasks[fakeTaskArnPrefix+"t2"].LastStatus = "RUNNING"
+	ft.add("t4", "pr-4", "PROVISIONING", "RUNNING")
+	delete(ft.tasks, fakeTaskArnPrefix+"t3")
+	now = now.Add(cfg.TTL)
+	if err := c.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expect("changes", "t1,t2,t4", 6)
+	if s := subdomains("RUNNING"); s != "pr-2,pr-4" {
+		t.Errorf("unexpected running tasks %s", s)
+	}
+	if s := subdomains("STOPPED"); s != "pr-1" {
+		t.Errorf("unexpected stopped tasks %s", s)
+	}
+	expect("read after refresh", "", 6)
+
+	ft.tasks[fakeTaskArnPrefix+"t1"].LastStatus = "STOPPED"
+	ft.tasks[fakeTaskArnPrefix+"t4"].LastStatus = "RUNNING"
+	c.SetEvents(true)
+	if err := c.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expect("events enabled", "t1,t4", 8)
+	now = now.Add(cfg.TTL)
+	if err := c.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expect("no events", "", 8)
+
+	ft.add("t5", "pr-5", "PENDING", "RUNNING")
+	c.MarkStale(fakeTaskArnPrefix + "t5")
+	if s := subdomains("RUNNING"); s != "pr-2,pr-4,pr-5" {
+		t.Errorf("unexpected running tasks %s", s)
+	}
+	expect("event of a new task", "t5", 8)
+
+	now = now.Add(cfg.FullRefreshInterval)
+	if err := c.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expect("full refresh", "t1,t2,t4,t5,u1", 10)
+
+	ft.err = errors.New("throttled")
+	c.MarkStale(fakeTaskArnPrefix + "t5")
+	if s := subdomains("RUNNING"); s != "pr-2,pr-4,pr-5" {
+		t.Errorf("the cache should be served on errors: %s", s)
+	}
+	if _, err := mirageecs.NewTaskStateCache(cfg, ft, time.Now).List(ctx, "RUNNING"); err == nil {
+		t.Error("the first read should fail on errors")
+	}
+}
+
+// blockingTaskFetcher blocks DescribeTasks until release is closed.
+type blockingTaskFetcher struct {
+	*fakeTaskFetcher
+	release chan struct{}
+}
+
+func (ft *blockingTaskFetcher) DescribeTasks(ctx context.Context, arns []string) ([]*mirageecs.Information, error) {
+	<-ft.release
+	return ft.fakeTaskFetcher.DescribeTasks(ctx, arns)
+}
+
+func TestTaskStateCacheSlowRefresh(t *testing.T) {
+	ctx := context.Background()
+	cfg := &mirageecs.TaskCacheCfg{}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	ft := &blockingTaskFetcher{fakeTaskFetcher: &fakeTaskFetcher{tasks: map[string]*mirageecs.Information{}}, release: make(chan struct{})}
+	ft.add("t1", "pr-1", "RUNNING", "RUNNING")
+	c := mirageecs.NewTaskStateCache(cfg, ft, time.Now)
+
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.List(cctx, "RUNNING"); err == nil {
+		t.Error("the first read should fail when ctx is done")
+	}
+	// mutations are not blocked by the refresh in progress
+	c.MarkStale(fakeTaskArnPrefix + "t1")
+	close(ft.release)
+	infos, err := c.List(ctx, "RUNNING")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].SubDomain != "pr-1" {
+		t.Errorf("unexpected tasks %#v", infos)
+	}
+}
+
+func TestTaskCacheCfg(t *testing.T) {
+	tests := []struct {
+		cfg   mirageecs.TaskCacheCfg
+		valid bool
+	}{
+		{mirageecs.TaskCacheCfg{}, true},
+		{mirageecs.TaskCacheCfg{TTL: time.Minute, FullRefreshInterval: time.Minute}, true},
+		{mirageecs.TaskCacheCfg{SQSQueueURL: "https://sqs.ap-northeast-1.amazonaws.com/123456789012/mirage-events"}, true},
+		{mirageecs.TaskCacheCfg{TTL: time.Millisecond}, false},
+		{mirageecs.TaskCacheCfg{TTL: 10 * time.Minute}, false},
+		{mirageecs.TaskCacheCfg{SQSQueueURL: "mirage-events"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%#v: unexpected validation result %v", tt.cfg, err)
+		}
+	}
+}
+
+func TestParseTaskStateChange(t *testing.T) {
+	ev, err := mirageecs.ParseTaskStateChange(`{
+  "version": "0",
+  "detail-type": "ECS Task State Change",
+  "source": "aws.ecs",
+  "region": "ap-northeast-1",
+  "detail": {
+    "clusterArn": "arn:aws:ecs:ap-northeast-1:123456789012:cluster/mirage",
+    "taskArn": "arn:aws:ecs:ap-northeast-1:123456789012:task/mirage/0123",
+    "lastStatus": "RUNNING",
+    "desiredStatus": "STOPPED"
+  }
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Detail.TaskArn != "arn:aws:ecs:ap-northeast-1:123456789012:task/mirage/0123" || ev.Detail.DesiredStatus != "STOPPED" {
+		t.Errorf("unexpected event %#v", ev)
+	}
+
+	ev, err = mirageecs.ParseTaskStateChange(`{"detail-type": "ECS Container Instance State Change", "source": "aws.ecs", "detail": {}}`)
+	if ev != nil || err != nil {
+		t.Errorf("other events should be ignored: %v %v", ev, err)
+	}
+	for _, body := range []string{"not json", `{"detail-type": "ECS Task State Change", "source": "aws.ecs", "detail": {}}`} {
+		if _, err := mirageecs.ParseTaskStateChange(body); err == nil {
+			t.Errorf("%s should be invalid", body)
+		}
+	}
+}
@@ -30,6 +30,12 @@ variable "domain" {
   type = string
 }
 
+// KMS key to encrypt secret parameters (secrets.kms_key_id). Empty means the AWS managed key.
+variable "secrets_kms_key_arn" {
+  type    = string
+  default = ""
+}
+
 variable "oauth_client_id" {
   type    = string
   default = ""
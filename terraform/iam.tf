@@ -19,7 +19,7 @@ resource "aws_iam_policy" "mirage-ecs" {
   name = var.project
   policy = jsonencode({
     Version = "2012-10-17"
-    Statement = [
+    Statement = concat([
       {
         Action = [
           "iam:PassRole",
@@ -29,11 +29,22 @@ resource "aws_iam_policy" "mirage-ecs" {
           "ecs:DescribeServices",
           "ecs:StopTask",
           "ecs:ListTasks",
+          "ecs:TagResource",
+          "ecs:RegisterTaskDefinition",
           "cloudwatch:PutMetricData",
           "cloudwatch:GetMetricData",
           "logs:GetLogEvents",
+          "logs:StartQuery",
+          "logs:GetQueryResults",
+          "logs:StopQuery",
+          "sqs:ReceiveMessage",
+          "sqs:DeleteMessage",
           "route53:GetHostedZone",
           "route53:ChangeResourceRecordSets",
+          "route53:GetChange",
+          "dynamodb:PutItem",
+          "dynamodb:Query",
+          "dynamodb:DeleteItem",
         ]
         Effect   = "Allow"
         Resource = "*"
@@ -56,7 +67,28 @@ resource "aws_iam_policy" "mirage-ecs" {
           "${aws_s3_bucket.mirage-ecs.arn}",
         ]
       },
-    ]
+      {
+        // parameters of the secret type (secrets.ssm_prefix)
+        Action = [
+          "ssm:PutParameter",
+          "ssm:DeleteParameter",
+        ],
+        Effect   = "Allow",
+        Resource = [
+          "arn:aws:ssm:*:${data.aws_caller_identity.current.account_id}:parameter/mirage-ecs/*",
+        ]
+      },
+      ], var.secrets_kms_key_arn != "" ? [
+      {
+        Action = [
+          "kms:Encrypt",
+        ],
+        Effect   = "Allow",
+        Resource = [
+          var.secrets_kms_key_arn,
+        ]
+      },
+    ] : [])
   })
 }
 
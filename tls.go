@@ -0,0 +1,176 @@
+package mirageecs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+type TLSCfg struct {
+	Certificates []*TLSCertificate `yaml:"certificates"`
+}
+
+type TLSCertificate struct {
+	Cert string `yaml:"cert"` // file path or S3 URL of PEM encoded certificate (chain)
+	Key  string `yaml:"key"`  // file path or S3 URL of PEM encoded private key
+}
+
+func (c *TLSCertificate) load(ctx context.Context, awscfg *aws.Config) (*tls.Certificate, error) {
+	if c.Cert == "" || c.Key == "" {
+		return nil, fmt.Errorf("both cert and key are required")
+	}
+	certPEM, err := loadFromFileOrS3(ctx, awscfg, c.Cert)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load cert %s: %w", c.Cert, err)
+	}
+	keyPEM, err := loadFromFileOrS3(ctx, awscfg, c.Key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load key %s: %w", c.Key, err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key pair %s %s: %w", c.Cert, c.Key, err)
+	}
+	return &cert, nil
+}
+
+// CertificateStore holds TLS certificates and selects one by SNI.
+type CertificateStore struct {
+	mu          sync.RWMutex
+	certs       map[string]*tls.Certificate // key is a DNS name in SAN (may be a wildcard "*.example.net")
+	defaultCert *tls.Certificate
+}
+
+func NewCertificateStore() *CertificateStore {
+	return &CertificateStore{
+		certs: make(map[string]*tls.Certificate),
+	}
+}
+
+// Add adds a certificate to the store. The certificate is indexed by DNS names in its SAN.
+// A certificate added later replaces the former one that has the same name.
+func (s *CertificateStore) Add(cert *tls.Certificate) error {
+	if cert.Leaf == nil {
+		if len(cert.Certificate) == 0 {
+			return fmt.Errorf("no certificate found")
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		cert.Leaf = leaf
+	}
+	names := cert.Leaf.DNSNames
+	if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+		names = []string{cert.Leaf.Subject.CommonName}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range names {
+		name = strings.ToLower(name)
+		slog.Info(f("tls certificate for %s (expire at %s)", name, cert.Leaf.NotAfter))
+		s.certs[name] = cert
+	}
+	if s.defaultCert == nil {
+		s.defaultCert = cert
+	}
+	return nil
+}
+
+// Len returns the number of names which have a certificate.
+func (s *CertificateStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.certs)
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+// It finds a certificate by an exact name at first, and then by a wildcard name.
+func (s *CertificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if cert, ok := s.lookup(name); ok {
+		return cert, nil
+	}
+	if s.defaultCert == nil {
+		return nil, fmt.Errorf("no certificate for %q", name)
+	}
+	if name != "" {
+		slog.Debug(f("no certificate for %s, using default certificate", name))
+	}
+	return s.defaultCert, nil
+}
+
+// Has reports whether the store has a certificate for the name.
+func (s *CertificateStore) Has(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.lookup(strings.ToLower(name))
+	return ok
+}
+
+func (s *CertificateStore) lookup(name string) (*tls.Certificate, bool) {
+	if name == "" {
+		return nil, false
+	}
+	if cert, ok := s.certs[name]; ok {
+		return cert, true
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		if cert, ok := s.certs["*"+name[i:]]; ok {
+			return cert, true
+		}
+	}
+	return nil, false
+}
+
+// TLSConfig returns a tls.Config that uses the store for selecting certificates.
+func (s *CertificateStore) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: s.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+}
+
+func (c *Config) loadCertificates(ctx context.Context) error {
+	c.certificates = NewCertificateStore()
+	for _, tc := range c.TLS.Certificates {
+		slog.Info(f("loading tls certificate: %s", tc.Cert))
+		cert, err := tc.load(ctx, c.awscfg)
+		if err != nil {
+			return err
+		}
+		if err := c.certificates.Add(cert); err != nil {
+			return fmt.Errorf("cannot add certificate %s: %w", tc.Cert, err)
+		}
+	}
+	if c.ACME != nil {
+		// certificates are provided by ACMEManager
+		c.ACME.fillDefaults()
+		return nil
+	}
+	if len(c.Listen.HTTPS) > 0 && c.certificates.Len() == 0 {
+		return fmt.Errorf("listen.https requires tls.certificates or acme")
+	}
+	if c.certificates.Len() > 0 {
+		for _, name := range []string{c.Host.WebApi, "*" + c.Host.ReverseProxySuffix} {
+			if !c.certificates.Has(name) {
+				slog.Warn(f("no tls certificate for %s", name))
+			}
+		}
+	}
+	return nil
+}
+
+// Certificates returns the certificate store for TLS listeners.
+func (c *Config) Certificates() *CertificateStore {
+	return c.certificates
+}
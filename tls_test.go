@@ -0,0 +1,129 @@
+package mirageecs_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+// writeTestCertificate writes a self-signed certificate and key for names into dir.
+func writeTestCertificate(t *testing.T, dir string, prefix string, names ...string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, prefix+".crt")
+	keyFile := filepath.Join(dir, prefix+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSCertificates(t *testing.T) {
+	dir := t.TempDir()
+	wildCert, wildKey := writeTestCertificate(t, dir, "wildcard", "*.dev.example.net")
+	apiCert, apiKey := writeTestCertificate(t, dir, "webapi", "mirage.example.net")
+
+	conf := filepath.Join(dir, "config.yml")
+	data := `---
+host:
+  webapi: mirage.example.net
+  reverse_proxy_suffix: .dev.example.net
+listen:
+  http:
+    - listen: 80
+      target: 80
+  https:
+    - listen: 443
+      target: 80
+tls:
+  certificates:
+    - cert: ` + wildCert + `
+      key: ` + wildKey + `
+    - cert: ` + apiCert + `
+      key: ` + apiKey + `
+`
+	if err := os.WriteFile(conf, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := mirageecs.NewConfig(context.Background(), &mirageecs.ConfigParams{Path: conf})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		serverName string
+		expect     string
+	}{
+		{"foo.dev.example.net", "*.dev.example.net"},
+		{"BAR.dev.example.net", "*.dev.example.net"},
+		{"mirage.example.net", "mirage.example.net"},
+		{"unknown.example.com", "*.dev.example.net"}, // default is the first one
+		{"", "*.dev.example.net"},
+	}
+	store := cfg.Certificates()
+	for _, tt := range tests {
+		cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tt.serverName, err)
+			continue
+		}
+		if cert.Leaf.DNSNames[0] != tt.expect {
+			t.Errorf("%s: expected %s, got %s", tt.serverName, tt.expect, cert.Leaf.DNSNames[0])
+		}
+	}
+	if store.Has("foo.bar.dev.example.net") {
+		t.Error("wildcard must match only one label")
+	}
+}
+
+func TestTLSCertificatesRequired(t *testing.T) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "config.yml")
+	data := `---
+listen:
+  https:
+    - listen: 443
+      target: 80
+`
+	if err := os.WriteFile(conf, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mirageecs.NewConfig(context.Background(), &mirageecs.ConfigParams{Path: conf}); err == nil {
+		t.Error("listen.https without certificates must be error")
+	}
+}
@@ -0,0 +1,198 @@
+package mirageecs
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+const (
+	ScopeRead      = "read"
+	ScopeLaunch    = "launch"
+	ScopeTerminate = "terminate"
+	ScopePurge     = "purge"
+	ScopePresets   = "presets"
+)
+
+var validScopes = []string{ScopeRead, ScopeLaunch, ScopeTerminate, ScopePurge, ScopePresets}
+
+// NamedToken is an API token identified by the name.
+type NamedToken struct {
+	Name string `yaml:"name"`
+	// Token is a plain text token. Either Token or TokenSHA256 is required.
+	Token string `yaml:"token"`
+	// TokenSHA256 is a hex encoded SHA-256 hash of the token.
+	TokenSHA256 string `yaml:"token_sha256"`
+	// Scopes limit the allowed actions. Empty means all actions allowed by the role.
+	Scopes []string `yaml:"scopes"`
+	// ExpiresAt is the time when the token expires. Zero means never.
+	ExpiresAt time.Time `yaml:"expires_at"`
+	// AllowedIPs are IP addresses or CIDRs of clients allowed to use the token. Empty means any.
+	AllowedIPs []string `yaml:"allowed_ips"`
+}
+
+func (t *NamedToken) validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if (t.Token == "") == (t.TokenSHA256 == "") {
+		return fmt.Errorf("token %s: either token or token_sha256 is required", t.Name)
+	}
+	if t.TokenSHA256 != "" {
+		if b, err := hex.DecodeString(t.TokenSHA256); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("token %s: token_sha256 must be a hex encoded SHA-256 hash", t.Name)
+		}
+	}
+	for _, s := range t.Scopes {
+		if !lo.Contains(validScopes, s) {
+			return fmt.Errorf("token %s: invalid scope %s", t.Name, s)
+		}
+	}
+	for _, ip := range t.AllowedIPs {
+		if _, err := parseIPNet(ip); err != nil {
+			return fmt.Errorf("token %s: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// parseIPNet parses an IP address or a CIDR.
+func parseIPNet(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %s", s)
+		}
+		bits := lo.Ternary(ip.To4() != nil, 32, 128)
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %s", s)
+	}
+	return n, nil
+}
+
+// equal compares the sent token in constant time.
+func (t *NamedToken) equal(sent string) bool {
+	if t.TokenSHA256 != "" {
+		sum := sha256.Sum256([]byte(sent))
+		return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(t.TokenSHA256))) == 1
+	}
+	return subtle.ConstantTimeCompare([]byte(t.Token), []byte(sent)) == 1
+}
+
+func (t *NamedToken) allowedFrom(ip net.IP) bool {
+	if len(t.AllowedIPs) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	return lo.SomeBy(t.AllowedIPs, func(s string) bool {
+		n, err := parseIPNet(s)
+		return err == nil && n.Contains(ip)
+	})
+}
+
+// clientIP returns the IP address of the client.
+// X-Forwarded-For is used only when the peer is a trusted proxy, because it can be spoofed by clients.
+// The last address of X-Forwarded-For that is not a trusted proxy is the client.
+func (b *AuthMethodToken) clientIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if !b.trusted(ip) {
+		return ip
+	}
+	xff := req.Header.Values("X-Forwarded-For")
+	ps := strings.Split(strings.Join(xff, ","), ",")
+	for i := len(ps) - 1; i >= 0; i-- {
+		p := net.ParseIP(strings.TrimSpace(ps[i]))
+		if p == nil {
+			// X-Forwarded-For is empty or broken
+			return ip
+		}
+		ip = p
+		if !b.trusted(ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+func (b *AuthMethodToken) trusted(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	return lo.SomeBy(b.TrustedProxies, func(s string) bool {
+		n, err := parseIPNet(s)
+		return err == nil && n.Contains(ip)
+	})
+}
+
+func (b *AuthMethodToken) validate() error {
+	if b.Header == "" {
+		return fmt.Errorf("header is required")
+	}
+	names := map[string]struct{}{}
+	for i, t := range b.Tokens {
+		if err := t.validate(); err != nil {
+			return fmt.Errorf("tokens[%d]: %w", i, err)
+		}
+		if _, ok := names[t.Name]; ok {
+			return fmt.Errorf("tokens[%d]: name %s is duplicated", i, t.Name)
+		}
+		names[t.Name] = struct{}{}
+	}
+	for _, ip := range b.TrustedProxies {
+		if _, err := parseIPNet(ip); err != nil {
+			return fmt.Errorf("trusted_proxies: %w", err)
+		}
+	}
+	return nil
+}
+
+// MatchNamed returns the named token that matches the request.
+// Expired tokens and tokens used from not allowed IP addresses never match.
+func (b *AuthMethodToken) MatchNamed(req *http.Request, now time.Time) *NamedToken {
+	if b == nil || len(b.Tokens) == 0 {
+		return nil
+	}
+	sent := req.Header.Get(b.Header)
+	if sent == "" {
+		return nil
+	}
+	t, ok := lo.Find(b.Tokens, func(t *NamedToken) bool { return t.equal(sent) })
+	if !ok {
+		return nil
+	}
+	if !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt) {
+		slog.Warn(f("token %s is expired at %s", t.Name, t.ExpiresAt.Format(time.RFC3339)))
+		return nil
+	}
+	if ip := b.clientIP(req); !t.allowedFrom(ip) {
+		slog.Warn(f("token %s is not allowed from %s", t.Name, ip))
+		return nil
+	}
+	slog.Info(f("token %s is used for %s %s", t.Name, req.Method, req.URL.Path))
+	return t
+}
+
+// HasScope reports whether the identity is allowed the scope. Identities without scopes are allowed all scopes.
+func (i *Identity) HasScope(scope string) bool {
+	if i == nil {
+		return false
+	}
+	return i.Scopes == nil || lo.Contains(i.Scopes, scope)
+}
@@ -0,0 +1,140 @@
+package mirageecs_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestNamedTokensConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		isErr bool
+	}{
+		{"plain", "name: ci\n        token: xxx", false},
+		{"hash", "name: ci\n        token_sha256: " + strings.Repeat("ab", 32), false},
+		{"no name", "token: xxx", true},
+		{"both", "name: ci\n        token: xxx\n        token_sha256: " + strings.Repeat("ab", 32), true},
+		{"invalid hash", "name: ci\n        token_sha256: xyz", true},
+		{"invalid scope", "name: ci\n        token: xxx\n        scopes: [admin]", true},
+		{"invalid ip", "name: ci\n        token: xxx\n        allowed_ips: [10.0.0.0/33]", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestConfig(t, "auth:\n  token:\n    header: x-mirage-token\n    tokens:\n      - "+tt.token+"\n")
+			if tt.isErr != (err != nil) {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestNamedTokens(t *testing.T) {
+	hash := sha256.Sum256([]byte("bot-token"))
+	cfg, err := newTestConfig(t, `auth:
+  token:
+    header: x-mirage-token
+    trusted_proxies: [127.0.0.1, 10.0.0.0/8]
+    tokens:
+      - name: ci
+        token: ci-token
+        scopes: [read, launch]
+      - name: bot
+        token_sha256: `+hex.EncodeToString(hash[:])+`
+      - name: expired
+        token: expired-token
+        expires_at: 2020-01-01T00:00:00Z
+      - name: office
+        token: office-token
+        allowed_ips: [192.0.2.0/24, 2001:db8::1]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(context.Background(), cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		token  string
+		xff    string
+		status int
+	}{
+		{"read by ci", http.MethodGet, "/api/list", "", "ci-token", "", http.StatusOK},
+		{"launch by ci", http.MethodPost, "/api/launch", `{"subdomain":"ci","taskdef":["dummy"],"branch":"main"}`, "ci-token", "", http.StatusOK},
+		{"terminate by ci", http.MethodPost, "/api/terminate", `{"subdomain":"ci"}`, "ci-token", "", http.StatusForbidden},
+		{"purge by ci", http.MethodPost, "/api/purge", `{"duration":"86400"}`, "ci-token", "", http.StatusForbidden},
+		{"hashed token", http.MethodPost, "/api/terminate", `{"subdomain":"ci"}`, "bot-token", "", http.StatusOK},
+		{"hash itself is not a token", http.MethodGet, "/api/list", "", hex.EncodeToString(hash[:]), "", http.StatusUnauthorized},
+		{"expired", http.MethodGet, "/api/list", "", "expired-token", "", http.StatusUnauthorized},
+		{"allowed ip", http.MethodGet, "/api/list", "", "office-token", "203.0.113.1, 192.0.2.10", http.StatusOK},
+		{"allowed ipv6", http.MethodGet, "/api/list", "", "office-token", "2001:db8::1", http.StatusOK},
+		{"not allowed ip", http.MethodGet, "/api/list", "", "office-token", "192.0.2.10, 203.0.113.1", http.StatusUnauthorized},
+		{"not allowed remote addr", http.MethodGet, "/api/list", "", "office-token", "", http.StatusUnauthorized},
+		{"allowed ip behind proxies", http.MethodGet, "/api/list", "", "office-token", "192.0.2.10, 10.0.0.5", http.StatusOK},
+		{"spoofed by the client", http.MethodGet, "/api/list", "", "office-token", "192.0.2.10, 203.0.113.1, 10.0.0.5", http.StatusUnauthorized},
+		{"unknown", http.MethodGet, "/api/list", "", "unknown-token", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("x-mirage-token", tt.token)
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			res, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.status {
+				t.Errorf("unexpected status %d, expected %d", res.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestNamedTokensUntrustedProxy(t *testing.T) {
+	if _, err := newTestConfig(t, "auth:\n  token:\n    header: x-mirage-token\n    trusted_proxies: [10.0.0.0/33]\n"); err == nil {
+		t.Error("invalid trusted_proxies must be an error")
+	}
+	cfg, err := newTestConfig(t, `auth:
+  token:
+    header: x-mirage-token
+    tokens:
+      - name: office
+        token: office-token
+        allowed_ips: [192.0.2.0/24]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(context.Background(), cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	// X-Forwarded-For from a peer not in trusted_proxies is ignored
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/list", nil)
+	req.Header.Set("x-mirage-token", "office-token")
+	req.Header.Set("X-Forwarded-For", "192.0.2.10")
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("spoofed X-Forwarded-For must be rejected: %d", res.StatusCode)
+	}
+}
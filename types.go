@@ -3,11 +3,14 @@ package mirageecs
 import (
 	"encoding/json"
 	"net/url"
+	"time"
 )
 
 // APIListResponse is a response of /api/list
 type APIListResponse struct {
 	Result []*APITaskInfo `json:"result"`
+	// NextCursor is the cursor of the next page. It is set only when the limit is specified and more tasks remain.
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 type APITaskInfo = Information
@@ -21,6 +24,16 @@ type APILogsResponse struct {
 	Result []string `json:"result"`
 }
 
+// APIHistoryResponse is a response of /api/history
+type APIHistoryResponse struct {
+	Result []*HistoryRecord `json:"result"`
+}
+
+// APISleepingResponse is a response of /api/sleeping
+type APISleepingResponse struct {
+	Result []*SleepingSubdomain `json:"result"`
+}
+
 // APIAccessResponse is a response of /api/access
 type APIAccessResponse struct {
 	Result   string `json:"result"`
@@ -33,6 +46,44 @@ type APILaunchRequest struct {
 	Branch     string            `json:"branch" form:"branch"`
 	Taskdef    []string          `json:"taskdef" form:"taskdef"`
 	Parameters map[string]string `json:"parameters" form:"parameters"`
+	TTL        string            `json:"ttl" form:"ttl"`
+	ExpireAt   string            `json:"expire_at" form:"expire_at"`
+
+	Preset      string `json:"preset" form:"preset"`
+	Target      string `json:"target" form:"target"`
+	Wait        bool   `json:"wait" form:"wait"`
+	WaitTimeout string `json:"wait_timeout" form:"wait_timeout"`
+	Force       bool   `json:"force" form:"force"` // overwrite a subdomain owned by another identity
+
+	Overrides *TaskOverrides `json:"overrides" form:"-"` // JSON only
+}
+
+// APILaunchResponse is a response of /api/launch
+type APILaunchResponse struct {
+	Result string             `json:"result"`
+	Status string             `json:"status,omitempty"` // running, stopped or timeout with wait=true
+	Tasks  []*APILaunchedTask `json:"tasks,omitempty"`
+
+	Launched []*LaunchResult `json:"launched,omitempty"`
+}
+
+type APILaunchedTask struct {
+	ID            string                `json:"id"`
+	ShortID       string                `json:"short_id"`
+	TaskDef       string                `json:"taskdef"`
+	IPAddress     string                `json:"ipaddress"`
+	PortMap       map[string]int        `json:"port_map"`
+	LastStatus    string                `json:"last_status"`
+	Ready         bool                  `json:"ready"`
+	StoppedReason string                `json:"stopped_reason,omitempty"`
+	Containers    []*APIContainerStatus `json:"containers,omitempty"`
+}
+
+type APIContainerStatus struct {
+	Name       string `json:"name"`
+	LastStatus string `json:"last_status"`
+	ExitCode   *int32 `json:"exit_code,omitempty"`
+	Reason     string `json:"reason,omitempty"`
 }
 
 func (r *APILaunchRequest) GetParameter(key string) string {
@@ -47,7 +98,8 @@ func (r *APILaunchRequest) MergeForm(form url.Values) {
 		r.Parameters = make(map[string]string, len(form))
 	}
 	for key, values := range form {
-		if key == "branch" || key == "subdomain" || key == "taskdef" {
+		if key == "branch" || key == "subdomain" || key == "taskdef" || key == "ttl" || key == "expire_at" ||
+			key == "preset" || key == "target" || key == "wait" || key == "wait_timeout" || key == "force" {
 			continue
 		}
 		r.Parameters[key] = values[0]
@@ -58,9 +110,33 @@ type APIPurgeRequest struct {
 	Duration    json.Number `json:"duration" form:"duration"`
 	Excludes    []string    `json:"excludes" form:"excludes"`
 	ExcludeTags []string    `json:"exclude_tags" form:"exclude_tags"`
+	DryRun      bool        `json:"dry_run" form:"dry_run"`
+}
+
+// APIPresetsResponse is a response of /api/presets
+type APIPresetsResponse struct {
+	Result []*Preset `json:"result"`
+}
+
+// APIPurgeStatusResponse is a response of /api/purge/status
+type APIPurgeStatusResponse struct {
+	Result  *PurgeReport `json:"result"`
+	Running bool         `json:"running"`
 }
 
 type APITerminateRequest struct {
 	ID        string `json:"id" form:"id"`
 	Subdomain string `json:"subdomain" form:"subdomain"`
 }
+
+type APIExtendRequest struct {
+	Subdomain string `json:"subdomain" form:"subdomain"`
+	TTL       string `json:"ttl" form:"ttl"`
+	ExpireAt  string `json:"expire_at" form:"expire_at"`
+}
+
+// APIExtendResponse is a response of /api/extend
+type APIExtendResponse struct {
+	Result   string    `json:"result"`
+	ExpireAt time.Time `json:"expire_at"`
+}
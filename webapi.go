@@ -34,6 +34,11 @@ type WebApi struct {
 	cfg    *Config
 	runner TaskRunner
 	mu     *sync.Mutex
+
+	purgeStatus    purgeStatus
+	sleeping       *sleepers
+	expiryWarnings *expiryWarnings
+	proxy          *ReverseProxy
 }
 
 type Template struct {
@@ -51,32 +56,58 @@ func (t *Template) Render(w io.Writer, name string, data interface{}, c echo.Con
 
 func NewWebApi(cfg *Config, runner TaskRunner) *WebApi {
 	app := &WebApi{
-		mu:     &sync.Mutex{},
-		runner: runner,
+		mu:             &sync.Mutex{},
+		runner:         runner,
+		sleeping:       newSleepers(cfg.sleepingStore()),
+		expiryWarnings: newExpiryWarnings(),
 	}
 	app.cfg = cfg
 
 	e := echo.New()
 	e.Use(middleware.Logger())
 
+	viewer := app.require(RoleViewer, ScopeRead)
+	launcher := app.require(RoleLauncher, ScopeLaunch)
+	terminator := app.require(RoleLauncher, ScopeTerminate)
+	purger := app.require(RoleAdmin, ScopePurge)
+	presetAdmin := app.require(RoleAdmin, ScopePresets)
+
+	if cfg.Auth != nil && cfg.Auth.OIDC != nil {
+		e.GET(OIDCLoginPath, cfg.OIDCLogin)
+		e.GET(OIDCCallbackPath, cfg.OIDCCallback)
+		e.GET(OIDCLogoutPath, cfg.OIDCLogout)
+	}
+
 	web := e.Group("")
 	web.Use(cfg.AuthMiddlewareForWeb)
-	web.GET("/", app.Top)
-	web.GET("/list", app.List)
-	web.GET("/launcher", app.Launcher)
-	web.GET("/trace/:taskid", app.Trace)
-	web.POST("/launch", app.Launch)
-	web.POST("/terminate", app.Terminate)
+	web.GET("/", app.Top, viewer)
+	web.GET("/list", app.List, viewer)
+	web.GET("/launcher", app.Launcher, launcher)
+	web.GET("/trace/:taskid", app.Trace, viewer)
+	web.GET("/logs", app.Logs, viewer)
+	web.GET("/logs/stream", app.LogsStream, viewer)
+	web.GET("/history", app.History, viewer)
+	web.GET("/events", app.Events, viewer)
+	web.POST("/launch", app.Launch, launcher)
+	web.POST("/terminate", app.Terminate, terminator)
 
 	api := e.Group("/api")
 	api.Use(cfg.CompatMiddlewareForAPI)
 	api.Use(cfg.AuthMiddlewareForAPI)
-	api.GET("/list", app.ApiList)
-	api.GET("/access", app.ApiAccess)
-	api.GET("/logs", app.ApiLogs)
-	api.POST("/launch", app.ApiLaunch)
-	api.POST("/terminate", app.ApiTerminate)
-	api.POST("/purge", app.ApiPurge)
+	api.GET("/list", app.ApiList, viewer)
+	api.GET("/access", app.ApiAccess, viewer)
+	api.GET("/logs", app.ApiLogs, viewer)
+	api.GET("/history", app.ApiHistory, viewer)
+	api.POST("/launch", app.ApiLaunch, launcher)
+	api.POST("/terminate", app.ApiTerminate, terminator)
+	api.POST("/purge", app.ApiPurge, purger)
+	api.GET("/purge/status", app.ApiPurgeStatus, viewer)
+	api.POST("/extend", app.ApiExtend, launcher)
+	api.GET("/sleeping", app.ApiSleeping, viewer)
+	api.GET("/presets", app.ApiPresets, viewer)
+	api.POST("/presets", app.ApiPutPreset, presetAdmin)
+	api.DELETE("/presets/:name", app.ApiDeletePreset, presetAdmin)
+	api.GET("/events", app.Events, viewer)
 
 	e.Renderer = &Template{
 		templates: template.Must(template.ParseGlob(cfg.HtmlDir + "/*")),
@@ -113,9 +144,14 @@ func (api *WebApi) List(c echo.Context) error {
 		stoppedSubdomains[info.SubDomain] = struct{}{}
 		return true
 	})
+	api.setHealthStatus(infoRunning)
 	info := append(infoRunning, infoStopped...)
+	if c.QueryParam("mine") == "true" {
+		me := identityOf(c).String()
+		info = lo.Filter(info, func(info *Information, _ int) bool { return info.Owner == me })
+	}
 	value := map[string]interface{}{
-		"info":  info,
+		"info":  api.cfg.Redactor().Informations(info),
 		"error": err,
 	}
 	return c.Render(http.StatusOK, "list.html", value)
@@ -128,14 +164,20 @@ func (api *WebApi) Launcher(c echo.Context) error {
 	} else {
 		taskdefs = []string{api.cfg.ECS.DefaultTaskDefinition}
 	}
+	presets, err := api.cfg.PresetRegistry().List(c.Request().Context())
+	if err != nil {
+		slog.Warn(f("failed to list presets: %s", err))
+	}
 	return c.Render(http.StatusOK, "launcher.html", map[string]interface{}{
+		"Presets":                lo.Map(presets, func(p *Preset, _ int) *Preset { return api.cfg.Redactor().Preset(p) }),
 		"DefaultTaskDefinitions": taskdefs,
 		"Parameters":             api.cfg.Parameter,
+		"Targets":                api.cfg.ECS.TargetNames(),
 	})
 }
 
 func (api *WebApi) Launch(c echo.Context) error {
-	code, err := api.launch(c)
+	code, _, err := api.launch(c)
 	if err != nil {
 		return c.String(code, err.Error())
 	}
@@ -166,62 +208,157 @@ func (api *WebApi) Trace(c echo.Context) error {
 }
 
 func (api *WebApi) ApiList(c echo.Context) error {
-	info, err := api.runner.List(c.Request().Context(), statusRunning)
+	q, err := parseListQuery(c, api.cfg)
 	if err != nil {
-		return c.JSON(500, APIListResponse{})
+		return c.JSON(http.StatusBadRequest, APICommonResponse{Result: err.Error()})
+	}
+	ctx := c.Request().Context()
+	var infos []*Information
+	for _, status := range q.statuses() {
+		is, err := api.runner.List(ctx, status)
+		if err != nil {
+			return c.JSON(500, APIListResponse{})
+		}
+		if status == statusRunning {
+			api.setHealthStatus(is)
+		}
+		infos = append(infos, is...)
+	}
+	infos, next := q.apply(infos)
+	return c.JSON(200, APIListResponse{Result: api.cfg.Redactor().Informations(infos), NextCursor: next})
+}
+
+// setHealthStatus sets the health status of running tasks in the reverse proxy.
+func (api *WebApi) setHealthStatus(infos []*Information) {
+	if api.proxy == nil {
+		return
+	}
+	for _, info := range infos {
+		info.Health = api.proxy.HealthStatus(info.SubDomain, info.IPAddress)
+		info.Ready = info.Health == HealthStatusHealthy
 	}
-	return c.JSON(200, APIListResponse{Result: info})
 }
 
 func (api *WebApi) ApiLaunch(c echo.Context) error {
-	code, err := api.launch(c)
+	code, res, err := api.launch(c)
+	if res != nil {
+		return c.JSON(code, res)
+	}
 	if err != nil {
 		return c.JSON(code, APICommonResponse{Result: err.Error()})
 	}
-	return c.JSON(code, APICommonResponse{Result: "ok"})
+	return c.JSON(code, APILaunchResponse{Result: "ok"})
 }
 
-func (api *WebApi) launch(c echo.Context) (int, error) {
+// launch launches tasks. The response is returned after calling TaskRunner.Launch, even if it failed.
+func (api *WebApi) launch(c echo.Context) (int, *APILaunchResponse, error) {
 	r := APILaunchRequest{}
 	ps, _ := c.FormParams()
 	r.MergeForm(ps)
 	if err := c.Bind(&r); err != nil {
-		return http.StatusBadRequest, err
+		return http.StatusBadRequest, nil, err
+	}
+	var tags map[string]string
+	if r.Preset != "" {
+		p, err := api.cfg.PresetRegistry().Get(c.Request().Context(), r.Preset)
+		if err != nil {
+			slog.Error(f("launch failed: %s", err))
+			return http.StatusBadRequest, nil, err
+		}
+		p.apply(&r)
+		tags = p.Tags
 	}
 
 	subdomain := r.Subdomain
 	subdomain = strings.ToLower(subdomain)
 	if err := validateSubdomain(subdomain); err != nil {
 		slog.Error(f("launch failed: %s", err))
-		return http.StatusBadRequest, err
+		return http.StatusBadRequest, nil, err
 	}
 	taskdefs := r.Taskdef
 	parameter, err := api.LoadParameter(r.GetParameter)
 	if err != nil {
 		slog.Error(f("failed to load parameter: %s", err))
-		return http.StatusBadRequest, err
+		return http.StatusBadRequest, nil, err
+	}
+
+	expireAt, err := parseExpiration(r.TTL, r.ExpireAt, time.Now())
+	if err != nil {
+		slog.Error(f("launch failed: %s", err))
+		return http.StatusBadRequest, nil, err
+	}
+
+	if !api.cfg.ECS.HasTarget(r.Target) {
+		return http.StatusBadRequest, nil, fmt.Errorf("unknown target %s", r.Target)
+	}
+	if err := r.Overrides.validate(api.cfg); err != nil {
+		slog.Error(f("launch failed: %s", err))
+		return http.StatusBadRequest, nil, err
+	}
+
+	var waitTimeout time.Duration
+	if r.Wait {
+		if waitTimeout, err = parseWaitTimeout(r.WaitTimeout); err != nil {
+			return http.StatusBadRequest, nil, err
+		}
 	}
 
 	if subdomain == "" || len(taskdefs) == 0 {
-		return http.StatusBadRequest, fmt.Errorf("parameter required: subdomain=%s, taskdef=%v", subdomain, taskdefs)
+		return http.StatusBadRequest, nil, fmt.Errorf("parameter required: subdomain=%s, taskdef=%v", subdomain, taskdefs)
 	} else {
 		ctx, cancel := context.WithTimeout(c.Request().Context(), APICallTimeout)
 		defer cancel()
-		err := api.runner.Launch(ctx, subdomain, parameter, taskdefs...)
+		// launching tasks replaces the running subdomain
+		if code, err := api.authorizeOverwrite(ctx, identityOf(c), subdomain, r.Force); err != nil {
+			return code, nil, err
+		}
+		if err := api.cfg.storeSecrets(ctx, subdomain, parameter); err != nil {
+			slog.Error(f("launch failed: %s", err))
+			return http.StatusInternalServerError, nil, err
+		}
+		h := newHistoryRecord(HistoryActionLaunch, subdomain, identityOf(c))
+		// history is persisted, so sensitive values are never stored
+		h.Parameters = api.cfg.Redactor().Parameters(parameter)
+		h.Taskdefs = taskdefs
+		api.sleeping.delete(ctx, subdomain)
+		api.cfg.EventBus().Publish(&Event{
+			Type:      EventLaunchRequested,
+			Subdomain: subdomain,
+			Actor:     h.Actor,
+			Detail:    map[string]string{"taskdefs": strings.Join(taskdefs, ",")},
+		})
+		opt := LaunchOption{
+			ExpireAt:  expireAt,
+			Target:    r.Target,
+			Tags:      tags,
+			Overrides: r.Overrides,
+			Owner:     h.Actor,
+		}
+		results, err := api.runner.Launch(ctx, subdomain, parameter, opt, taskdefs...)
+		h.TaskID = strings.Join(launchedTaskIDs(results), ",")
+		api.putHistory(h, err)
 		if err != nil {
 			slog.Error(f("launch failed: %s", err))
-			return http.StatusInternalServerError, err
+			api.cfg.EventBus().Publish(&Event{
+				Type:      EventLaunchFailed,
+				Subdomain: subdomain,
+				Actor:     h.Actor,
+				Detail:    map[string]string{"error": err.Error()},
+			})
+			code := http.StatusInternalServerError
+			if errors.Is(err, errInvalidOverrides) {
+				code = http.StatusBadRequest
+			}
+			return code, &APILaunchResponse{Result: err.Error(), Launched: results}, err
 		}
+		api.cfg.EventBus().Publish(&Event{Type: EventLaunched, Subdomain: subdomain, Actor: h.Actor})
+		if r.Wait {
+			code, res, err := api.waitLaunch(c.Request().Context(), newLaunchWaiter(subdomain, results), waitTimeout)
+			res.Launched = results
+			return code, res, err
+		}
+		return http.StatusOK, &APILaunchResponse{Result: "ok", Launched: results}, nil
 	}
-	return http.StatusOK, nil
-}
-
-func (api *WebApi) ApiLogs(c echo.Context) error {
-	code, logs, err := api.logs(c)
-	if err != nil {
-		return c.JSON(code, APICommonResponse{Result: err.Error()})
-	}
-	return c.JSON(code, APILogsResponse{Result: logs})
 }
 
 func (api *WebApi) ApiTerminate(c echo.Context) error {
@@ -248,41 +385,30 @@ func (api *WebApi) ApiPurge(c echo.Context) error {
 	return c.JSON(code, APICommonResponse{Result: "accepted"})
 }
 
-func (api *WebApi) logs(c echo.Context) (int, []string, error) {
-	subdomain := c.QueryParam("subdomain")
-	since := c.QueryParam("since")
-	tail := c.QueryParam("tail")
-
-	if subdomain == "" {
-		return http.StatusBadRequest, nil, fmt.Errorf("parameter required: subdomain")
+func (api *WebApi) ApiExtend(c echo.Context) error {
+	code, expireAt, err := api.extend(c)
+	if err != nil {
+		return c.JSON(code, APICommonResponse{Result: err.Error()})
 	}
+	return c.JSON(code, APIExtendResponse{Result: "ok", ExpireAt: expireAt})
+}
 
-	var sinceTime time.Time
-	if since != "" {
-		var err error
-		sinceTime, err = time.Parse(time.RFC3339, since)
-		if err != nil {
-			return http.StatusBadRequest, nil, fmt.Errorf("cannot parse since: %s", err)
-		}
-	}
-	var tailN int
-	if tail != "" {
-		if tail == "all" {
-			tailN = 0
-		} else if n, err := strconv.Atoi(tail); err != nil {
-			return http.StatusBadRequest, nil, fmt.Errorf("cannot parse tail: %s", err)
-		} else {
-			tailN = n
-		}
-	}
+func (api *WebApi) ApiPurgeStatus(c echo.Context) error {
+	r := api.purgeStatus.get()
+	return c.JSON(http.StatusOK, APIPurgeStatusResponse{
+		Result:  r,
+		Running: r != nil && r.CompletedAt == nil,
+	})
+}
 
-	ctx, cancel := context.WithTimeout(c.Request().Context(), APICallTimeout)
-	defer cancel()
-	logs, err := api.runner.Logs(ctx, subdomain, sinceTime, tailN)
-	if err != nil {
-		return http.StatusInternalServerError, nil, err
-	}
-	return http.StatusOK, logs, nil
+func (api *WebApi) ApiSleeping(c echo.Context) error {
+	sls := api.sleeping.list()
+	sort.Slice(sls, func(i, j int) bool {
+		return sls[i].Subdomain < sls[j].Subdomain
+	})
+	return c.JSON(http.StatusOK, APISleepingResponse{Result: lo.Map(sls, func(sl *SleepingSubdomain, _ int) *SleepingSubdomain {
+		return api.cfg.Redactor().SleepingSubdomain(sl)
+	})})
 }
 
 func (api *WebApi) terminate(c echo.Context) (int, error) {
@@ -295,20 +421,89 @@ func (api *WebApi) terminate(c echo.Context) (int, error) {
 
 	ctx, cancel := context.WithTimeout(c.Request().Context(), APICallTimeout)
 	defer cancel()
+	var taskSubdomain string
 	if id != "" {
-		if err := api.runner.Terminate(ctx, id); err != nil {
+		// the cache may not have the task launched just now
+		info, err := api.runner.Describe(ctx, id)
+		if err != nil {
 			return http.StatusInternalServerError, err
 		}
+		if info == nil || info.SubDomain == "" {
+			// only tasks managed by Mirage can be stopped
+			return http.StatusNotFound, fmt.Errorf("task %s is not found", id)
+		}
+		if code, err := api.authorizeTask(ctx, identityOf(c), info); err != nil {
+			return code, err
+		}
+		taskSubdomain = info.SubDomain
+		// the task may be woken up from sleeping, don't wake it up again
+		api.sleeping.delete(ctx, taskSubdomain)
 	} else if subdomain != "" {
-		if err := api.runner.TerminateBySubdomain(ctx, subdomain); err != nil {
+		if code, err := api.authorizeSubdomain(ctx, identityOf(c), subdomain); err != nil {
+			return code, err
+		}
+	}
+	if id != "" {
+		h := newHistoryRecord(HistoryActionTerminate, taskSubdomain, identityOf(c))
+		h.TaskID = id
+		api.cfg.EventBus().Publish(&Event{Type: EventTerminateRequested, Subdomain: taskSubdomain, TaskID: id, Actor: h.Actor})
+		err := api.runner.Terminate(ctx, id)
+		api.putHistory(h, err)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if others, err := api.hasOtherTasks(ctx, taskSubdomain, id); err == nil && !others {
+			// the last task of the subdomain
+			api.cfg.deleteSecrets(ctx, taskSubdomain)
+		}
+	} else if subdomain != "" {
+		api.sleeping.delete(ctx, subdomain)
+		h := newHistoryRecord(HistoryActionTerminate, subdomain, identityOf(c))
+		api.cfg.EventBus().Publish(&Event{Type: EventTerminateRequested, Subdomain: subdomain, Actor: h.Actor})
+		err := api.runner.TerminateBySubdomain(ctx, subdomain)
+		api.putHistory(h, err)
+		if err != nil {
 			return http.StatusInternalServerError, err
 		}
+		api.cfg.deleteSecrets(ctx, subdomain)
 	} else {
 		return http.StatusBadRequest, fmt.Errorf("parameter required: id or subdomain")
 	}
 	return http.StatusOK, nil
 }
 
+func (api *WebApi) extend(c echo.Context) (int, time.Time, error) {
+	r := APIExtendRequest{}
+	if err := c.Bind(&r); err != nil {
+		return http.StatusBadRequest, time.Time{}, err
+	}
+	if r.Subdomain == "" {
+		return http.StatusBadRequest, time.Time{}, fmt.Errorf("parameter required: subdomain")
+	}
+	if r.TTL == "" && r.ExpireAt == "" {
+		return http.StatusBadRequest, time.Time{}, fmt.Errorf("parameter required: ttl or expire_at")
+	}
+	expireAt, err := parseExpiration(r.TTL, r.ExpireAt, time.Now())
+	if err != nil {
+		return http.StatusBadRequest, time.Time{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), APICallTimeout)
+	defer cancel()
+	if code, err := api.authorizeSubdomain(ctx, identityOf(c), r.Subdomain); err != nil {
+		return code, time.Time{}, err
+	}
+	h := newHistoryRecord(HistoryActionExtend, r.Subdomain, identityOf(c))
+	h.Parameters = map[string]string{"expire_at": expireAt.UTC().Format(time.RFC3339)}
+	err = api.runner.SetExpiration(ctx, r.Subdomain, expireAt)
+	api.putHistory(h, err)
+	if err != nil {
+		slog.Error(f("extend failed: %s", err))
+		return http.StatusInternalServerError, time.Time{}, err
+	}
+	return http.StatusOK, expireAt, nil
+}
+
 func (api *WebApi) accessCounter(c echo.Context) (int, int64, int64, error) {
 	subdomain := c.QueryParam("subdomain")
 	duration := c.QueryParam("duration")
@@ -339,7 +534,7 @@ func (api *WebApi) LoadParameter(getFunc func(string) string) (TaskParameter, er
 			continue
 		}
 
-		if v.Rule != "" {
+		if _, isRef := secretReference(param); v.Rule != "" && !(v.IsSecret() && isRef) {
 			if !v.Regexp.MatchString(param) {
 				return nil, fmt.Errorf("parameter %s value is rule error", v.Name)
 			}
@@ -377,85 +572,164 @@ func (api *WebApi) purge(c echo.Context) (int, error) {
 	if err := c.Bind(&r); err != nil {
 		return http.StatusBadRequest, err
 	}
-	excludes := r.Excludes
-	excludeTags := r.ExcludeTags
 	di, err := r.Duration.Int64()
 	if err != nil {
 		msg := fmt.Sprintf("invalid duration %s", r.Duration)
 		slog.Error(msg)
 		return http.StatusBadRequest, errors.New(msg)
 	}
-	mininum := int64(PurgeMinimumDuration.Seconds())
-	if di < mininum {
-		msg := fmt.Sprintf("invalid duration %d (at least %d)", di, mininum)
-		slog.Error(msg)
-		return http.StatusBadRequest, errors.New(msg)
-	}
-
-	excludesMap := make(map[string]struct{}, len(excludes))
-	for _, exclude := range excludes {
-		excludesMap[exclude] = struct{}{}
-	}
-	excludeTagsMap := make(map[string]string, len(excludeTags))
-	for _, excludeTag := range excludeTags {
-		p := strings.SplitN(excludeTag, ":", 2)
-		if len(p) != 2 {
-			msg := fmt.Sprintf("invalid exclude_tags format %s", excludeTag)
-			slog.Error(msg)
-			return http.StatusBadRequest, errors.New(msg)
-		}
-		k, v := p[0], p[1]
-		excludeTagsMap[k] = v
+	opt, err := newPurgeOption(time.Duration(di)*time.Second, r.Excludes, r.ExcludeTags, r.DryRun)
+	if err != nil {
+		slog.Error(err.Error())
+		return http.StatusBadRequest, err
 	}
-	duration := time.Duration(di) * time.Second
 
-	infos, err := api.runner.List(c.Request().Context(), statusRunning)
+	slog.Info(f("purge subdomains: duration=%s, excludes=%v, exclude_tags=%v, dry_run=%t", opt.duration, r.Excludes, r.ExcludeTags, opt.dryRun))
+	terminates, err := api.purgeCandidates(c.Request().Context(), opt)
 	if err != nil {
-		slog.Error(f("list ecs failed: %s", err))
+		slog.Error(err.Error())
 		return http.StatusInternalServerError, err
 	}
-	slog.Info(f("purge subdomains: duration=%s, excludes=%v, exclude_tags=%v", duration, excludes, excludeTags))
-	tm := make(map[string]struct{}, len(infos))
-	for _, info := range infos {
-		if info.ShouldBePurged(duration, excludesMap, excludeTagsMap) {
-			tm[info.SubDomain] = struct{}{}
-		}
-	}
-	terminates := lo.Keys(tm)
-	if len(terminates) > 0 {
-		// running in background. Don't cancel by client context.
-		go api.purgeSubdomains(context.Background(), terminates, duration)
-	}
+	// running in background. Don't cancel by client context.
+	go api.purgeSubdomains(context.Background(), terminates, opt, PurgeTriggerAPI, identityOf(c))
 
 	return http.StatusOK, nil
 }
 
-func (api *WebApi) purgeSubdomains(ctx context.Context, subdomains []string, duration time.Duration) {
+func (api *WebApi) purgeSubdomains(ctx context.Context, subdomains []string, opt *purgeOption, trigger string, actor *Identity) {
 	if api.mu.TryLock() {
 		defer api.mu.Unlock()
 	} else {
 		slog.Info("skip purge subdomains, another purge is running")
 		return
 	}
+	api.purgeStatus.set(newPurgeReport(trigger, actor, opt, subdomains))
+	defer api.purgeStatus.update(func(r *PurgeReport) {
+		now := time.Now()
+		r.CompletedAt = &now
+	})
+
 	slog.Info(f("start purge subdomains %d", len(subdomains)))
 	purged := 0
 	for _, subdomain := range subdomains {
-		sum, err := api.runner.GetAccessCount(ctx, subdomain, duration)
+		sum, err := api.runner.GetAccessCount(ctx, subdomain, opt.duration)
 		if err != nil {
 			slog.Warn(f("access count failed: %s %s", subdomain, err))
+			api.purgeStatus.update(func(r *PurgeReport) {
+				r.Errors[subdomain] = err.Error()
+			})
 			continue
 		}
 		if sum > 0 {
 			slog.Info(f("skip purge %s %d access", subdomain, sum))
+			api.purgeStatus.update(func(r *PurgeReport) {
+				r.Skipped = append(r.Skipped, subdomain)
+			})
+			continue
+		}
+		if opt.dryRun {
+			slog.Info(f("purge %s (dry run)", subdomain))
+			api.purgeStatus.update(func(r *PurgeReport) {
+				r.Purged = append(r.Purged, subdomain)
+			})
 			continue
 		}
-		if err := api.runner.TerminateBySubdomain(ctx, subdomain); err != nil {
+		h := newHistoryRecord(HistoryActionPurge, subdomain, actor)
+		err = api.runner.TerminateBySubdomain(ctx, subdomain)
+		api.putHistory(h, err)
+		if err != nil {
 			slog.Warn(f("terminate failed %s %s", subdomain, err))
+			api.purgeStatus.update(func(r *PurgeReport) {
+				r.Errors[subdomain] = err.Error()
+			})
 		} else {
 			purged++
 			slog.Info(f("purged %s", subdomain))
+			api.cfg.deleteSecrets(ctx, subdomain)
+			api.cfg.EventBus().Publish(&Event{Type: EventPurged, Subdomain: subdomain, Actor: h.Actor})
+			api.purgeStatus.update(func(r *PurgeReport) {
+				r.Purged = append(r.Purged, subdomain)
+			})
 		}
 		time.Sleep(3 * time.Second)
 	}
 	slog.Info(f("purge %d subdomains completed", purged))
 }
+
+// terminateExpired terminates subdomains which have passed their expiration.
+func (api *WebApi) terminateExpired(ctx context.Context) error {
+	infos, err := api.runner.List(ctx, statusRunning)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	api.warnExpiring(infos, now)
+	expired := make(map[string]struct{}, len(infos))
+	for _, info := range infos {
+		if info.Expired(now) {
+			expired[info.SubDomain] = struct{}{}
+		}
+	}
+	for subdomain := range expired {
+		slog.Info(f("subdomain %s is expired. terminating", subdomain))
+		h := newHistoryRecord(HistoryActionExpire, subdomain, SystemIdentity)
+		err := api.runner.TerminateBySubdomain(ctx, subdomain)
+		api.putHistory(h, err)
+		if err != nil {
+			slog.Warn(f("terminate failed %s %s", subdomain, err))
+		} else {
+			api.cfg.deleteSecrets(ctx, subdomain)
+			api.cfg.EventBus().Publish(&Event{Type: EventExpired, Subdomain: subdomain, Actor: h.Actor})
+		}
+	}
+	return nil
+}
+
+// putHistory completes the history record with err and stores it.
+// A failure of the history store doesn't fail the operation.
+func (api *WebApi) putHistory(h *HistoryRecord, err error) {
+	h.complete(err)
+	// don't use the request context, it may be canceled already
+	ctx, cancel := context.WithTimeout(context.Background(), APICallTimeout)
+	defer cancel()
+	if err := api.cfg.HistoryStore().Put(ctx, h); err != nil {
+		slog.Warn(f("failed to put history %s %s: %s", h.Action, h.Subdomain, err))
+	}
+}
+
+func (api *WebApi) history(c echo.Context) (int, []*HistoryRecord, error) {
+	subdomain := c.QueryParam("subdomain")
+	limit := DefaultHistoryLimit
+	if l := c.QueryParam("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			return http.StatusBadRequest, nil, fmt.Errorf("invalid limit %s", l)
+		}
+		limit = min(n, MaxHistoryLimit)
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), APICallTimeout)
+	defer cancel()
+	records, err := api.cfg.HistoryStore().List(ctx, subdomain, limit)
+	if err != nil {
+		slog.Error(f("failed to list history: %s", err))
+		return http.StatusInternalServerError, nil, err
+	}
+	return http.StatusOK, records, nil
+}
+
+func (api *WebApi) ApiHistory(c echo.Context) error {
+	code, records, err := api.history(c)
+	if err != nil {
+		return c.JSON(code, APICommonResponse{Result: err.Error()})
+	}
+	return c.JSON(code, APIHistoryResponse{Result: records})
+}
+
+func (api *WebApi) History(c echo.Context) error {
+	code, records, err := api.history(c)
+	return c.Render(code, "history.html", map[string]interface{}{
+		"history":   records,
+		"subdomain": c.QueryParam("subdomain"),
+		"error":     err,
+	})
+}